@@ -0,0 +1,339 @@
+package controllers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"hotupdate/app/metrics"
+	"hotupdate/app/models"
+)
+
+// 统计数据先在内存中累计，定期写入元数据存储，避免每次检查更新都写一次存储
+const analyticsFlushInterval = 30 * time.Second
+
+var (
+	analyticsMu      sync.Mutex
+	analyticsPending = map[string]map[string]*models.DailyAnalytics{} // 应用ID -> 日期 -> 未写入的统计
+	analyticsCleaned string                                           // 最近一次清理过期统计的日期
+)
+
+// 注册统计查询路由
+func setupAnalyticsRoutes(r *gin.Engine) {
+	viewerAuth := RoleRequired(models.RoleViewer)
+
+	r.GET("/api/apps/:app_id/analytics/adoption", viewerAuth, GetAdoption)
+	r.GET("/api/apps/:app_id/analytics/funnel", viewerAuth, GetUpdateFunnel)
+	r.GET("/api/apps/:app_id/analytics/active", viewerAuth, GetActiveClients)
+
+	go runAnalyticsFlusher()
+}
+
+// GetAdoption 获取每天各版本的活跃设备数（估算值）
+func GetAdoption(c *gin.Context) {
+	days, ok := analyticsDays(c)
+	if !ok {
+		return
+	}
+	history, err := loadAnalytics(c.Param("app_id"), days)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "加载统计数据失败", "app", c.Param("app_id"), "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "无法加载统计数据"})
+		return
+	}
+
+	result := make([]gin.H, 0, len(history))
+	for _, day := range history {
+		result = append(result, gin.H{"date": day.Date, "devices": day.Active.Count(), "versions": sketchCounts(day.Versions)})
+	}
+	c.JSON(http.StatusOK, gin.H{"days": result})
+}
+
+// GetUpdateFunnel 获取各版本的更新漏斗：提供更新、完成下载和上报已应用
+func GetUpdateFunnel(c *gin.Context) {
+	appID := c.Param("app_id")
+	days, ok := analyticsDays(c)
+	if !ok {
+		return
+	}
+
+	versionList, err := models.LoadVersions(MetadataStore, appID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "无法加载版本列表"})
+		return
+	}
+	history, err := loadAnalytics(appID, days)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "加载统计数据失败", "app", c.Param("app_id"), "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "无法加载统计数据"})
+		return
+	}
+
+	offered := map[string]int{}
+	downloaded := map[string]int{}
+	redirected := map[string]int{}
+	applied := map[string]int{}
+	for _, day := range history {
+		for version, count := range day.Offered {
+			offered[version] += count
+		}
+		for version, count := range day.Downloads {
+			downloaded[version] += count
+		}
+		for version, count := range day.Redirects {
+			redirected[version] += count
+		}
+		for version, count := range day.Applied {
+			applied[version] += count
+		}
+	}
+
+	// 按版本从新到旧排列，只包含仍在版本列表中的版本
+	result := make([]gin.H, 0, len(versionList.Versions))
+	for i := len(versionList.Versions) - 1; i >= 0; i-- {
+		id := versionList.Versions[i].ID
+		result = append(result, gin.H{
+			"version":    id,
+			"offered":    offered[id],
+			"downloaded": downloaded[id],
+			"redirected": redirected[id],
+			"applied":    applied[id],
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"versions": result})
+}
+
+// GetActiveClients 获取每天的活跃设备数、检查更新次数和各平台的活跃设备数（设备数为估算值）
+func GetActiveClients(c *gin.Context) {
+	days, ok := analyticsDays(c)
+	if !ok {
+		return
+	}
+	history, err := loadAnalytics(c.Param("app_id"), days)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "加载统计数据失败", "app", c.Param("app_id"), "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "无法加载统计数据"})
+		return
+	}
+
+	result := make([]gin.H, 0, len(history))
+	for _, day := range history {
+		result = append(result, gin.H{"date": day.Date, "devices": day.Active.Count(), "checks": day.Checks, "platforms": sketchCounts(day.Platforms)})
+	}
+	c.JSON(http.StatusOK, gin.H{"days": result})
+}
+
+// 估算各版本或平台的设备数
+func sketchCounts(sketches map[string]models.DeviceSketch) map[string]int {
+	counts := make(map[string]int, len(sketches))
+	for name, sketch := range sketches {
+		counts[name] = sketch.Count()
+	}
+	return counts
+}
+
+// 读取查询参数days，默认30天，最多为统计数据保留的天数。无效时输出错误响应
+func analyticsDays(c *gin.Context) (int, bool) {
+	raw := c.DefaultQuery("days", "30")
+	days, err := strconv.Atoi(raw)
+	if err != nil || days < 1 || days > models.AnalyticsRetentionDays {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("days必须是1到%d之间的整数", models.AnalyticsRetentionDays)})
+		return 0, false
+	}
+	return days, true
+}
+
+// 加载最近days天的统计，包括尚未写入存储的数据，按日期先后排列
+func loadAnalytics(appID string, days int) ([]*models.DailyAnalytics, error) {
+	dates := models.AnalyticsDates(time.Now(), days)
+	history := make([]*models.DailyAnalytics, 0, len(dates))
+	for _, date := range dates {
+		day, err := models.LoadDailyAnalytics(MetadataStore, appID, date)
+		if err != nil {
+			return nil, err
+		}
+		history = append(history, day)
+	}
+
+	analyticsMu.Lock()
+	defer analyticsMu.Unlock()
+	for _, day := range history {
+		if pending, ok := analyticsPending[appID][day.Date]; ok {
+			day.Merge(pending)
+		}
+	}
+	return history, nil
+}
+
+// 记录一次检查更新的结果，offered为提供给客户端的版本（没有更新时为空）
+func recordCheck(c *gin.Context, appID string, outcome string, offered string) {
+	metrics.CheckUpdates.Inc(appID, outcome)
+
+	clientVersion := c.Query("version")
+	deviceID := c.Query("device_id")
+	platform := strings.ToLower(c.Query("platform"))
+
+	analyticsMu.Lock()
+	defer analyticsMu.Unlock()
+	day := pendingAnalytics(appID)
+	day.Checks++
+	if offered != "" {
+		day.Offered[offered]++
+	}
+	if deviceID != "" && clientVersion != "" {
+		day.AddDevice(hashDeviceID(appID, deviceID), clientVersion, platform)
+	}
+}
+
+// 记录一次完整的下载
+func recordDownload(appID string, versionID string) {
+	analyticsMu.Lock()
+	defer analyticsMu.Unlock()
+	pendingAnalytics(appID).Downloads[versionID]++
+}
+
+// 记录一次重定向到对象存储的下载，重定向后无法得知客户端是否下载完成，与完整下载分开统计
+func recordRedirect(appID string, versionID string) {
+	analyticsMu.Lock()
+	defer analyticsMu.Unlock()
+	pendingAnalytics(appID).Redirects[versionID]++
+}
+
+// 记录按设备去重后上报已应用版本的设备数变化，设备改报其他结果时count为负数
+func recordApplied(appID string, versionID string, count int) {
+	if count == 0 {
+		return
+	}
+	analyticsMu.Lock()
+	defer analyticsMu.Unlock()
+	pendingAnalytics(appID).Applied[versionID] += count
+}
+
+// 判断文件是否已完整发送给客户端：200响应写满了Content-Length，或206响应发送到了文件末尾
+func downloadCompleted(c *gin.Context) bool {
+	written := int64(c.Writer.Size())
+	if written <= 0 {
+		return false
+	}
+
+	switch c.Writer.Status() {
+	case http.StatusOK:
+		length, err := strconv.ParseInt(c.Writer.Header().Get("Content-Length"), 10, 64)
+		return err == nil && written == length
+	case http.StatusPartialContent:
+		var start, end, total int64
+		if _, err := fmt.Sscanf(c.Writer.Header().Get("Content-Range"), "bytes %d-%d/%d", &start, &end, &total); err != nil {
+			return false
+		}
+		return end == total-1 && written == end-start+1
+	}
+	return false
+}
+
+// 丢弃应用尚未写入的统计，用于彻底删除应用
+func discardAnalytics(appID string) {
+	analyticsMu.Lock()
+	defer analyticsMu.Unlock()
+	delete(analyticsPending, appID)
+}
+
+// 获取应用当天未写入的统计，调用方需持有analyticsMu
+func pendingAnalytics(appID string) *models.DailyAnalytics {
+	date := time.Now().Format(models.AnalyticsDateLayout)
+	days, ok := analyticsPending[appID]
+	if !ok {
+		days = map[string]*models.DailyAnalytics{}
+		analyticsPending[appID] = days
+	}
+	day, ok := days[date]
+	if !ok {
+		day = models.NewDailyAnalytics(date)
+		days[date] = day
+	}
+	return day
+}
+
+// 设备ID只保存与应用ID一起计算的哈希，不同应用的同一设备得到不同的哈希
+func hashDeviceID(appID string, deviceID string) string {
+	sum := sha256.Sum256([]byte(appID + "\x00" + deviceID))
+	return hex.EncodeToString(sum[:8])
+}
+
+// 定期写入统计数据和客户端上报，并每天清理一次超过保留期的统计
+func runAnalyticsFlusher() {
+	ticker := time.NewTicker(analyticsFlushInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		FlushAnalytics()
+		cleanupAnalytics()
+	}
+}
+
+// FlushAnalytics 立即将内存中累计的客户端上报和统计数据写入存储，服务停止前调用
+func FlushAnalytics() {
+	// 上报写入时会记录已应用的设备数，先写上报，统计中才包含这部分数据
+	flushReports()
+	flushAnalytics()
+}
+
+// 将内存中累计的统计合并到存储中，写入失败的数据放回内存等待下次写入
+func flushAnalytics() {
+	analyticsMu.Lock()
+	pending := analyticsPending
+	analyticsPending = map[string]map[string]*models.DailyAnalytics{}
+	analyticsMu.Unlock()
+
+	for appID, days := range pending {
+		for date, day := range days {
+			err := models.UpdateDailyAnalytics(MetadataStore, appID, date, func(analytics *models.DailyAnalytics) error {
+				analytics.Merge(day)
+				return nil
+			})
+			if err == nil {
+				continue
+			}
+			slog.Error("保存统计数据失败", "app", appID, "date", date, "error", err)
+
+			// 放回时排在之后记录的数据之前
+			analyticsMu.Lock()
+			if analyticsPending[appID] == nil {
+				analyticsPending[appID] = map[string]*models.DailyAnalytics{}
+			}
+			if later, ok := analyticsPending[appID][date]; ok {
+				day.Merge(later)
+			}
+			analyticsPending[appID][date] = day
+			analyticsMu.Unlock()
+		}
+	}
+}
+
+// 删除各应用超过保留期的统计，每天执行一次。按键列出已保存的日期，服务停止多久都能清理干净
+func cleanupAnalytics() {
+	now := time.Now()
+	today := now.Format(models.AnalyticsDateLayout)
+	if analyticsCleaned == today {
+		return
+	}
+	analyticsCleaned = today
+
+	appList, err := models.LoadApps(MetadataStore)
+	if err != nil {
+		slog.Error("清理统计数据时加载应用列表失败", "error", err)
+		return
+	}
+	before := now.AddDate(0, 0, -models.AnalyticsRetentionDays+1).Format(models.AnalyticsDateLayout)
+	for _, app := range appList.Apps {
+		if err := models.DeleteAnalytics(MetadataStore, app.ID, before); err != nil {
+			slog.Error("删除过期统计数据失败", "app", app.ID, "error", err)
+		}
+	}
+}
@@ -0,0 +1,287 @@
+package controllers
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"hotupdate/app/models"
+)
+
+// 注册应用归档、恢复和彻底删除路由
+func setupAppLifecycleRoutes(r *gin.Engine) {
+	ownerAuth := RoleRequired(models.RoleOwner)
+
+	r.DELETE("/api/apps/:app_id", ownerAuth, ArchiveApp)
+	r.POST("/api/apps/:app_id/restore", ownerAuth, RestoreApp)
+	r.POST("/api/apps/:app_id/purge", ownerAuth, PurgeApp)
+}
+
+// 归档的应用仍然可以访问的路由：查看应用信息、恢复和彻底删除
+var archivedAppRoutes = map[string]string{
+	"/api/apps/:app_id":         http.MethodGet,
+	"/api/apps/:app_id/restore": http.MethodPost,
+	"/api/apps/:app_id/purge":   http.MethodPost,
+}
+
+// archivedAppGuard 拦截对已归档应用的访问，客户端检查更新和下载也返回404
+func archivedAppGuard() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		appID := c.Param("app_id")
+		if appID == "" || archivedAppRoutes[c.FullPath()] == c.Request.Method {
+			c.Next()
+			return
+		}
+
+		// 无法确认应用是否已归档时拒绝请求，不能让已归档的应用继续提供更新
+		appList, err := models.LoadApps(MetadataStore)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "加载应用列表失败", "error", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "无法加载应用列表"})
+			return
+		}
+		if app, exists := models.GetApp(appList, appID); exists && app.Archived() {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "应用已归档"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// ArchiveApp 归档应用：客户端不再能检查更新和下载，文件、令牌和角色保留，可以恢复
+func ArchiveApp(c *gin.Context) {
+	appID := c.Param("app_id")
+
+	// 不允许归档默认应用
+	if appID == "default" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不能删除默认应用"})
+		return
+	}
+
+	var app models.App
+	err := models.UpdateApps(MetadataStore, func(appList *models.AppList) error {
+		var exists bool
+		app, exists = models.GetApp(appList, appID)
+		if !exists {
+			return newRequestError(http.StatusNotFound, "应用不存在")
+		}
+		if app.Archived() {
+			return newRequestError(http.StatusConflict, "应用已归档")
+		}
+
+		now := time.Now()
+		app.ArchivedAt = &now
+		app.ArchivedBy = c.GetString("username")
+		app.UpdatedAt = now
+		models.AddApp(appList, app)
+		return nil
+	})
+	if err != nil {
+		respondError(c, err, "保存应用列表失败")
+		return
+	}
+
+	slog.InfoContext(c.Request.Context(), "已归档应用", "app", appID, "operator", c.GetString("username"))
+	c.JSON(http.StatusOK, gin.H{"message": "应用已归档", "app": app})
+}
+
+// RestoreApp 恢复已归档的应用
+func RestoreApp(c *gin.Context) {
+	appID := c.Param("app_id")
+
+	var app models.App
+	err := models.UpdateApps(MetadataStore, func(appList *models.AppList) error {
+		var exists bool
+		app, exists = models.GetApp(appList, appID)
+		if !exists {
+			return newRequestError(http.StatusNotFound, "应用不存在")
+		}
+		if !app.Archived() {
+			return newRequestError(http.StatusConflict, "应用未归档")
+		}
+
+		app.ArchivedAt = nil
+		app.ArchivedBy = ""
+		app.UpdatedAt = time.Now()
+		models.AddApp(appList, app)
+		return nil
+	})
+	if err != nil {
+		respondError(c, err, "保存应用列表失败")
+		return
+	}
+
+	slog.InfoContext(c.Request.Context(), "已恢复应用", "app", appID, "operator", c.GetString("username"))
+	c.JSON(http.StatusOK, gin.H{"message": "应用已恢复", "app": app})
+}
+
+// PurgeApp 彻底删除已归档的应用：删除全部版本文件和元数据，吊销令牌并撤销角色。
+// 需要在confirm中再次填写应用ID，删除后不能恢复
+func PurgeApp(c *gin.Context) {
+	appID := c.Param("app_id")
+
+	if c.PostForm("confirm") != appID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请在confirm中填写应用ID以确认彻底删除"})
+		return
+	}
+
+	appList, err := models.LoadApps(MetadataStore)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "无法加载应用列表"})
+		return
+	}
+	app, exists := models.GetApp(appList, appID)
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "应用不存在"})
+		return
+	}
+	if !app.Archived() {
+		c.JSON(http.StatusConflict, gin.H{"error": "只能彻底删除已归档的应用，请先归档"})
+		return
+	}
+
+	// 先删除元数据，再删除文件：中途失败时不会留下指向已删除文件的版本
+	versionList, err := models.LoadVersions(MetadataStore, appID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "无法加载版本列表"})
+		return
+	}
+	if err := MetadataStore.Delete(models.VersionsKey(appID)); err != nil {
+		slog.ErrorContext(c.Request.Context(), "删除版本列表失败", "app", appID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "无法删除版本列表"})
+		return
+	}
+	for _, version := range versionList.Versions {
+		if err := models.DeleteFileManifests(MetadataStore, appID, version); err != nil {
+			slog.ErrorContext(c.Request.Context(), "删除文件清单失败", "app", appID, "version", version.ID, "error", err)
+		}
+		if err := models.DeleteReportDevices(MetadataStore, appID, version.ID); err != nil {
+			slog.ErrorContext(c.Request.Context(), "删除上报设备失败", "app", appID, "version", version.ID, "error", err)
+		}
+	}
+	discardAnalytics(appID)
+	discardReports(appID)
+	if err := models.DeleteAnalytics(MetadataStore, appID, ""); err != nil {
+		slog.ErrorContext(c.Request.Context(), "删除统计数据失败", "app", appID, "error", err)
+	}
+	purgeAppUploads(c.Request.Context(), appID)
+	purgeAppFiles(c.Request.Context(), appID)
+
+	// 吊销该应用的全部API令牌，避免同名应用重建后旧令牌继续生效
+	err = models.UpdateTokens(MetadataStore, func(tokenList *models.TokenList) error {
+		models.RevokeAppTokens(tokenList, appID, time.Now())
+		return nil
+	})
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "吊销应用令牌失败", "app", appID, "error", err)
+	}
+
+	// 撤销用户在该应用上的角色
+	err = models.UpdateUsers(MetadataStore, func(userList *models.UserList) error {
+		models.RemoveAppRoles(userList, appID)
+		return nil
+	})
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "撤销应用角色失败", "app", appID, "error", err)
+	}
+
+	// 最后从应用列表中移除，之后才能重新创建同ID的应用
+	err = models.UpdateApps(MetadataStore, func(appList *models.AppList) error {
+		models.DeleteApp(appList, appID)
+		return nil
+	})
+	if err != nil {
+		respondError(c, err, "保存应用列表失败")
+		return
+	}
+
+	slog.InfoContext(c.Request.Context(), "已彻底删除应用", "app", appID, "operator", c.GetString("username"))
+	c.JSON(http.StatusOK, gin.H{"message": "应用已彻底删除"})
+}
+
+// 删除应用未完成的上传会话及其临时文件
+func purgeAppUploads(ctx context.Context, appID string) {
+	sessionList, err := models.LoadUploadSessions(MetadataStore)
+	if err != nil {
+		slog.ErrorContext(ctx, "加载上传会话失败", "error", err)
+		return
+	}
+	for _, session := range sessionList.Sessions {
+		if session.AppID == appID {
+			removeUploadSession(ctx, session.ID)
+		}
+	}
+}
+
+// 删除应用目录下的全部文件
+func purgeAppFiles(ctx context.Context, appID string) {
+	objects, err := FileStorage.List(ctx, models.GetArtifactKey(appID, "")+"/")
+	if err != nil {
+		slog.ErrorContext(ctx, "列出应用文件失败", "app", appID, "error", err)
+		return
+	}
+	for _, object := range objects {
+		if err := FileStorage.Delete(ctx, object.Key); err != nil {
+			slog.ErrorContext(ctx, "删除文件失败", "key", object.Key, "error", err)
+		}
+	}
+
+	// 本地上传目录中可能还留有JSON元数据存储的空目录
+	if err := os.RemoveAll(filepath.Join(UploadDir, "apps", appID)); err != nil {
+		slog.ErrorContext(ctx, "删除应用目录失败", "app", appID, "error", err)
+	}
+}
+
+// 读取应用的更新包规则，表单字段required_files和allowed_files为逗号或换行分隔的路径模式，
+// 只替换请求中提供的字段，其余沿用current。两个字段都没有提供时返回false；规则全部为空时返回nil
+func requestPackageRules(c *gin.Context, current *models.PackageRules) (*models.PackageRules, bool, error) {
+	required, setRequired := c.GetPostForm("required_files")
+	allowed, setAllowed := c.GetPostForm("allowed_files")
+	if !setRequired && !setAllowed {
+		return current, false, nil
+	}
+
+	rules := &models.PackageRules{}
+	if current != nil {
+		*rules = *current
+	}
+	if setRequired {
+		rules.Required = splitPatterns(required)
+	}
+	if setAllowed {
+		rules.Allowed = splitPatterns(allowed)
+	}
+	if rules.Empty() {
+		return nil, true, nil
+	}
+	if err := rules.Validate(); err != nil {
+		return nil, true, err
+	}
+	return rules, true, nil
+}
+
+// 按逗号或换行拆分路径模式，忽略空白
+func splitPatterns(value string) []string {
+	var patterns []string
+	for _, pattern := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == '\n' }) {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns
+}
+
+// 输出更新包校验失败的响应，包含错误码和出错的包内路径
+func respondPackageError(c *gin.Context, err *models.PackageError) {
+	body := gin.H{"error": err.Message, "code": err.Code}
+	if err.Entry != "" {
+		body["entry"] = err.Entry
+	}
+	c.JSON(http.StatusBadRequest, body)
+}
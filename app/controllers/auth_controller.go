@@ -0,0 +1,381 @@
+package controllers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+
+	"hotupdate/app/models"
+	"hotupdate/app/store"
+)
+
+const (
+	// SessionCookieName 登录会话Cookie名称
+	SessionCookieName = "hotupdate_session"
+	// 默认会话有效期
+	defaultSessionTTL = 12 * time.Hour
+)
+
+// session 表示一个已登录用户的会话
+type session struct {
+	Username  string
+	ExpiresAt time.Time
+}
+
+var (
+	sessionTTL = defaultSessionTTL
+
+	sessions   = make(map[string]session)
+	sessionsMu sync.Mutex
+
+	// 用于用户不存在时的哈希比较，使登录失败的响应时间一致
+	dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("hotupdate"), bcrypt.DefaultCost)
+)
+
+// SetupAuthController 设置认证控制器，配置文件中的管理员账号会在用户列表为空时作为初始所有者导入
+func SetupAuthController(r *gin.Engine, metadataStore store.Store, bootstrapUsername string, bootstrapPasswordHash string, ttl time.Duration) {
+	MetadataStore = metadataStore
+	if ttl > 0 {
+		sessionTTL = ttl
+	}
+
+	ensureBootstrapUser(bootstrapUsername, bootstrapPasswordHash)
+
+	// 登录页面
+	r.GET("/login", LoginPage)
+
+	// 认证API
+	r.POST("/api/login", Login)
+	r.POST("/api/logout", Logout)
+	r.GET("/api/session", sessionAuth(true), SessionInfo)
+	r.POST("/api/password", sessionAuth(true), ChangePassword)
+
+	// 用户管理API
+	setupUserRoutes(r)
+}
+
+// 用户列表为空时，导入配置文件中的管理员作为全局所有者。没有配置密码哈希时生成一次性初始密码，
+// 只输出到标准错误而不写入日志文件，首次登录后必须修改
+func ensureBootstrapUser(username string, passwordHash string) {
+	var initialPassword string
+	err := models.UpdateUsers(MetadataStore, func(userList *models.UserList) error {
+		if len(userList.Users) > 0 {
+			return errNoChange
+		}
+
+		if username == "" {
+			slog.Warn("用户列表为空且未配置管理员账号，管理接口将无法登录")
+			return errNoChange
+		}
+
+		// 没有配置密码哈希时生成随机的初始密码，不使用任何默认密码
+		if passwordHash == "" {
+			password, hash, err := generateInitialPassword()
+			if err != nil {
+				return err
+			}
+			initialPassword, passwordHash = password, hash
+		}
+
+		now := time.Now()
+		models.AddUser(userList, models.User{
+			Username:           username,
+			PasswordHash:       passwordHash,
+			Roles:              map[string]models.Role{models.AllApps: models.RoleOwner},
+			MustChangePassword: initialPassword != "",
+			CreatedAt:          now,
+			UpdatedAt:          now,
+		})
+		return nil
+	})
+	if errors.Is(err, errNoChange) {
+		return
+	}
+	if err != nil {
+		slog.Error("保存用户列表失败", "error", err)
+		return
+	}
+
+	if initialPassword != "" {
+		fmt.Fprintf(os.Stderr, "\n管理员 %s 的初始密码: %s\n此密码只显示一次，首次登录后必须修改\n\n", username, initialPassword)
+		slog.Warn("未配置管理员密码哈希，已生成一次性初始密码并输出到标准错误，首次登录后必须修改密码", "user", username)
+		return
+	}
+	slog.Info("已从配置文件导入管理员账号", "user", username)
+}
+
+// 生成随机的初始密码及其bcrypt哈希
+func generateInitialPassword() (string, string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	password := base64.RawURLEncoding.EncodeToString(b)
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", "", err
+	}
+	return password, string(hash), nil
+}
+
+// LoginPage 登录页面
+func LoginPage(c *gin.Context) {
+	// 已登录则直接进入管理界面，必须修改初始密码的用户留在登录页重新登录后修改
+	if user, ok := currentUser(c); ok && !user.MustChangePassword {
+		c.Redirect(http.StatusFound, "/admin")
+		return
+	}
+
+	c.HTML(http.StatusOK, "login.html", gin.H{
+		"title": "登录 - 多项目热更新管理系统",
+	})
+}
+
+// Login 用户登录，成功后签发会话Cookie
+func Login(c *gin.Context) {
+	username := c.PostForm("username")
+	password := c.PostForm("password")
+
+	if username == "" || password == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "用户名和密码不能为空"})
+		return
+	}
+
+	user, ok := checkCredentials(c.Request.Context(), username, password)
+	if !ok {
+		slog.WarnContext(c.Request.Context(), "用户登录失败", "user", username, "ip", c.ClientIP())
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "用户名或密码错误"})
+		return
+	}
+
+	token, err := randomHex(32)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "无法创建会话"})
+		return
+	}
+
+	expiresAt := time.Now().Add(sessionTTL)
+	sessionsMu.Lock()
+	purgeExpiredSessionsLocked()
+	sessions[token] = session{Username: username, ExpiresAt: expiresAt}
+	sessionsMu.Unlock()
+
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(SessionCookieName, token, int(sessionTTL.Seconds()), "/", "", isSecureRequest(c), true)
+
+	slog.InfoContext(c.Request.Context(), "用户登录成功", "user", username, "ip", c.ClientIP())
+	c.JSON(http.StatusOK, gin.H{
+		"message":            "登录成功",
+		"username":           username,
+		"expiresAt":          expiresAt,
+		"mustChangePassword": user.MustChangePassword,
+	})
+}
+
+// Logout 注销当前会话
+func Logout(c *gin.Context) {
+	if token, err := c.Cookie(SessionCookieName); err == nil && token != "" {
+		sessionsMu.Lock()
+		delete(sessions, token)
+		sessionsMu.Unlock()
+	}
+
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(SessionCookieName, "", -1, "/", "", isSecureRequest(c), true)
+	c.JSON(http.StatusOK, gin.H{"message": "已退出登录"})
+}
+
+// SessionInfo 返回当前登录用户信息
+func SessionInfo(c *gin.Context) {
+	user, _ := currentUser(c)
+	c.JSON(http.StatusOK, gin.H{
+		"username":           user.Username,
+		"roles":              user.Roles,
+		"mustChangePassword": user.MustChangePassword,
+	})
+}
+
+// AuthRequired API认证中间件，要求已登录，未登录时返回401，尚未修改初始密码时返回403
+func AuthRequired() gin.HandlerFunc {
+	return sessionAuth(false)
+}
+
+// 登录会话认证中间件。allowInitialPassword为true时尚未修改初始密码的用户也可以访问，
+// 只用于查看会话和修改密码
+func sessionAuth(allowInitialPassword bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := currentUser(c)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "未登录或会话已过期"})
+			return
+		}
+		if user.MustChangePassword && !allowInitialPassword {
+			abortMustChangePassword(c)
+			return
+		}
+		c.Set("username", user.Username)
+		c.Set("user", user)
+		c.Next()
+	}
+}
+
+// RoleRequired 角色认证中间件，要求调用者在路由中的应用上具有不低于所需的角色。
+// 接受登录会话或属于该应用的Bearer令牌；路由中没有app_id时只认可全局授权。
+func RoleRequired(required models.Role) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		appID := c.Param("app_id")
+
+		if rawToken := bearerToken(c); rawToken != "" {
+			token, status, message := authenticateToken(c.Request.Context(), rawToken, appID, models.RequiredTokenPermission(required))
+			if status != http.StatusOK {
+				c.AbortWithStatusJSON(status, gin.H{"error": message})
+				return
+			}
+			// 令牌调用方没有用户名，以令牌ID记录发布者、撤回者等操作人。":"不是合法的用户名字符，不会与用户混淆
+			c.Set("tokenID", token.ID)
+			c.Set("username", "token:"+token.ID)
+			c.Set("role", token.Permission.Role())
+			c.Next()
+			return
+		}
+
+		user, ok := currentUser(c)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "未登录或会话已过期"})
+			return
+		}
+		if user.MustChangePassword {
+			abortMustChangePassword(c)
+			return
+		}
+
+		role := user.RoleFor(appID)
+		if !role.AtLeast(required) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "权限不足，需要角色: " + string(required)})
+			return
+		}
+
+		c.Set("username", user.Username)
+		c.Set("user", user)
+		c.Set("role", role)
+		c.Next()
+	}
+}
+
+// PageAuthRequired 页面认证中间件，未登录时重定向到登录页
+func PageAuthRequired() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := currentUser(c)
+		if !ok || user.MustChangePassword {
+			c.Redirect(http.StatusFound, "/login")
+			c.Abort()
+			return
+		}
+		c.Set("username", user.Username)
+		c.Set("user", user)
+		c.Next()
+	}
+}
+
+// 拒绝尚未修改初始密码的用户访问
+func abortMustChangePassword(c *gin.Context) {
+	c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "请先修改初始密码", "mustChangePassword": true})
+}
+
+// 获取经过RoleRequired中间件确认的调用者角色
+func callerRole(c *gin.Context) models.Role {
+	if role, ok := c.Get("role"); ok {
+		return role.(models.Role)
+	}
+	return ""
+}
+
+// 获取请求对应的已登录用户，会话过期或用户被禁用、删除时返回false
+func currentUser(c *gin.Context) (models.User, bool) {
+	token, err := c.Cookie(SessionCookieName)
+	if err != nil || token == "" {
+		return models.User{}, false
+	}
+
+	sessionsMu.Lock()
+	s, ok := sessions[token]
+	if ok && time.Now().After(s.ExpiresAt) {
+		delete(sessions, token)
+		ok = false
+	}
+	sessionsMu.Unlock()
+
+	if !ok {
+		return models.User{}, false
+	}
+
+	userList, err := models.LoadUsers(MetadataStore)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "加载用户列表失败", "error", err)
+		return models.User{}, false
+	}
+
+	user, exists := models.GetUser(userList, s.Username)
+	if !exists || user.Disabled {
+		return models.User{}, false
+	}
+	return user, true
+}
+
+// 校验用户名和密码，成功时返回该用户
+func checkCredentials(ctx context.Context, username, password string) (models.User, bool) {
+	userList, err := models.LoadUsers(MetadataStore)
+	if err != nil {
+		slog.ErrorContext(ctx, "加载用户列表失败", "error", err)
+		return models.User{}, false
+	}
+
+	user, exists := models.GetUser(userList, username)
+	if !exists || user.Disabled || user.PasswordHash == "" {
+		// 用户不存在时也执行一次哈希比较，避免通过响应时间猜测用户名
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+		return models.User{}, false
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+		return models.User{}, false
+	}
+	return user, true
+}
+
+// 删除指定用户的全部会话，except为保留的会话令牌（如当前请求的会话），为空时全部删除
+func revokeUserSessions(username string, except string) {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+
+	for token, s := range sessions {
+		if s.Username == username && token != except {
+			delete(sessions, token)
+		}
+	}
+}
+
+// 清理已过期的会话，调用方需持有sessionsMu
+func purgeExpiredSessionsLocked() {
+	now := time.Now()
+	for token, s := range sessions {
+		if now.After(s.ExpiresAt) {
+			delete(sessions, token)
+		}
+	}
+}
+
+// 判断请求是否通过HTTPS到达（包括反向代理转发的情况）
+func isSecureRequest(c *gin.Context) bool {
+	return c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https"
+}
@@ -0,0 +1,160 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"hotupdate/app/models"
+)
+
+// 发送表单请求，cookie不为空时附带会话Cookie
+func postForm(target string, form url.Values, cookie *http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	testServer.ServeHTTP(w, req)
+	return w
+}
+
+// 使用初始密码登录后，修改密码之前只能查看会话和修改密码
+func TestInitialPasswordMustBeChanged(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("initial-password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = models.UpdateUsers(MetadataStore, func(userList *models.UserList) error {
+		models.AddUser(userList, models.User{
+			Username:           "initial",
+			PasswordHash:       string(hash),
+			Roles:              map[string]models.Role{models.AllApps: models.RoleOwner},
+			MustChangePassword: true,
+			CreatedAt:          time.Now(),
+			UpdatedAt:          time.Now(),
+		})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := postForm("/api/login", url.Values{"username": {"initial"}, "password": {"initial-password"}}, nil)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"mustChangePassword":true`) {
+		t.Fatalf("登录返回 %d: %s", w.Code, w.Body.String())
+	}
+	var cookie *http.Cookie
+	for _, c := range w.Result().Cookies() {
+		if c.Name == SessionCookieName {
+			cookie = c
+		}
+	}
+	if cookie == nil {
+		t.Fatal("登录后没有会话Cookie")
+	}
+	get := func(target string) int {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.AddCookie(cookie)
+		w := httptest.NewRecorder()
+		testServer.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	for _, target := range []string{"/api/users", "/api/apps"} {
+		if code := get(target); code != http.StatusForbidden {
+			t.Errorf("修改初始密码前访问 %s 返回 %d，期望403", target, code)
+		}
+	}
+	if code := get("/api/session"); code != http.StatusOK {
+		t.Errorf("修改初始密码前查看会话返回 %d", code)
+	}
+
+	if w := postForm("/api/password", url.Values{"current_password": {"wrong-password"}, "new_password": {"new-password"}}, cookie); w.Code != http.StatusBadRequest {
+		t.Errorf("当前密码错误时返回 %d", w.Code)
+	}
+	if w := postForm("/api/password", url.Values{"current_password": {"initial-password"}, "new_password": {"new-password"}}, cookie); w.Code != http.StatusOK {
+		t.Fatalf("修改密码返回 %d: %s", w.Code, w.Body.String())
+	}
+
+	if code := get("/api/users"); code != http.StatusOK {
+		t.Errorf("修改密码后访问用户列表返回 %d", code)
+	}
+	if w := postForm("/api/login", url.Values{"username": {"initial"}, "password": {"initial-password"}}, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("修改密码后使用初始密码登录返回 %d", w.Code)
+	}
+}
+
+// 创建一个全局所有者并登录，返回会话Cookie
+func testLogin(t *testing.T, username string) *http.Cookie {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte("test-password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = models.UpdateUsers(MetadataStore, func(userList *models.UserList) error {
+		models.AddUser(userList, models.User{
+			Username:     username,
+			PasswordHash: string(hash),
+			Roles:        map[string]models.Role{models.AllApps: models.RoleOwner},
+			CreatedAt:    time.Now(),
+			UpdatedAt:    time.Now(),
+		})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := postForm("/api/login", url.Values{"username": {username}, "password": {"test-password"}}, nil)
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == SessionCookieName {
+			return cookie
+		}
+	}
+	t.Fatalf("登录返回 %d: %s", w.Code, w.Body.String())
+	return nil
+}
+
+// 使用API令牌的操作以令牌ID记录操作人
+func TestTokenCallerRecordedAsOperator(t *testing.T) {
+	addTestVersion(t, "token-operator", "2.0.0", testArtifactData(1024))
+	err := models.UpdateTokens(MetadataStore, func(tokenList *models.TokenList) error {
+		models.AddToken(tokenList, models.APIToken{
+			ID:         "operator",
+			AppID:      "token-operator",
+			Name:       "ci",
+			Permission: models.TokenPermissionAdmin,
+			SecretHash: models.HashTokenSecret("secret"),
+			CreatedAt:  time.Now(),
+		})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/apps/token-operator/versions/2.0.0/yank", strings.NewReader("reason=broken"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "Bearer "+tokenPrefix+"operator_secret")
+	w := httptest.NewRecorder()
+	testServer.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("使用令牌撤回版本返回 %d: %s", w.Code, w.Body.String())
+	}
+
+	versionList, err := models.LoadVersions(MetadataStore, "token-operator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	version, _ := models.GetVersion(versionList, "2.0.0")
+	if version.Yanked == nil || version.Yanked.YankedBy != "token:operator" {
+		t.Errorf("撤回记录为 %+v，期望操作人为token:operator", version.Yanked)
+	}
+}
@@ -0,0 +1,228 @@
+package controllers
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"hotupdate/app/models"
+)
+
+// 渠道名称格式：小写字母开头，可包含小写字母、数字、横线和下划线
+var channelNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,31}$`)
+
+// 注册发布渠道路由
+func setupChannelRoutes(r *gin.Engine) {
+	r.GET("/api/apps/:app_id/channels", RoleRequired(models.RoleViewer), ListChannels)
+	r.POST("/api/apps/:app_id/channels", RoleRequired(models.RoleOwner), CreateChannel)
+	r.DELETE("/api/apps/:app_id/channels/:channel", RoleRequired(models.RoleOwner), DeleteChannel)
+	r.POST("/api/apps/:app_id/channels/:channel/promote", RoleRequired(models.RoleReleaseManager), PromoteVersion)
+}
+
+// ListChannels 列出应用的发布渠道
+func ListChannels(c *gin.Context) {
+	appID := c.Param("app_id")
+
+	if !appExists(c, appID) {
+		return
+	}
+
+	versionList, err := models.LoadVersions(MetadataStore, appID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "无法加载版本列表"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"channels": versionList.Channels})
+}
+
+// CreateChannel 创建发布渠道，新渠道没有版本，通过推广或指定渠道上传加入版本
+func CreateChannel(c *gin.Context) {
+	appID := c.Param("app_id")
+	name := c.PostForm("name")
+
+	if !channelNamePattern.MatchString(name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "渠道名称格式无效，需以小写字母开头，只能包含小写字母、数字、横线和下划线"})
+		return
+	}
+
+	if !appExists(c, appID) {
+		return
+	}
+
+	now := time.Now()
+	channel := models.Channel{
+		Name:        name,
+		Description: c.PostForm("description"),
+		Versions:    []string{},
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	err := models.UpdateVersions(MetadataStore, appID, func(versionList *models.VersionList) error {
+		if !models.AddChannel(versionList, channel) {
+			return newRequestError(http.StatusConflict, "渠道已存在")
+		}
+		return nil
+	})
+	if err != nil {
+		respondError(c, err, "无法保存渠道")
+		return
+	}
+
+	slog.InfoContext(c.Request.Context(), "已创建渠道", "app", appID, "channel", name, "operator", c.GetString("username"))
+	c.JSON(http.StatusOK, gin.H{"message": "渠道创建成功", "channel": channel})
+}
+
+// DeleteChannel 删除发布渠道，渠道中的版本仍保留在版本列表和其他渠道中
+func DeleteChannel(c *gin.Context) {
+	appID := c.Param("app_id")
+	name := c.Param("channel")
+
+	if name == models.DefaultChannel {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不能删除默认渠道"})
+		return
+	}
+
+	err := models.UpdateVersions(MetadataStore, appID, func(versionList *models.VersionList) error {
+		if _, exists := models.GetChannel(versionList, name); !exists {
+			return newRequestError(http.StatusNotFound, "渠道不存在")
+		}
+		models.DeleteChannel(versionList, name)
+		return nil
+	})
+	if err != nil {
+		respondError(c, err, "无法保存渠道")
+		return
+	}
+
+	slog.InfoContext(c.Request.Context(), "已删除渠道", "app", appID, "channel", name, "operator", c.GetString("username"))
+	c.JSON(http.StatusOK, gin.H{"message": "渠道删除成功"})
+}
+
+// PromoteVersion 将版本推广到指定渠道，例如把beta中验证过的版本推广到stable。
+// 提供from参数时，版本必须已在该渠道中
+func PromoteVersion(c *gin.Context) {
+	appID := c.Param("app_id")
+	target := c.Param("channel")
+	versionID := c.PostForm("version_id")
+	source := c.PostForm("from")
+
+	if versionID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "版本ID不能为空"})
+		return
+	}
+
+	var promoted models.Channel
+	err := models.UpdateVersions(MetadataStore, appID, func(versionList *models.VersionList) error {
+		version, exists := models.GetVersion(versionList, versionID)
+		if !exists {
+			return newRequestError(http.StatusNotFound, "版本不存在")
+		}
+
+		if source != "" {
+			sourceChannel, exists := models.GetChannel(versionList, source)
+			if !exists {
+				return newRequestError(http.StatusNotFound, "来源渠道不存在")
+			}
+			if !sourceChannel.Contains(versionID) {
+				return newRequestError(http.StatusBadRequest, "版本不在来源渠道中")
+			}
+		}
+
+		targetChannel, exists := models.GetChannel(versionList, target)
+		if !exists {
+			return newRequestError(http.StatusNotFound, "渠道不存在")
+		}
+		if targetChannel.Contains(versionID) {
+			return newRequestError(http.StatusConflict, "版本已在该渠道中")
+		}
+		if missing := missingPredecessors(versionList, targetChannel, version); len(missing) > 0 {
+			return newRequestError(http.StatusConflict, fmt.Sprintf("非累积版本依赖的版本 %s 不在渠道 %s 中，请先推广这些版本，或改为推广累积版本", strings.Join(missing, "、"), target))
+		}
+
+		models.AddToChannel(versionList, target, versionID)
+		promoted, _ = models.GetChannel(versionList, target)
+		return nil
+	})
+	if err != nil {
+		respondError(c, err, "无法保存渠道")
+		return
+	}
+
+	if source != "" {
+		slog.InfoContext(c.Request.Context(), "已推广版本", "app", appID, "version", versionID, "from_channel", source, "channel", target, "operator", c.GetString("username"))
+	} else {
+		slog.InfoContext(c.Request.Context(), "已推广版本", "app", appID, "version", versionID, "channel", target, "operator", c.GetString("username"))
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "版本推广成功", "channel": promoted})
+}
+
+// 获取非累积版本所依赖、但不在渠道中的版本：上传顺序中在它之前、直到最近一个累积版本（含）的未撤回版本。
+// 缺少这些版本时，渠道中的客户端会跳过它们的改动直接更新到该版本
+func missingPredecessors(versionList *models.VersionList, channel models.Channel, version models.Version) []string {
+	if version.Cumulative {
+		return nil
+	}
+
+	var missing []string
+	before := false
+	for i := len(versionList.Versions) - 1; i >= 0; i-- {
+		previous := versionList.Versions[i]
+		if previous.ID == version.ID {
+			before = true
+			continue
+		}
+		if !before || channel.YankOf(previous) != nil {
+			continue
+		}
+		if !channel.Contains(previous.ID) {
+			missing = append(missing, previous.ID)
+		}
+		if previous.Cumulative {
+			break
+		}
+	}
+	slices.Reverse(missing)
+	return missing
+}
+
+// 获取请求指定的渠道，未指定时使用默认渠道。渠道不存在时输出错误响应
+func requestChannel(c *gin.Context, appID string, name string) (string, bool) {
+	if name == "" {
+		return models.DefaultChannel, true
+	}
+
+	versionList, err := models.LoadVersions(MetadataStore, appID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "无法加载版本列表"})
+		return "", false
+	}
+
+	if _, exists := models.GetChannel(versionList, name); !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "渠道不存在"})
+		return "", false
+	}
+	return name, true
+}
+
+// 确认应用存在，不存在时输出错误响应
+func appExists(c *gin.Context, appID string) bool {
+	appList, err := models.LoadApps(MetadataStore)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "无法加载应用列表"})
+		return false
+	}
+
+	if _, exists := models.GetApp(appList, appID); !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "应用不存在"})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,78 @@
+package controllers
+
+import (
+	"slices"
+	"testing"
+
+	"hotupdate/app/models"
+)
+
+func TestMissingPredecessors(t *testing.T) {
+	versionList := &models.VersionList{
+		Versions: []models.Version{
+			{ID: "1.0.0", Cumulative: true},
+			{ID: "1.1.0"},
+			{ID: "1.2.0", Cumulative: true},
+			{ID: "1.3.0"},
+			{ID: "1.4.0", Yanked: &models.Yank{Reason: "崩溃"}},
+			{ID: "1.5.0"},
+		},
+	}
+	version := func(id string) models.Version {
+		v, _ := models.GetVersion(versionList, id)
+		return v
+	}
+
+	tests := []struct {
+		channel []string
+		version string
+		want    []string
+	}{
+		// 非累积版本依赖之前直到最近一个累积版本的全部版本，已撤回的版本除外
+		{[]string{"1.0.0"}, "1.5.0", []string{"1.2.0", "1.3.0"}},
+		{[]string{"1.2.0"}, "1.5.0", []string{"1.3.0"}},
+		{[]string{"1.2.0", "1.3.0"}, "1.5.0", nil},
+		{nil, "1.1.0", []string{"1.0.0"}},
+		// 累积版本可以推广到任何渠道
+		{nil, "1.2.0", nil},
+	}
+	for _, tt := range tests {
+		channel := models.Channel{Name: "stable", Versions: tt.channel}
+		if got := missingPredecessors(versionList, channel, version(tt.version)); !slices.Equal(got, tt.want) {
+			t.Errorf("渠道 %v 推广 %s 缺少 %v，期望 %v", tt.channel, tt.version, got, tt.want)
+		}
+	}
+}
+
+// 新版本只需大于目标渠道中的版本，其他渠道的先行版本不影响正式渠道的热修复
+func TestValidateNewVersionPerChannel(t *testing.T) {
+	versionList := &models.VersionList{
+		Versions: []models.Version{{ID: "1.0.0"}, {ID: "1.1.0-beta.1"}},
+		Channels: []models.Channel{
+			{Name: "stable", Versions: []string{"1.0.0"}},
+			{Name: "beta", Versions: []string{"1.0.0", "1.1.0-beta.1"}},
+		},
+	}
+	scheme, _ := models.GetVersionScheme(models.VersionSchemeSemver)
+
+	tests := []struct {
+		channel string
+		version string
+		ok      bool
+	}{
+		{"stable", "1.0.1", true},
+		{"stable", "1.1.0", true},
+		{"stable", "0.9.0", false},
+		{"beta", "1.0.1", false},
+		{"beta", "1.1.0-beta.2", true},
+		// 版本ID在所有渠道中唯一
+		{"stable", "1.1.0-beta.1", false},
+		{"stable", "1.0.0", false},
+	}
+	for _, tt := range tests {
+		err := validateNewVersion(scheme, versionList, tt.channel, tt.version)
+		if (err == nil) != tt.ok {
+			t.Errorf("向 %s 发布 %s: %v，期望通过 %v", tt.channel, tt.version, err, tt.ok)
+		}
+	}
+}
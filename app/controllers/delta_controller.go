@@ -0,0 +1,237 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"hotupdate/app/models"
+	"hotupdate/app/zipdelta"
+)
+
+const (
+	// 对一个变化的条目做bsdiff最多使用的内存。bsdiff需要把条目的新旧数据都读入内存，
+	// 并为旧数据建立后缀数组，估算方法见zipdelta.DiffMemory，新旧条目都为24MB左右时达到上限，
+	// 更大的条目在差分包中直接写入完整数据
+	maxDeltaMemory = 512 << 20
+	// 等待生成的差分任务上限，超出时新任务标记为跳过
+	deltaQueueSize = 64
+)
+
+// deltaJob 一个差分包生成任务
+type deltaJob struct {
+	appID       string
+	fromVersion string
+	toVersion   string
+}
+
+// 差分任务队列，由单个后台协程顺序处理，避免并发生成占用过多内存和CPU
+var deltaJobs = make(chan deltaJob, deltaQueueSize)
+
+// 注册差分包路由并启动后台生成协程
+func setupDeltaRoutes(r *gin.Engine) {
+	r.GET("/api/apps/:app_id/delta/:from/:to", DownloadDelta)
+	r.HEAD("/api/apps/:app_id/delta/:from/:to", DownloadDelta)
+
+	go runDeltaWorker()
+}
+
+// DownloadDelta 下载从from版本到to版本的差分包
+func DownloadDelta(c *gin.Context) {
+	appID := c.Param("app_id")
+	fromVersion := c.Param("from")
+	toVersion := c.Param("to")
+
+	versionList, err := models.LoadVersions(MetadataStore, appID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "无法加载版本列表"})
+		return
+	}
+
+	version, exists := models.GetVersion(versionList, toVersion)
+	if !exists || !version.Delta.Available(fromVersion) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "差分包不存在，请下载完整更新包"})
+		return
+	}
+
+	c.Header("ETag", version.Delta.ETag())
+	c.Header("Digest", digestHeader(version.Delta.SHA256, ""))
+	serveArtifact(c, models.GetArtifactKey(appID, version.Delta.FilePath), filepath.Base(version.Delta.FilePath))
+	if c.Request.Method == http.MethodGet && downloadCompleted(c) {
+		markDownloaded(c.Request.Context(), appID, toVersion)
+		recordDownload(appID, toVersion)
+	}
+}
+
+// 将版本标记为等待生成差分包，并提交后台任务。
+// 先保存等待状态再提交，避免后台任务先完成后被等待状态覆盖
+func scheduleDelta(ctx context.Context, appID string, fromVersion string, toVersion string) {
+	setDelta(ctx, appID, toVersion, &models.Delta{FromVersion: fromVersion, Status: models.DeltaPending, UpdatedAt: time.Now()})
+
+	select {
+	case deltaJobs <- deltaJob{appID: appID, fromVersion: fromVersion, toVersion: toVersion}:
+	default:
+		slog.WarnContext(ctx, "差分任务队列已满，跳过差分包", "app", appID, "version", toVersion)
+		setDelta(ctx, appID, toVersion, &models.Delta{
+			FromVersion: fromVersion,
+			Status:      models.DeltaSkipped,
+			Error:       "差分任务队列已满",
+			UpdatedAt:   time.Now(),
+		})
+	}
+}
+
+// 重新提交服务重启前未完成的差分任务
+func resumeDeltaJobs() {
+	appList, err := models.LoadApps(MetadataStore)
+	if err != nil {
+		slog.Error("加载应用列表失败", "error", err)
+		return
+	}
+
+	for _, app := range appList.Apps {
+		versionList, err := models.LoadVersions(MetadataStore, app.ID)
+		if err != nil {
+			slog.Error("加载版本列表失败", "app", app.ID, "error", err)
+			continue
+		}
+		for _, version := range versionList.Versions {
+			if version.Delta != nil && version.Delta.Status == models.DeltaPending {
+				scheduleDelta(context.Background(), app.ID, version.Delta.FromVersion, version.ID)
+			}
+		}
+	}
+}
+
+// 后台顺序处理差分任务
+func runDeltaWorker() {
+	for job := range deltaJobs {
+		delta := generateDelta(job)
+		setDelta(context.Background(), job.appID, job.toVersion, delta)
+	}
+}
+
+// 生成差分包，返回最终状态
+func generateDelta(job deltaJob) *models.Delta {
+	ctx := context.Background()
+	delta := &models.Delta{FromVersion: job.fromVersion}
+
+	fail := func(err error) *models.Delta {
+		slog.Error("生成差分包失败", "app", job.appID, "from", job.fromVersion, "to", job.toVersion, "error", err)
+		delta.Status = models.DeltaFailed
+		delta.Error = err.Error()
+		delta.UpdatedAt = time.Now()
+		return delta
+	}
+
+	versionList, err := models.LoadVersions(MetadataStore, job.appID)
+	if err != nil {
+		return fail(err)
+	}
+	from, fromExists := models.GetVersion(versionList, job.fromVersion)
+	to, toExists := models.GetVersion(versionList, job.toVersion)
+	if !fromExists || !toExists {
+		return fail(errors.New("版本不存在"))
+	}
+
+	oldArtifact, oldSize, closeOld, err := openArtifact(ctx, models.GetArtifactKey(job.appID, from.FilePath))
+	if err != nil {
+		return fail(err)
+	}
+	defer closeOld()
+	newArtifact, newSize, closeNew, err := openArtifact(ctx, models.GetArtifactKey(job.appID, to.FilePath))
+	if err != nil {
+		return fail(err)
+	}
+	defer closeNew()
+	if oldSize != from.FileSize || newSize != to.FileSize {
+		return fail(errors.New("存储中的文件大小与版本信息不一致"))
+	}
+
+	// 差分包先写入临时文件，只有变化的条目会读入内存
+	tmp, err := os.CreateTemp("", "hotupdate-delta-*")
+	if err != nil {
+		return fail(err)
+	}
+	defer func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}()
+
+	start := time.Now()
+	stats, err := zipdelta.Build(tmp, oldArtifact, oldSize, newArtifact, newSize, maxDeltaMemory)
+	if err != nil {
+		return fail(err)
+	}
+	patchSize, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return fail(err)
+	}
+
+	// 差分包不比完整包小时没有意义
+	if patchSize >= to.FileSize {
+		delta.Status = models.DeltaSkipped
+		delta.Error = "差分包不比完整包小"
+		delta.UpdatedAt = time.Now()
+		return delta
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return fail(err)
+	}
+
+	relPath := filepath.Join("versions", job.toVersion, fmt.Sprintf("from_%s.patch", job.fromVersion))
+	hasher, err := putArtifact(ctx, models.GetArtifactKey(job.appID, relPath), tmp, patchSize)
+	if err != nil {
+		return fail(err)
+	}
+
+	slog.Info("差分包已生成", "app", job.appID, "from", job.fromVersion, "to", job.toVersion,
+		"size", hasher.Size(), "full_size", to.FileSize, "changed_entries", stats.Changed,
+		"copied", stats.CopiedBytes, "patched", stats.PatchedBytes, "literal", stats.LiteralBytes,
+		"elapsed", time.Since(start).String())
+
+	delta.Status = models.DeltaReady
+	delta.FilePath = relPath
+	delta.FileSize = hasher.Size()
+	delta.SHA256 = hasher.SHA256()
+	delta.UpdatedAt = time.Now()
+	return delta
+}
+
+// 保存版本的差分包状态
+func setDelta(ctx context.Context, appID string, versionID string, delta *models.Delta) {
+	deleted := false
+	err := models.UpdateVersions(MetadataStore, appID, func(versionList *models.VersionList) error {
+		version, exists := models.GetVersion(versionList, versionID)
+		if !exists {
+			deleted = true
+			return errNoChange
+		}
+		version.Delta = delta
+		models.SetVersion(versionList, version)
+		return nil
+	})
+	if err != nil && !errors.Is(err, errNoChange) {
+		slog.ErrorContext(ctx, "保存差分包状态失败", "app", appID, "version", versionID, "error", err)
+	}
+
+	// 生成期间版本已被删除时，删除生成的差分包
+	if deleted && delta.FilePath != "" {
+		if err := FileStorage.Delete(ctx, models.GetArtifactKey(appID, delta.FilePath)); err != nil {
+			slog.ErrorContext(ctx, "删除已删除版本的差分包失败", "app", appID, "version", versionID, "error", err)
+		}
+	}
+}
+
+// 获取版本的差分包下载地址
+func deltaDownloadURL(appID string, version models.Version) string {
+	return fmt.Sprintf("/api/apps/%s/delta/%s/%s", appID, version.Delta.FromVersion, version.ID)
+}
@@ -0,0 +1,66 @@
+package controllers
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"testing"
+
+	"hotupdate/app/models"
+	"hotupdate/app/zipdelta"
+)
+
+// 构造一个ZIP更新包，文件以不压缩方式存储，与常见的.pak更新包相同
+func testZip(t *testing.T, files map[string][]byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, name := range []string{"Content/Paks/base.pak", "Content/Paks/patch.pak"} {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(files[name])
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// 整个更新包超过bsdiff的内存限制时，只比较变化的条目，仍然生成差分包
+func TestDeltaForLargePackage(t *testing.T) {
+	base := testArtifactData(32 << 20)
+	patch := base[:1<<20]
+	modified := append([]byte(nil), patch...)
+	copy(modified[5000:], "新版本的内容")
+
+	oldZip := testZip(t, map[string][]byte{"Content/Paks/base.pak": base, "Content/Paks/patch.pak": patch})
+	newZip := testZip(t, map[string][]byte{"Content/Paks/base.pak": base, "Content/Paks/patch.pak": modified})
+	if zipdelta.DiffMemory(int64(len(oldZip)), int64(len(newZip))) <= maxDeltaMemory {
+		t.Fatal("测试用的更新包应超过对整个文件做bsdiff的内存限制")
+	}
+	addTestVersion(t, "delta", "2.8.0", oldZip)
+	to := addTestVersion(t, "delta", "2.8.1", newZip)
+
+	delta := generateDelta(deltaJob{appID: "delta", fromVersion: "2.8.0", toVersion: "2.8.1"})
+	if delta.Status != models.DeltaReady {
+		t.Fatalf("差分包状态为 %s: %s", delta.Status, delta.Error)
+	}
+	if delta.FileSize > to.FileSize/100 {
+		t.Errorf("差分包 %d 字节，完整包 %d 字节", delta.FileSize, to.FileSize)
+	}
+
+	object, err := FileStorage.Get(context.Background(), models.GetArtifactKey("delta", delta.FilePath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer object.Close()
+	var out bytes.Buffer
+	if err := zipdelta.Apply(&out, bytes.NewReader(oldZip), object); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), newZip) {
+		t.Fatal("应用差分包后的内容与新版本的更新包不一致")
+	}
+}
@@ -0,0 +1,444 @@
+package controllers
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+
+	"hotupdate/app/models"
+	"hotupdate/app/storage"
+	"hotupdate/app/store"
+)
+
+const (
+	// 等待生成的文件清单任务队列长度，队列满时请求直接返回稍后重试
+	manifestQueueSize = 64
+	// 从对象存储按范围读取更新包时每次预读的字节数，也是每个打开的更新包占用的最大内存
+	rangeReadAhead = 1 << 20
+	// 文件清单生成中时建议客户端重试的间隔（秒）
+	manifestRetryAfter = "5"
+)
+
+// manifestJob 一个文件清单生成任务，用于文件清单功能之前上传的版本
+type manifestJob struct {
+	appID     string
+	versionID string
+	artifact  models.Artifact
+}
+
+var (
+	// 文件清单任务队列，由单个后台协程顺序处理，避免并发读取大量更新包
+	manifestJobs = make(chan manifestJob, manifestQueueSize)
+
+	manifestMu      sync.Mutex
+	manifestPending = map[string]bool{}   // 已提交、尚未完成的任务，键为文件清单的存储键
+	manifestFailed  = map[string]string{} // 更新包不是有效ZIP文件、无法生成清单的原因
+)
+
+// 注册文件清单路由并启动后台生成协程，与检查更新一样供客户端启动器直接调用。
+// 请求参数platform、flavor与检查更新相同，用于选择平台或纹理格式专用更新包的清单
+func setupManifestRoutes(r *gin.Engine) {
+	r.GET("/api/apps/:app_id/manifest/:version", GetFileManifest)
+	r.GET("/api/apps/:app_id/diff/:from/:to", DiffVersions)
+	r.GET("/api/apps/:app_id/files/:version/*path", DownloadEntry)
+
+	go runManifestWorker()
+}
+
+// GetFileManifest 获取版本更新包的文件清单
+func GetFileManifest(c *gin.Context) {
+	appID := c.Param("app_id")
+
+	version, ok := findVersion(c, appID, c.Param("version"))
+	if !ok {
+		return
+	}
+
+	manifest, ok := fileManifest(c, appID, version, requestArtifact(c, version))
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, manifest)
+}
+
+// DiffVersions 比较两个版本的文件清单，列出新增、变化和删除的文件
+func DiffVersions(c *gin.Context) {
+	appID := c.Param("app_id")
+
+	fromVersion, ok := findVersion(c, appID, c.Param("from"))
+	if !ok {
+		return
+	}
+	toVersion, ok := findVersion(c, appID, c.Param("to"))
+	if !ok {
+		return
+	}
+
+	fromManifest, ok := fileManifest(c, appID, fromVersion, requestArtifact(c, fromVersion))
+	if !ok {
+		return
+	}
+	toManifest, ok := fileManifest(c, appID, toVersion, requestArtifact(c, toVersion))
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, models.DiffFileManifests(fromManifest, toManifest))
+}
+
+// DownloadEntry 下载更新包中的单个文件，启动器可以据此只下载变化的文件。支持Range断点续传
+func DownloadEntry(c *gin.Context) {
+	appID := c.Param("app_id")
+	entryPath := strings.TrimPrefix(c.Param("path"), "/")
+
+	version, ok := findVersion(c, appID, c.Param("version"))
+	if !ok {
+		return
+	}
+	artifact := requestArtifact(c, version)
+
+	ra, size, closeArtifact, err := openArtifact(c.Request.Context(), models.GetArtifactKey(appID, artifact.FilePath))
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "打开更新包失败", "app", appID, "version", version.ID, "artifact", artifact.Key, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "无法读取更新包"})
+		return
+	}
+	defer closeArtifact()
+
+	reader, err := zip.NewReader(ra, size)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "打开更新包失败", "app", appID, "version", version.ID, "artifact", artifact.Key, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "无法读取更新包"})
+		return
+	}
+
+	for _, file := range reader.File {
+		if file.Name != entryPath {
+			continue
+		}
+
+		content, err := entryContent(ra, file)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "无法读取文件"})
+			return
+		}
+		defer content.Close()
+
+		if manifest, err := models.LoadFileManifest(MetadataStore, appID, version.ID, artifact.Key); err == nil {
+			if entry, exists := manifest.FindFile(file.Name); exists {
+				c.Header("ETag", `"`+entry.SHA256+`"`)
+			}
+		}
+		c.Header("Content-Type", "application/octet-stream")
+		c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(file.Name)}))
+		http.ServeContent(c.Writer, c.Request, file.Name, file.Modified, content)
+		if c.Request.Method == http.MethodGet && downloadCompleted(c) {
+			markDownloaded(c.Request.Context(), appID, version.ID)
+		}
+		return
+	}
+
+	c.JSON(http.StatusNotFound, gin.H{"error": "文件不存在"})
+}
+
+// 按请求参数platform、flavor选择更新包，与检查更新的选择规则相同，都不匹配时为默认更新包
+func requestArtifact(c *gin.Context, version models.Version) models.Artifact {
+	return version.ResolveArtifact(strings.ToLower(c.Query("platform")), strings.ToLower(c.Query("flavor")))
+}
+
+// 查找版本，失败时输出错误响应
+func findVersion(c *gin.Context, appID string, versionID string) (models.Version, bool) {
+	versionList, err := models.LoadVersions(MetadataStore, appID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "无法加载版本列表"})
+		return models.Version{}, false
+	}
+
+	version, exists := models.GetVersion(versionList, versionID)
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "版本不存在"})
+		return models.Version{}, false
+	}
+	return version, true
+}
+
+// 获取版本更新包的文件清单。清单在发布版本时生成；文件清单功能之前上传的版本没有清单，
+// 提交后台任务生成，并让客户端稍后重试，不在请求中读取整个更新包
+func fileManifest(c *gin.Context, appID string, version models.Version, artifact models.Artifact) (*models.FileManifest, bool) {
+	manifest, err := models.LoadFileManifest(MetadataStore, appID, version.ID, artifact.Key)
+	if err == nil {
+		return manifest, true
+	}
+	if !errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "无法加载文件清单"})
+		return nil, false
+	}
+
+	if reason, failed := scheduleManifest(c.Request.Context(), manifestJob{appID: appID, versionID: version.ID, artifact: artifact}); failed {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "更新包不是有效的ZIP文件，无法生成文件清单: " + reason})
+		return nil, false
+	}
+	c.Header("Retry-After", manifestRetryAfter)
+	c.JSON(http.StatusServiceUnavailable, gin.H{"error": "文件清单正在生成，请稍后重试"})
+	return nil, false
+}
+
+// 提交文件清单生成任务，同一清单只提交一次。之前生成失败时返回失败原因和true
+func scheduleManifest(ctx context.Context, job manifestJob) (string, bool) {
+	key := models.FileManifestKey(job.appID, job.versionID, job.artifact.Key)
+
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+	if reason, failed := manifestFailed[key]; failed {
+		return reason, true
+	}
+	if manifestPending[key] {
+		return "", false
+	}
+
+	select {
+	case manifestJobs <- job:
+		manifestPending[key] = true
+	default:
+		// 队列已满，之后的请求会再次提交
+		slog.WarnContext(ctx, "文件清单任务队列已满", "app", job.appID, "version", job.versionID, "artifact", job.artifact.Key)
+	}
+	return "", false
+}
+
+// 后台顺序生成文件清单
+func runManifestWorker() {
+	for job := range manifestJobs {
+		key := models.FileManifestKey(job.appID, job.versionID, job.artifact.Key)
+		err := generateManifest(job)
+
+		manifestMu.Lock()
+		delete(manifestPending, key)
+		var pkgErr *models.PackageError
+		if errors.As(err, &pkgErr) {
+			manifestFailed[key] = pkgErr.Message
+		}
+		manifestMu.Unlock()
+	}
+}
+
+// 读取更新包生成文件清单并保存
+func generateManifest(job manifestJob) error {
+	ctx := context.Background()
+	ra, size, closeArtifact, err := openArtifact(ctx, models.GetArtifactKey(job.appID, job.artifact.FilePath))
+	if err != nil {
+		slog.Error("读取更新包失败", "app", job.appID, "version", job.versionID, "artifact", job.artifact.Key, "error", err)
+		return err
+	}
+	defer closeArtifact()
+
+	manifest, err := models.BuildFileManifest(ra, size, job.versionID)
+	if err != nil {
+		slog.Error("生成文件清单失败", "app", job.appID, "version", job.versionID, "artifact", job.artifact.Key, "error", err)
+		return err
+	}
+	manifest.Artifact = job.artifact.Key
+
+	if err := models.SaveFileManifest(MetadataStore, job.appID, manifest); err != nil {
+		slog.Error("保存文件清单失败", "app", job.appID, "version", job.versionID, "artifact", job.artifact.Key, "error", err)
+		return err
+	}
+	slog.Info("已生成文件清单", "app", job.appID, "version", job.versionID, "artifact", job.artifact.Key)
+	return nil
+}
+
+// 以随机读取的方式打开存储中的文件，不把整个文件读入内存：支持按范围读取的存储按需读取，
+// 本地文件直接随机读取，其他存储先写入临时文件。使用完毕后需调用返回的关闭函数
+func openArtifact(ctx context.Context, key string) (io.ReaderAt, int64, func(), error) {
+	if getter, ok := FileStorage.(storage.RangeGetter); ok {
+		info, err := FileStorage.Stat(ctx, key)
+		if err != nil {
+			return nil, 0, nil, err
+		}
+		return &rangeReaderAt{ctx: ctx, getter: getter, key: key, size: info.Size}, info.Size, func() {}, nil
+	}
+
+	object, err := FileStorage.Get(ctx, key)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	if ra, ok := object.ReadCloser.(io.ReaderAt); ok {
+		return ra, object.Info.Size, func() { object.Close() }, nil
+	}
+
+	defer object.Close()
+	tmp, err := os.CreateTemp("", "hotupdate-artifact-*")
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	cleanup := func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}
+	size, err := io.Copy(tmp, object)
+	if err != nil {
+		cleanup()
+		return nil, 0, nil, err
+	}
+	return tmp, size, cleanup, nil
+}
+
+// rangeReaderAt 将按范围读取的存储包装为io.ReaderAt，供zip.Reader随机读取。
+// 每次范围读取至少预读rangeReadAhead字节并缓存，顺序读取同一文件时不会产生大量小请求
+type rangeReaderAt struct {
+	ctx    context.Context
+	getter storage.RangeGetter
+	key    string
+	size   int64
+
+	mu     sync.Mutex
+	buf    []byte
+	bufOff int64
+}
+
+func (r *rangeReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("无效的偏移量")
+	}
+	if off >= r.size {
+		return 0, io.EOF
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := 0
+	for n < len(p) && off < r.size {
+		if off < r.bufOff || off >= r.bufOff+int64(len(r.buf)) {
+			if err := r.fill(off, len(p)-n); err != nil {
+				return n, err
+			}
+		}
+		copied := copy(p[n:], r.buf[off-r.bufOff:])
+		n += copied
+		off += int64(copied)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// 从off开始读取至少want字节（不超过文件末尾）到缓存
+func (r *rangeReaderAt) fill(off int64, want int) error {
+	length := min(int64(max(want, rangeReadAhead)), r.size-off)
+	body, err := r.getter.GetRange(r.ctx, r.key, off, length)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	if int64(cap(r.buf)) < length {
+		r.buf = make([]byte, length)
+	}
+	r.buf = r.buf[:length]
+	if _, err := io.ReadFull(body, r.buf); err != nil {
+		r.buf = r.buf[:0]
+		return err
+	}
+	r.bufOff = off
+	return nil
+}
+
+// 打开更新包中的文件供http.ServeContent按范围读取。不压缩存储的文件直接读取更新包中的对应区间，
+// 压缩的文件从头解压到请求的位置
+func entryContent(ra io.ReaderAt, file *zip.File) (io.ReadSeekCloser, error) {
+	if file.Method == zip.Store {
+		offset, err := file.DataOffset()
+		if err != nil {
+			return nil, err
+		}
+		return nopCloser{io.NewSectionReader(ra, offset, int64(file.UncompressedSize64))}, nil
+	}
+	return &zipEntryReadSeeker{file: file, size: int64(file.UncompressedSize64)}, nil
+}
+
+// nopCloser 为不需要关闭的io.ReadSeeker添加Close方法
+type nopCloser struct {
+	io.ReadSeeker
+}
+
+func (nopCloser) Close() error { return nil }
+
+// zipEntryReadSeeker 将压缩的ZIP条目包装为io.ReadSeeker。Seek只记录位置，下一次Read时
+// 向前跳过解压的数据；位置在已解压的数据之前时从头重新解压
+type zipEntryReadSeeker struct {
+	file    *zip.File
+	size    int64
+	offset  int64
+	body    io.ReadCloser
+	bodyOff int64 // body已读到的位置
+}
+
+func (r *zipEntryReadSeeker) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	if r.body == nil {
+		body, err := r.file.Open()
+		if err != nil {
+			return 0, err
+		}
+		r.body, r.bodyOff = body, 0
+	}
+	if r.bodyOff < r.offset {
+		skipped, err := io.CopyN(io.Discard, r.body, r.offset-r.bodyOff)
+		r.bodyOff += skipped
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.body.Read(p)
+	r.offset += int64(n)
+	r.bodyOff += int64(n)
+	return n, err
+}
+
+func (r *zipEntryReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	var target int64
+	switch whence {
+	case io.SeekStart:
+		target = offset
+	case io.SeekCurrent:
+		target = r.offset + offset
+	case io.SeekEnd:
+		target = r.size + offset
+	default:
+		return 0, errors.New("无效的whence")
+	}
+	if target < 0 {
+		return 0, errors.New("无效的偏移量")
+	}
+
+	if target < r.bodyOff {
+		r.Close()
+	}
+	r.offset = target
+	return target, nil
+}
+
+func (r *zipEntryReadSeeker) Close() error {
+	if r.body == nil {
+		return nil
+	}
+	err := r.body.Close()
+	r.body = nil
+	return err
+}
@@ -0,0 +1,144 @@
+package controllers
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+)
+
+// fakeRangeGetter 内存中的按范围读取存储，记录读取次数和最大的单次读取长度
+type fakeRangeGetter struct {
+	data      []byte
+	calls     int
+	maxLength int64
+}
+
+func (g *fakeRangeGetter) GetRange(ctx context.Context, key string, offset int64, length int64) (io.ReadCloser, error) {
+	g.calls++
+	g.maxLength = max(g.maxLength, length)
+	return io.NopCloser(bytes.NewReader(g.data[offset : offset+length])), nil
+}
+
+func TestRangeReaderAtReadsZip(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	want := map[string][]byte{}
+	for i := 0; i < 3; i++ {
+		name := fmt.Sprintf("Content/file%d.bin", i)
+		content := bytes.Repeat([]byte{byte('a' + i)}, 3*rangeReadAhead/2)
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(content)
+		want[name] = content
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	getter := &fakeRangeGetter{data: buf.Bytes()}
+	ra := &rangeReaderAt{ctx: context.Background(), getter: getter, key: "k", size: int64(buf.Len())}
+	reader, err := zip.NewReader(ra, int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range reader.File {
+		rc, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want[file.Name]) {
+			t.Errorf("%s 内容不一致", file.Name)
+		}
+	}
+
+	// 按预读大小分块读取，不会读入整个文件，也不会产生大量小请求
+	if getter.maxLength > rangeReadAhead {
+		t.Errorf("单次读取 %d 字节，超过预读大小 %d", getter.maxLength, rangeReadAhead)
+	}
+	if limit := buf.Len()/rangeReadAhead + 8; getter.calls > limit {
+		t.Errorf("范围读取 %d 次，超过 %d 次", getter.calls, limit)
+	}
+}
+
+func TestRangeReaderAtEOF(t *testing.T) {
+	getter := &fakeRangeGetter{data: []byte("0123456789")}
+	ra := &rangeReaderAt{ctx: context.Background(), getter: getter, key: "k", size: 10}
+
+	p := make([]byte, 4)
+	if n, err := ra.ReadAt(p, 8); n != 2 || err != io.EOF || string(p[:n]) != "89" {
+		t.Errorf("ReadAt(8) = %d, %v, %q", n, err, p[:n])
+	}
+	if n, err := ra.ReadAt(p, 10); n != 0 || err != io.EOF {
+		t.Errorf("ReadAt(10) = %d, %v", n, err)
+	}
+	if n, err := ra.ReadAt(p, 2); n != 4 || err != nil || string(p) != "2345" {
+		t.Errorf("ReadAt(2) = %d, %v, %q", n, err, p)
+	}
+}
+
+// 单个文件下载支持Range，不压缩和压缩存储的文件都能从任意位置续传
+func TestDownloadEntryRange(t *testing.T) {
+	content := testArtifactData(256 << 10)
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, entry := range []struct {
+		name   string
+		method uint16
+	}{{"stored.pak", zip.Store}, {"deflated.pak", zip.Deflate}} {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: entry.name, Method: entry.method})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(content)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	addTestVersion(t, "entry-range", "2.0.0", buf.Bytes())
+
+	for _, name := range []string{"stored.pak", "deflated.pak"} {
+		t.Run(name, func(t *testing.T) {
+			url := "/api/apps/entry-range/files/2.0.0/" + name
+			w := serve(http.MethodGet, url, nil)
+			if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), content) {
+				t.Fatalf("完整下载返回 %d，%d 字节", w.Code, w.Body.Len())
+			}
+			if got := w.Header().Get("Accept-Ranges"); got != "bytes" {
+				t.Errorf("Accept-Ranges = %q", got)
+			}
+
+			for _, tt := range []struct {
+				header     string
+				start, end int
+			}{
+				{"bytes=1000-1999", 1000, 2000},
+				{"bytes=200000-", 200000, len(content)},
+				{"bytes=-100", len(content) - 100, len(content)},
+			} {
+				w := serve(http.MethodGet, url, map[string]string{"Range": tt.header})
+				if w.Code != http.StatusPartialContent {
+					t.Errorf("Range %s 返回 %d", tt.header, w.Code)
+					continue
+				}
+				if !bytes.Equal(w.Body.Bytes(), content[tt.start:tt.end]) {
+					t.Errorf("Range %s 返回的内容不正确", tt.header)
+				}
+				want := fmt.Sprintf("bytes %d-%d/%d", tt.start, tt.end-1, len(content))
+				if got := w.Header().Get("Content-Range"); got != want {
+					t.Errorf("Content-Range = %s，期望 %s", got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package controllers
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"hotupdate/app/storage"
+	"hotupdate/app/utils"
+)
+
+// 测试目录中上传目录之外的文件内容
+const testSecret = "hotupdate-test-secret-outside-upload-dir"
+
+// checkedStorage 记录解析到根目录之外的对象键。本地存储本身会拒绝这些键，
+// 这里检查的是处理请求时根本不应该构造出这样的键
+type checkedStorage struct {
+	storage.Storage
+	root string
+
+	mu     sync.Mutex
+	unsafe []string
+}
+
+func (s *checkedStorage) check(key string) {
+	if _, err := utils.SafeJoin(s.root, key); err != nil || !strings.HasPrefix(key, "apps/") {
+		s.mu.Lock()
+		s.unsafe = append(s.unsafe, key)
+		s.mu.Unlock()
+	}
+}
+
+// 取出并清空记录的不安全对象键
+func (s *checkedStorage) takeUnsafe() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	unsafe := s.unsafe
+	s.unsafe = nil
+	return unsafe
+}
+
+func (s *checkedStorage) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	s.check(key)
+	return s.Storage.Put(ctx, key, r, size)
+}
+
+func (s *checkedStorage) Get(ctx context.Context, key string) (*storage.Object, error) {
+	s.check(key)
+	return s.Storage.Get(ctx, key)
+}
+
+func (s *checkedStorage) Stat(ctx context.Context, key string) (storage.ObjectInfo, error) {
+	s.check(key)
+	return s.Storage.Stat(ctx, key)
+}
+
+func (s *checkedStorage) Delete(ctx context.Context, key string) error {
+	s.check(key)
+	return s.Storage.Delete(ctx, key)
+}
+
+func (s *checkedStorage) PresignGet(ctx context.Context, key string, expires time.Duration) (string, error) {
+	s.check(key)
+	return s.Storage.PresignGet(ctx, key, expires)
+}
+
+// 生成包含指定文件的ZIP更新包
+func testZipData(t testing.TB, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, content)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func FuzzValidatePathParams(f *testing.F) {
+	for _, seed := range []struct{ key, value string }{
+		{"app_id", "game"}, {"app_id", "../game"}, {"app_id", "admin"},
+		{"version", "1.0.0"}, {"version", ".."}, {"version", "1.0/../../x"},
+		{"from", "1.0.0"}, {"to", `..\..`},
+		{"filename", "update.zip"}, {"filename", "../../secret.txt"}, {"filename", ".."},
+		{"upload_id", "0123abcd"}, {"token_id", "..%2f"},
+	} {
+		f.Add(seed.key, seed.value)
+	}
+
+	gin.SetMode(gin.TestMode)
+	f.Fuzz(func(t *testing.T, key string, value string) {
+		passed := false
+		r := gin.New()
+		r.Use(func(c *gin.Context) {
+			c.Params = gin.Params{{Key: key, Value: value}}
+		}, validatePathParams())
+		r.GET("/", func(c *gin.Context) { passed = true })
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		if !passed {
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("%s=%q 被拒绝时返回 %d", key, value, w.Code)
+			}
+			return
+		}
+
+		// 通过校验的路径参数都不能离开所在目录
+		switch key {
+		case "app_id", "version", "version_id", "from", "to", "filename", "upload_id", "token_id":
+			if value == "" || strings.Contains(value, "..") || strings.ContainsAny(value, "/\\\x00") {
+				t.Fatalf("%s=%q 通过了校验", key, value)
+			}
+		}
+	})
+}
+
+// 对下载、差分包和文件清单路由发送任意路径，任何请求都不能访问上传目录之外的文件
+func FuzzDownloadRoutes(f *testing.F) {
+	addTestVersion(f, "fuzz", "1.0.0", testZipData(f, map[string]string{"a.bin": "a", "Content/b.pak": "b"}))
+	addTestVersion(f, "fuzz", "1.0.1", testZipData(f, map[string]string{"a.bin": "a2", "c.txt": "c"}))
+
+	for _, seed := range []struct{ version, name string }{
+		{"1.0.0", "update.zip"},
+		{"1.0.1", "a.bin"},
+		{"..", "secret.txt"},
+		{"1.0.0", "../../../../secret.txt"},
+		{"..%2f..%2f", "secret.txt"},
+		{"1.0.0/../../..", "secret.txt"},
+		{`..\..`, `..\secret.txt`},
+		{"1.0.0", "%2e%2e/%2e%2e/secret.txt"},
+		{"1.0.0\x00", "update.zip"},
+	} {
+		f.Add(seed.version, seed.name)
+	}
+
+	checked := FileStorage.(*checkedStorage)
+	f.Fuzz(func(t *testing.T, version string, name string) {
+		for _, target := range []string{
+			"/api/apps/fuzz/download/" + version + "/" + name,
+			"/api/download/" + version + "/" + name,
+			"/api/apps/fuzz/delta/" + version + "/" + name,
+			"/api/apps/fuzz/manifest/" + version,
+			"/api/apps/fuzz/diff/" + version + "/" + name,
+			"/api/apps/fuzz/files/" + version + "/" + name,
+			"/api/apps/fuzz/files/1.0.0/" + name,
+		} {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.URL.Path = target
+			w := httptest.NewRecorder()
+			testServer.ServeHTTP(w, req)
+
+			if strings.Contains(w.Body.String(), testSecret) {
+				t.Fatalf("%q 读到了上传目录之外的文件", target)
+			}
+			if unsafe := checked.takeUnsafe(); len(unsafe) > 0 {
+				t.Fatalf("%q 访问了不安全的对象键 %q", target, unsafe)
+			}
+			if w.Code >= http.StatusInternalServerError && w.Code != http.StatusServiceUnavailable {
+				t.Fatalf("%q 返回 %d: %s", target, w.Code, w.Body.String())
+			}
+		}
+	})
+}
@@ -0,0 +1,64 @@
+package controllers
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimiter 按键（如客户端IP）限制请求频率的令牌桶，令牌已补满的键会被定期清理
+type rateLimiter struct {
+	mu        sync.Mutex
+	rate      float64 // 每秒补充的令牌数
+	burst     float64 // 令牌桶容量，即允许的突发请求数
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+// tokenBucket 一个键的令牌桶
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// 创建每分钟允许perMinute次、最多突发burst次请求的限流器
+func newRateLimiter(perMinute int, burst int) *rateLimiter {
+	return &rateLimiter{
+		rate:    float64(perMinute) / 60,
+		burst:   float64(burst),
+		buckets: map[string]*tokenBucket{},
+	}
+}
+
+// Allow 判断该键此时能否再发送一次请求，能时消耗一个令牌
+func (l *rateLimiter) Allow(key string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: l.burst, updated: now}
+		l.buckets[key] = bucket
+	}
+	bucket.tokens = min(l.burst, bucket.tokens+now.Sub(bucket.updated).Seconds()*l.rate)
+	bucket.updated = now
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
+
+// 每分钟清理一次令牌已补满的键，它们与新的键没有区别，调用方需持有mu
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+	full := time.Duration(l.burst / l.rate * float64(time.Second))
+	for key, bucket := range l.buckets {
+		if now.Sub(bucket.updated) >= full {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,422 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+
+	"hotupdate/app/metrics"
+	"hotupdate/app/models"
+)
+
+const (
+	// 错误信息的最大长度（字符），超出部分截断
+	maxReportErrorLength = 500
+	// 设备ID的最大长度
+	maxDeviceIDLength = 128
+	// 每个客户端IP每分钟允许的上报次数和突发上报次数
+	reportRatePerMinute = 30
+	reportBurst         = 60
+	// 内存中等待写入的上报设备数上限，超出时拒绝新设备的上报，避免大量伪造设备占用内存
+	maxPendingReportDevices = 100000
+)
+
+// deviceReport 一台设备尚未写入的最后一次上报
+type deviceReport struct {
+	outcome        string
+	fromVersion    string
+	error          string
+	at             time.Time
+	downloadMillis int64 // 下载耗时（毫秒），未上报时为-1
+	applyMillis    int64 // 应用耗时（毫秒），未上报时为-1
+}
+
+var (
+	reportsMu           sync.Mutex
+	reportsPending      = map[string]map[string]map[string]deviceReport{} // 应用ID -> 版本ID -> 设备ID哈希 -> 最后一次上报
+	reportsPendingCount int                                               // reportsPending中的设备数
+	reportsUnsaved      = map[string]map[string]*models.ReportStats{}     // 已按设备去重、尚未写入版本信息的统计变化
+
+	reportLimiter = newRateLimiter(reportRatePerMinute, reportBurst)
+)
+
+// 注册客户端上报路由
+func setupReportRoutes(r *gin.Engine) {
+	r.POST("/api/apps/:app_id/report", ReportUpdateResult)
+}
+
+// ReportUpdateResult 客户端上报更新结果。表单参数：device_id（必填）、to_version（必填）、outcome（必填）、
+// from_version、error、download_ms、apply_ms。同一设备对同一版本只计最后上报的一次结果；
+// 上报按客户端IP限流，先在内存中累计，与统计数据一起定期写入版本信息
+func ReportUpdateResult(c *gin.Context) {
+	if !reportLimiter.Allow(c.ClientIP(), time.Now()) {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "上报过于频繁，请稍后重试"})
+		return
+	}
+
+	appID := c.Param("app_id")
+	deviceID := c.PostForm("device_id")
+	toVersion := c.PostForm("to_version")
+	fromVersion := c.PostForm("from_version")
+	outcome := c.PostForm("outcome")
+
+	if deviceID == "" || len(deviceID) > maxDeviceIDLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "device_id不能为空，最长128个字符"})
+		return
+	}
+	if !models.ValidReportOutcome(outcome) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的更新结果，可选值：applied、download_failed、hash_mismatch、mount_failed"})
+		return
+	}
+	if err := models.ValidateVersionID(toVersion); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "to_version无效: " + err.Error()})
+		return
+	}
+	if fromVersion != "" {
+		if err := models.ValidateVersionID(fromVersion); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "from_version无效: " + err.Error()})
+			return
+		}
+	}
+	downloadMillis, ok := reportMillis(c, "download_ms")
+	if !ok {
+		return
+	}
+	applyMillis, ok := reportMillis(c, "apply_ms")
+	if !ok {
+		return
+	}
+
+	appList, err := models.LoadApps(MetadataStore)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "无法加载应用列表"})
+		return
+	}
+	if _, exists := models.GetApp(appList, appID); !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "应用不存在"})
+		return
+	}
+	versionList, err := models.LoadVersions(MetadataStore, appID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "无法加载版本列表"})
+		return
+	}
+	if _, exists := models.GetVersion(versionList, toVersion); !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "版本不存在"})
+		return
+	}
+
+	report := deviceReport{
+		outcome:        outcome,
+		at:             time.Now(),
+		downloadMillis: downloadMillis,
+		applyMillis:    applyMillis,
+	}
+	if outcome != models.ReportApplied {
+		report.error = truncateReportError(c.PostForm("error"))
+		report.fromVersion = fromVersion
+	}
+
+	if !addPendingReport(appID, toVersion, hashDeviceID(appID, deviceID), report) {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "上报过于频繁，请稍后重试"})
+		return
+	}
+
+	metrics.UpdateReports.Inc(appID, outcome)
+	c.JSON(http.StatusOK, gin.H{"message": "已收到更新结果"})
+}
+
+// 记录设备的上报，同一设备尚未写入的上报只保留最后一次。等待写入的设备过多时返回false
+func addPendingReport(appID string, versionID string, hash string, report deviceReport) bool {
+	reportsMu.Lock()
+	defer reportsMu.Unlock()
+
+	if reportsPending[appID] == nil {
+		reportsPending[appID] = map[string]map[string]deviceReport{}
+	}
+	devices := reportsPending[appID][versionID]
+	if devices == nil {
+		devices = map[string]deviceReport{}
+		reportsPending[appID][versionID] = devices
+	}
+	if _, exists := devices[hash]; !exists {
+		if reportsPendingCount >= maxPendingReportDevices {
+			return false
+		}
+		reportsPendingCount++
+	}
+	devices[hash] = report
+	return true
+}
+
+// 读取以毫秒为单位的耗时，未提供时返回-1。格式无效时输出错误响应
+func reportMillis(c *gin.Context, name string) (int64, bool) {
+	raw := c.PostForm(name)
+	if raw == "" {
+		return -1, true
+	}
+	millis, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || millis < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": name + "必须是非负整数"})
+		return 0, false
+	}
+	return millis, true
+}
+
+// 截断过长的错误信息
+func truncateReportError(message string) string {
+	if utf8.RuneCountInString(message) <= maxReportErrorLength {
+		return message
+	}
+	return string([]rune(message)[:maxReportErrorLength])
+}
+
+// 将内存中累计的上报按设备去重后合并到版本信息，并检查灰度中的版本是否达到应用的自动终止条件。
+// 写入失败的上报放回内存等待下次写入
+func flushReports() {
+	reportsMu.Lock()
+	pending := reportsPending
+	reportsPending = map[string]map[string]map[string]deviceReport{}
+	reportsPendingCount = 0
+	reportsMu.Unlock()
+
+	for appID, versions := range pending {
+		versionList, err := models.LoadVersions(MetadataStore, appID)
+		if err != nil {
+			slog.Error("写入上报统计时加载版本列表失败", "app", appID, "error", err)
+			for versionID, devices := range versions {
+				requeueDeviceReports(appID, versionID, devices)
+			}
+			continue
+		}
+		for versionID, devices := range versions {
+			// 已删除的版本不再记录
+			if _, exists := models.GetVersion(versionList, versionID); !exists {
+				continue
+			}
+			if change := recordDeviceReports(appID, versionID, devices); change != nil {
+				recordApplied(appID, versionID, change.Outcomes[models.ReportApplied])
+				requeueReports(map[string]map[string]*models.ReportStats{appID: {versionID: change}})
+			}
+		}
+	}
+
+	reportsMu.Lock()
+	unsaved := reportsUnsaved
+	reportsUnsaved = map[string]map[string]*models.ReportStats{}
+	reportsMu.Unlock()
+	if len(unsaved) == 0 {
+		return
+	}
+
+	appList, err := models.LoadApps(MetadataStore)
+	if err != nil {
+		slog.Error("写入上报统计时加载应用列表失败", "error", err)
+		requeueReports(unsaved)
+		return
+	}
+
+	for appID, versions := range unsaved {
+		app, exists := models.GetApp(appList, appID)
+		if !exists {
+			continue
+		}
+
+		var halted map[string]string // 版本ID -> 自动终止的原因
+		err := models.UpdateVersions(MetadataStore, appID, func(versionList *models.VersionList) error {
+			halted = map[string]string{}
+			for versionID, report := range versions {
+				version, exists := models.GetVersion(versionList, versionID)
+				if !exists {
+					continue
+				}
+				if version.Reports == nil {
+					version.Reports = &models.ReportStats{}
+				}
+				version.Reports.Merge(report)
+
+				if reason, exceeded := app.AutoHalt.Exceeded(version.Reports); exceeded && version.Rollout != nil && version.Rollout.Status == models.RolloutActive {
+					version.Rollout.Status = models.RolloutHalted
+					version.Rollout.Reason = reason
+					version.Rollout.UpdatedBy = autoHaltOperator
+					version.Rollout.UpdatedAt = time.Now()
+					halted[versionID] = reason
+				}
+				models.SetVersion(versionList, version)
+			}
+			return nil
+		})
+		if err != nil {
+			slog.Error("保存上报统计失败", "app", appID, "error", err)
+			requeueReports(map[string]map[string]*models.ReportStats{appID: versions})
+			continue
+		}
+		for versionID, reason := range halted {
+			slog.Warn("已自动终止灰度", "app", appID, "version", versionID, "reason", reason)
+		}
+	}
+}
+
+// 将设备的上报按桶写入上报设备记录，返回版本统计的变化，没有变化时返回nil。
+// 每台设备只计最后上报的结果：重复上报相同结果不计，改报其他结果时扣除原结果。
+// 写入失败的桶中的上报放回内存等待下次写入
+func recordDeviceReports(appID string, versionID string, devices map[string]deviceReport) *models.ReportStats {
+	buckets := map[string]map[string]deviceReport{}
+	for hash, report := range devices {
+		bucket := models.DeviceBucket(hash)
+		if buckets[bucket] == nil {
+			buckets[bucket] = map[string]deviceReport{}
+		}
+		buckets[bucket][hash] = report
+	}
+
+	var total *models.ReportStats
+	for bucket, reports := range buckets {
+		var change *models.ReportStats
+		err := models.UpdateReportDevices(MetadataStore, appID, versionID, bucket, func(recorded *models.ReportDevices) error {
+			change = &models.ReportStats{Outcomes: map[string]int{}}
+			for hash, report := range reports {
+				previous, reported := recorded.Outcomes[hash]
+				if reported && previous == report.outcome {
+					continue
+				}
+				if reported {
+					change.Outcomes[previous]--
+				}
+				change.Outcomes[report.outcome]++
+				recorded.Outcomes[hash] = report.outcome
+
+				if report.downloadMillis >= 0 {
+					change.DownloadMillis += report.downloadMillis
+					change.Downloads++
+				}
+				if report.applyMillis >= 0 {
+					change.ApplyMillis += report.applyMillis
+					change.Applies++
+				}
+				if report.outcome != models.ReportApplied && (change.LastErrorAt == nil || report.at.After(*change.LastErrorAt)) {
+					at := report.at
+					change.LastError = report.error
+					change.LastFromVersion = report.fromVersion
+					change.LastErrorAt = &at
+				}
+			}
+			if len(change.Outcomes) == 0 {
+				return errNoChange
+			}
+			return nil
+		})
+		if errors.Is(err, errNoChange) {
+			continue
+		}
+		if err != nil {
+			slog.Error("保存上报设备失败", "app", appID, "version", versionID, "error", err)
+			requeueDeviceReports(appID, versionID, reports)
+			continue
+		}
+		if total == nil {
+			total = &models.ReportStats{}
+		}
+		total.Merge(change)
+	}
+	return total
+}
+
+// 自动终止灰度时记录的操作者
+const autoHaltOperator = "system"
+
+// 将写入失败的设备上报放回内存，之后收到的同一设备的上报优先
+func requeueDeviceReports(appID string, versionID string, failed map[string]deviceReport) {
+	reportsMu.Lock()
+	defer reportsMu.Unlock()
+	if reportsPending[appID] == nil {
+		reportsPending[appID] = map[string]map[string]deviceReport{}
+	}
+	devices := reportsPending[appID][versionID]
+	if devices == nil {
+		devices = map[string]deviceReport{}
+		reportsPending[appID][versionID] = devices
+	}
+	for hash, report := range failed {
+		if _, newer := devices[hash]; !newer {
+			devices[hash] = report
+			reportsPendingCount++
+		}
+	}
+}
+
+// 将尚未写入版本信息的统计变化放回内存，排在之后的变化之前
+func requeueReports(failed map[string]map[string]*models.ReportStats) {
+	reportsMu.Lock()
+	defer reportsMu.Unlock()
+	for appID, versions := range failed {
+		if reportsUnsaved[appID] == nil {
+			reportsUnsaved[appID] = map[string]*models.ReportStats{}
+		}
+		for versionID, report := range versions {
+			if later, ok := reportsUnsaved[appID][versionID]; ok {
+				report.Merge(later)
+			}
+			reportsUnsaved[appID][versionID] = report
+		}
+	}
+}
+
+// 丢弃应用尚未写入的上报，用于彻底删除应用
+func discardReports(appID string) {
+	reportsMu.Lock()
+	defer reportsMu.Unlock()
+	for _, devices := range reportsPending[appID] {
+		reportsPendingCount -= len(devices)
+	}
+	delete(reportsPending, appID)
+	delete(reportsUnsaved, appID)
+}
+
+// 读取请求中的自动终止规则，表单参数auto_halt_failure_rate为失败率阈值（1-100，0或空表示关闭），
+// auto_halt_min_reports为判断前至少需要的上报设备数（空表示默认值）。两个参数都没有提供时返回false
+func requestAutoHalt(c *gin.Context, current *models.AutoHalt) (*models.AutoHalt, bool, error) {
+	rateValue, setRate := c.GetPostForm("auto_halt_failure_rate")
+	minValue, setMin := c.GetPostForm("auto_halt_min_reports")
+	if !setRate && !setMin {
+		return current, false, nil
+	}
+
+	autoHalt := &models.AutoHalt{MinReports: models.DefaultAutoHaltMinReports}
+	if current != nil {
+		*autoHalt = *current
+	}
+	if setRate {
+		rate := 0
+		if rateValue != "" {
+			var err error
+			if rate, err = strconv.Atoi(rateValue); err != nil || rate < 0 || rate > 100 {
+				return nil, true, newRequestError(http.StatusBadRequest, "自动终止的失败率阈值必须是0到100之间的整数")
+			}
+		}
+		if rate == 0 {
+			return nil, true, nil
+		}
+		autoHalt.FailureRate = rate
+	}
+	if setMin && minValue == "" {
+		autoHalt.MinReports = models.DefaultAutoHaltMinReports
+	} else if setMin {
+		minReports, err := strconv.Atoi(minValue)
+		if err != nil || minReports < models.MinAutoHaltReports {
+			return nil, true, newRequestError(http.StatusBadRequest, fmt.Sprintf("自动终止的最少上报设备数必须是不小于%d的整数", models.MinAutoHaltReports))
+		}
+		autoHalt.MinReports = minReports
+	}
+	if autoHalt.FailureRate == 0 {
+		return nil, true, newRequestError(http.StatusBadRequest, "请先设置自动终止的失败率阈值")
+	}
+	return autoHalt, true, nil
+}
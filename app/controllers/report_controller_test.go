@@ -0,0 +1,132 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"hotupdate/app/models"
+)
+
+// 发送一次更新结果上报，每次使用不同的客户端IP，避免触发限流
+func postReport(t *testing.T, appID string, form url.Values) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/api/apps/"+appID+"/report", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.RemoteAddr = "198.51.100." + form.Get("device_id") + ":1234"
+	w := httptest.NewRecorder()
+	testServer.ServeHTTP(w, req)
+	return w
+}
+
+func TestReportRequiresDeviceID(t *testing.T) {
+	addTestVersion(t, "game", "2.5.0", testArtifactData(1024))
+
+	w := postReport(t, "game", url.Values{"to_version": {"2.5.0"}, "outcome": {models.ReportApplied}})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("没有device_id时返回 %d，期望400", w.Code)
+	}
+}
+
+// 同一设备对同一版本只计最后一次结果，跨多次写入也不重复计数
+func TestReportCountsOneOutcomePerDevice(t *testing.T) {
+	addTestVersion(t, "game", "2.6.0", testArtifactData(1024))
+	report := func(deviceID string, outcome string) {
+		t.Helper()
+		w := postReport(t, "game", url.Values{"device_id": {deviceID}, "to_version": {"2.6.0"}, "outcome": {outcome}, "error": {"失败"}})
+		if w.Code != http.StatusOK {
+			t.Fatalf("上报返回 %d: %s", w.Code, w.Body.String())
+		}
+	}
+	outcomes := func() map[string]int {
+		t.Helper()
+		flushReports()
+		versionList, err := models.LoadVersions(MetadataStore, "game")
+		if err != nil {
+			t.Fatal(err)
+		}
+		version, _ := models.GetVersion(versionList, "2.6.0")
+		if version.Reports == nil {
+			return map[string]int{}
+		}
+		return version.Reports.Outcomes
+	}
+
+	for i := 0; i < 5; i++ {
+		report("1", models.ReportMountFailed)
+	}
+	report("2", models.ReportMountFailed)
+	if got := outcomes(); got[models.ReportMountFailed] != 2 || len(got) != 1 {
+		t.Fatalf("重复上报后统计为 %v，期望两台设备失败", got)
+	}
+
+	// 再次上报相同结果不计数
+	report("1", models.ReportMountFailed)
+	if got := outcomes(); got[models.ReportMountFailed] != 2 {
+		t.Fatalf("再次上报相同结果后统计为 %v", got)
+	}
+
+	// 重试成功后以最后一次结果为准
+	report("1", models.ReportApplied)
+	if got := outcomes(); got[models.ReportMountFailed] != 1 || got[models.ReportApplied] != 1 {
+		t.Fatalf("改报结果后统计为 %v，期望失败和应用各1次", got)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	limiter := newRateLimiter(60, 3)
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		if !limiter.Allow("a", now) {
+			t.Fatalf("第%d次突发请求被拒绝", i+1)
+		}
+	}
+	if limiter.Allow("a", now) {
+		t.Fatal("超出突发次数的请求应被拒绝")
+	}
+	if !limiter.Allow("b", now) {
+		t.Fatal("不同的键互不影响")
+	}
+	if !limiter.Allow("a", now.Add(time.Second)) {
+		t.Fatal("补充令牌后请求应被允许")
+	}
+	if limiter.Allow("a", now.Add(time.Second)) {
+		t.Fatal("每秒只补充一个令牌")
+	}
+}
+
+// 不信任代理时X-Forwarded-For不影响客户端IP，伪造请求头无法绕过限流
+func TestReportRateLimitIgnoresForwardedFor(t *testing.T) {
+	addTestVersion(t, "game", "2.9.0", testArtifactData(1024))
+	limited := false
+	for i := 0; i < 100 && !limited; i++ {
+		form := url.Values{"device_id": {fmt.Sprintf("xff-%d", i)}, "to_version": {"2.9.0"}, "outcome": {models.ReportApplied}}
+		req := httptest.NewRequest(http.MethodPost, "/api/apps/game/report", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("X-Forwarded-For", fmt.Sprintf("192.0.2.%d", i))
+		req.RemoteAddr = "203.0.113.9:1234"
+		w := httptest.NewRecorder()
+		testServer.ServeHTTP(w, req)
+		limited = w.Code == http.StatusTooManyRequests
+	}
+	if !limited {
+		t.Fatal("更换X-Forwarded-For后仍应按连接地址限流")
+	}
+}
+
+// 上报设备数少于下限时不自动终止，即使规则设置的最少设备数更低
+func TestAutoHaltRequiresMinimumDevices(t *testing.T) {
+	autoHalt := &models.AutoHalt{FailureRate: 50, MinReports: 1}
+	stats := &models.ReportStats{Outcomes: map[string]int{models.ReportMountFailed: models.MinAutoHaltReports - 1}}
+	if _, exceeded := autoHalt.Exceeded(stats); exceeded {
+		t.Fatal("上报设备数少于下限时不应自动终止")
+	}
+	stats.Outcomes[models.ReportMountFailed]++
+	if _, exceeded := autoHalt.Exceeded(stats); !exceeded {
+		t.Fatal("上报设备数达到下限且失败率超过阈值时应自动终止")
+	}
+}
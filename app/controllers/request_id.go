@@ -0,0 +1,37 @@
+package controllers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+
+	"hotupdate/app/utils"
+)
+
+// 沿用客户端或反向代理传入的请求ID时，只接受长度有限的安全字符，避免日志注入
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// RequestID 为每个请求分配请求ID：沿用请求头X-Request-ID，没有或无效时生成新的ID。
+// 请求ID写入响应头，并记录在请求上下文中，处理请求时记录的日志都带有该ID
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(utils.RequestIDHeader)
+		if !requestIDPattern.MatchString(requestID) {
+			requestID = newRequestID()
+		}
+
+		c.Set("requestID", requestID)
+		c.Header(utils.RequestIDHeader, requestID)
+		c.Request = c.Request.WithContext(utils.WithRequestID(c.Request.Context(), requestID))
+		c.Next()
+	}
+}
+
+// 生成随机的请求ID
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,136 @@
+package controllers
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"hotupdate/app/models"
+)
+
+// 注册灰度发布路由，调整灰度需要发布经理及以上角色
+func setupRolloutRoutes(r *gin.Engine) {
+	releaseAuth := RoleRequired(models.RoleReleaseManager)
+
+	r.POST("/api/apps/:app_id/versions/:version_id/rollout", releaseAuth, SetRollout)
+	r.POST("/api/apps/:app_id/versions/:version_id/rollout/pause", releaseAuth, PauseRollout)
+	r.POST("/api/apps/:app_id/versions/:version_id/rollout/resume", releaseAuth, ResumeRollout)
+	r.POST("/api/apps/:app_id/versions/:version_id/rollout/halt", releaseAuth, HaltRollout)
+}
+
+// SetRollout 设置版本的灰度比例，用于逐步放量或重新开始已终止的灰度。
+// 比例只能提高：降低比例不会移除已更新的设备，只会悄悄停止向其他设备提供此版本，
+// 需要停止放量时应终止灰度，终止后可以用更低的比例重新开始
+func SetRollout(c *gin.Context) {
+	percentage, err := strconv.Atoi(c.PostForm("percentage"))
+	if err != nil || !models.ValidRolloutPercentage(percentage) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "灰度比例必须是1到100之间的整数"})
+		return
+	}
+
+	updateRollout(c, func(version *models.Version) error {
+		switch {
+		case version.Rollout == nil:
+			if percentage < 100 {
+				return newRequestError(http.StatusConflict, "版本已全量发布，不能降低灰度比例")
+			}
+		case version.Rollout.Status != models.RolloutHalted && percentage < version.Rollout.Percentage:
+			return newRequestError(http.StatusConflict, "灰度比例不能低于当前的 "+strconv.Itoa(version.Rollout.Percentage)+"%，如需停止放量请终止灰度")
+		}
+		version.Rollout = &models.Rollout{
+			Percentage: percentage,
+			Status:     models.RolloutActive,
+			UpdatedBy:  c.GetString("username"),
+			UpdatedAt:  time.Now(),
+		}
+		return nil
+	}, "灰度比例已调整为 "+strconv.Itoa(percentage)+"%")
+}
+
+// PauseRollout 暂停灰度，尚未更新的客户端暂时收不到此版本
+func PauseRollout(c *gin.Context) {
+	updateRollout(c, func(version *models.Version) error {
+		if version.Rollout == nil || version.Rollout.Status != models.RolloutActive {
+			return newRequestError(http.StatusConflict, "版本没有进行中的灰度发布")
+		}
+		version.Rollout.Status = models.RolloutPaused
+		version.Rollout.Reason = c.PostForm("reason")
+		version.Rollout.UpdatedBy = c.GetString("username")
+		version.Rollout.UpdatedAt = time.Now()
+		return nil
+	}, "灰度已暂停")
+}
+
+// ResumeRollout 恢复已暂停的灰度，比例保持不变
+func ResumeRollout(c *gin.Context) {
+	updateRollout(c, func(version *models.Version) error {
+		if version.Rollout == nil || version.Rollout.Status != models.RolloutPaused {
+			return newRequestError(http.StatusConflict, "版本的灰度发布没有暂停")
+		}
+		version.Rollout.Status = models.RolloutActive
+		version.Rollout.Reason = ""
+		version.Rollout.UpdatedBy = c.GetString("username")
+		version.Rollout.UpdatedAt = time.Now()
+		return nil
+	}, "灰度已恢复")
+}
+
+// HaltRollout 终止灰度，停止向尚未更新的客户端提供此版本。
+// 终止后不能恢复，需要重新设置比例
+func HaltRollout(c *gin.Context) {
+	updateRollout(c, func(version *models.Version) error {
+		if version.Rollout == nil || version.Rollout.Status == models.RolloutHalted {
+			return newRequestError(http.StatusConflict, "版本没有进行中的灰度发布")
+		}
+		version.Rollout.Status = models.RolloutHalted
+		version.Rollout.Reason = c.PostForm("reason")
+		version.Rollout.UpdatedBy = c.GetString("username")
+		version.Rollout.UpdatedAt = time.Now()
+		return nil
+	}, "灰度已终止")
+}
+
+// 在版本列表锁内修改版本的灰度设置并输出响应
+func updateRollout(c *gin.Context, fn func(version *models.Version) error, message string) {
+	appID := c.Param("app_id")
+	versionID := c.Param("version_id")
+
+	var updated models.Version
+	err := models.UpdateVersions(MetadataStore, appID, func(versionList *models.VersionList) error {
+		version, exists := models.GetVersion(versionList, versionID)
+		if !exists {
+			return newRequestError(http.StatusNotFound, "版本不存在")
+		}
+		if err := fn(&version); err != nil {
+			return err
+		}
+		models.SetVersion(versionList, version)
+		updated = version
+		return nil
+	})
+	if err != nil {
+		respondError(c, err, "无法保存灰度设置")
+		return
+	}
+
+	slog.InfoContext(c.Request.Context(), message, "app", appID, "version", versionID, "operator", c.GetString("username"))
+	c.JSON(http.StatusOK, gin.H{"message": message, "version": updated})
+}
+
+// 读取上传请求中的灰度比例，未指定时返回0表示全量发布。比例无效时输出错误响应
+func requestRollout(c *gin.Context) (int, bool) {
+	value := c.PostForm("rollout")
+	if value == "" {
+		return 0, true
+	}
+
+	percentage, err := strconv.Atoi(value)
+	if err != nil || !models.ValidRolloutPercentage(percentage) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "灰度比例必须是1到100之间的整数"})
+		return 0, false
+	}
+	return percentage, true
+}
@@ -0,0 +1,67 @@
+package controllers
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"hotupdate/app/models"
+)
+
+// 灰度比例只能提高，终止后可以用更低的比例重新开始
+func TestSetRolloutRejectsDecrease(t *testing.T) {
+	cookie := testLogin(t, "rollout-owner")
+	addTestVersion(t, "rollout", "2.0.0", testArtifactData(1024))
+	addTestVersion(t, "rollout", "2.1.0", testArtifactData(1024))
+	rolloutURL := "/api/apps/rollout/versions/2.1.0/rollout"
+	setRollout := func(percentage string) int {
+		return postForm(rolloutURL, url.Values{"percentage": {percentage}}, cookie).Code
+	}
+
+	if code := setRollout("50"); code != http.StatusConflict {
+		t.Errorf("全量发布的版本降低比例返回 %d，期望409", code)
+	}
+	err := models.UpdateVersions(MetadataStore, "rollout", func(versionList *models.VersionList) error {
+		version, _ := models.GetVersion(versionList, "2.1.0")
+		version.Rollout = &models.Rollout{Percentage: 10, Status: models.RolloutActive}
+		models.SetVersion(versionList, version)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, step := range []struct {
+		percentage string
+		want       int
+	}{
+		{"30", http.StatusOK},
+		{"30", http.StatusOK},
+		{"20", http.StatusConflict},
+	} {
+		if code := setRollout(step.percentage); code != step.want {
+			t.Errorf("设置比例 %s%% 返回 %d，期望 %d", step.percentage, code, step.want)
+		}
+	}
+
+	if w := postForm(rolloutURL+"/pause", nil, cookie); w.Code != http.StatusOK {
+		t.Fatalf("暂停灰度返回 %d: %s", w.Code, w.Body.String())
+	}
+	if code := setRollout("20"); code != http.StatusConflict {
+		t.Errorf("暂停后降低比例返回 %d，期望409", code)
+	}
+
+	if w := postForm(rolloutURL+"/halt", url.Values{"reason": {"崩溃率上升"}}, cookie); w.Code != http.StatusOK {
+		t.Fatalf("终止灰度返回 %d: %s", w.Code, w.Body.String())
+	}
+	if code := setRollout("5"); code != http.StatusOK {
+		t.Fatalf("终止后重新开始灰度返回 %d", code)
+	}
+	versionList, err := models.LoadVersions(MetadataStore, "rollout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version, _ := models.GetVersion(versionList, "2.1.0"); version.Rollout.Percentage != 5 || version.Rollout.Status != models.RolloutActive {
+		t.Errorf("重新开始后的灰度为 %+v", version.Rollout)
+	}
+}
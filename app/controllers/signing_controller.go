@@ -0,0 +1,62 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"hotupdate/app/models"
+	"hotupdate/app/signing"
+)
+
+// ManifestSigner 更新清单签名器，未配置签名密钥时为nil
+var ManifestSigner *signing.Signer
+
+// SignedManifest 已签名的更新清单。签名覆盖payload解码后的原始字节，
+// 客户端应先验证签名，再解析payload，而不是重新序列化清单
+type SignedManifest struct {
+	Payload string `json:"payload"` // base64编码的清单JSON
+	signing.Signature
+}
+
+// SetupSigningController 设置签名控制器
+func SetupSigningController(r *gin.Engine, signer *signing.Signer) {
+	ManifestSigner = signer
+
+	// 公钥是公开信息，客户端无需认证即可获取
+	r.GET("/api/signing/keys", ListSigningKeys)
+}
+
+// ListSigningKeys 发布清单签名公钥
+func ListSigningKeys(c *gin.Context) {
+	if ManifestSigner == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "服务器未配置清单签名密钥"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"keys": ManifestSigner.PublicKeys()})
+}
+
+// 签名更新清单，未配置签名密钥时返回nil。清单没有更新包哈希时拒绝签名，
+// 否则客户端无法用签名校验下载的更新包
+func signManifest(manifest models.UpdateManifest) (*SignedManifest, error) {
+	if ManifestSigner == nil {
+		return nil, nil
+	}
+	if manifest.SHA256 == "" {
+		return nil, fmt.Errorf("版本 %s 的更新包没有哈希，不能签名", manifest.Version)
+	}
+
+	payload, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SignedManifest{
+		Payload:   base64.StdEncoding.EncodeToString(payload),
+		Signature: ManifestSigner.Sign(payload),
+	}, nil
+}
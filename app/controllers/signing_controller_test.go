@@ -0,0 +1,91 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"hotupdate/app/models"
+	"hotupdate/app/signing"
+)
+
+// 哈希功能之前上传的版本没有记录哈希，签名清单前先计算哈希，不会签出哈希为空的清单
+func TestSignedManifestHashesLegacyVersion(t *testing.T) {
+	privateKey, _, err := signing.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := signing.New(signing.Config{ActiveKeyID: "test", Keys: []signing.KeyConfig{{ID: "test", PrivateKey: privateKey}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ManifestSigner = signer
+	defer func() { ManifestSigner = nil }()
+
+	version := addTestVersion(t, "legacy", "2.7.0", testArtifactData(4<<10))
+	err = models.UpdateVersions(MetadataStore, "legacy", func(versionList *models.VersionList) error {
+		legacy, _ := models.GetVersion(versionList, "2.7.0")
+		legacy.SHA256, legacy.MD5, legacy.CRC32 = "", "", ""
+		models.SetVersion(versionList, legacy)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := serve(http.MethodGet, "/api/apps/legacy/check?version=2.6.0", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("检查更新返回 %d: %s", w.Code, w.Body.String())
+	}
+	var response struct {
+		SHA256   string          `json:"sha256"`
+		Manifest *SignedManifest `json:"manifest"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.SHA256 != version.SHA256 {
+		t.Errorf("响应中的sha256 = %q，期望 %q", response.SHA256, version.SHA256)
+	}
+	if response.Manifest == nil {
+		t.Fatal("响应中没有签名清单")
+	}
+	payload, err := base64.StdEncoding.DecodeString(response.Manifest.Payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var manifest models.UpdateManifest
+	if err := json.Unmarshal(payload, &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if manifest.SHA256 != version.SHA256 {
+		t.Errorf("签名清单中的sha256 = %q，期望 %q", manifest.SHA256, version.SHA256)
+	}
+
+	// 计算出的哈希已保存，之后不再重新计算
+	versionList, err := models.LoadVersions(MetadataStore, "legacy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved, _ := models.GetVersion(versionList, "2.7.0"); saved.SHA256 != version.SHA256 {
+		t.Errorf("保存的sha256 = %q", saved.SHA256)
+	}
+}
+
+func TestSignManifestRefusesEmptyHash(t *testing.T) {
+	privateKey, _, err := signing.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := signing.New(signing.Config{ActiveKeyID: "test", Keys: []signing.KeyConfig{{ID: "test", PrivateKey: privateKey}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ManifestSigner = signer
+	defer func() { ManifestSigner = nil }()
+
+	if _, err := signManifest(models.UpdateManifest{AppID: "game", Version: "1.0.0"}); err == nil {
+		t.Error("没有哈希的清单不应被签名")
+	}
+}
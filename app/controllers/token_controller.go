@@ -0,0 +1,215 @@
+package controllers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"hotupdate/app/models"
+)
+
+const (
+	// 令牌明文前缀，便于在日志和密钥扫描中识别
+	tokenPrefix = "hut_"
+	// 最后使用时间的更新间隔，避免每个请求都重写令牌文件
+	tokenTouchInterval = time.Minute
+)
+
+// 注册令牌管理路由
+func setupTokenRoutes(r *gin.Engine) {
+	ownerAuth := RoleRequired(models.RoleOwner)
+
+	r.POST("/api/apps/:app_id/tokens", ownerAuth, CreateToken)
+	r.GET("/api/apps/:app_id/tokens", ownerAuth, ListTokens)
+	r.DELETE("/api/apps/:app_id/tokens/:token_id", ownerAuth, RevokeToken)
+}
+
+// CreateToken 为应用创建API令牌，令牌明文只在创建时返回一次
+func CreateToken(c *gin.Context) {
+	appID := c.Param("app_id")
+
+	// 验证应用是否存在
+	appList, err := models.LoadApps(MetadataStore)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "无法加载应用列表"})
+		return
+	}
+
+	if _, exists := models.GetApp(appList, appID); !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "应用不存在"})
+		return
+	}
+
+	name := c.PostForm("name")
+	permission := models.TokenPermission(c.DefaultPostForm("permission", string(models.TokenPermissionPublish)))
+
+	if name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "令牌名称不能为空"})
+		return
+	}
+
+	if !permission.Valid() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的令牌权限，可选值：read、publish、admin"})
+		return
+	}
+
+	tokenID, err := randomHex(8)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "无法生成令牌"})
+		return
+	}
+	secret, err := randomHex(32)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "无法生成令牌"})
+		return
+	}
+
+	token := models.APIToken{
+		ID:         tokenID,
+		AppID:      appID,
+		Name:       name,
+		Permission: permission,
+		SecretHash: models.HashTokenSecret(secret),
+		CreatedAt:  time.Now(),
+		CreatedBy:  c.GetString("username"),
+	}
+
+	err = models.UpdateTokens(MetadataStore, func(tokenList *models.TokenList) error {
+		models.AddToken(tokenList, token)
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存令牌列表失败"})
+		return
+	}
+
+	slog.InfoContext(c.Request.Context(), "已创建API令牌", "app", appID, "token", token.ID, "name", token.Name, "permission", token.Permission, "operator", c.GetString("username"))
+	c.JSON(http.StatusOK, gin.H{
+		"message": "令牌创建成功，请妥善保存，令牌只会显示一次",
+		"token":   tokenPrefix + tokenID + "_" + secret,
+		"info":    token.Public(),
+	})
+}
+
+// ListTokens 列出应用的API令牌
+func ListTokens(c *gin.Context) {
+	appID := c.Param("app_id")
+
+	tokenList, err := models.LoadTokens(MetadataStore)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "无法加载令牌列表"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"tokens": models.ListAppTokens(tokenList, appID)})
+}
+
+// RevokeToken 吊销应用的API令牌
+func RevokeToken(c *gin.Context) {
+	appID := c.Param("app_id")
+	tokenID := c.Param("token_id")
+
+	err := models.UpdateTokens(MetadataStore, func(tokenList *models.TokenList) error {
+		token, exists := models.GetToken(tokenList, tokenID)
+		if !exists || token.AppID != appID {
+			return newRequestError(http.StatusNotFound, "令牌不存在")
+		}
+
+		if token.Revoked() {
+			return newRequestError(http.StatusBadRequest, "令牌已被吊销")
+		}
+
+		models.RevokeToken(tokenList, tokenID, time.Now())
+		return nil
+	})
+	if err != nil {
+		respondError(c, err, "保存令牌列表失败")
+		return
+	}
+
+	slog.InfoContext(c.Request.Context(), "已吊销API令牌", "app", appID, "token", tokenID, "operator", c.GetString("username"))
+	c.JSON(http.StatusOK, gin.H{"message": "令牌已吊销"})
+}
+
+// 校验Bearer令牌，要求令牌属于指定应用并具有所需权限
+func authenticateToken(ctx context.Context, rawToken string, appID string, required models.TokenPermission) (models.APIToken, int, string) {
+	tokenID, secret, ok := parseToken(rawToken)
+	if !ok {
+		return models.APIToken{}, http.StatusUnauthorized, "无效的API令牌"
+	}
+
+	tokenList, err := models.LoadTokens(MetadataStore)
+	if err != nil {
+		return models.APIToken{}, http.StatusInternalServerError, "无法加载令牌列表"
+	}
+
+	token, exists := models.GetToken(tokenList, tokenID)
+	if !exists || token.Revoked() {
+		return models.APIToken{}, http.StatusUnauthorized, "无效的API令牌"
+	}
+
+	hash := models.HashTokenSecret(secret)
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(token.SecretHash)) != 1 {
+		return models.APIToken{}, http.StatusUnauthorized, "无效的API令牌"
+	}
+
+	if appID == "" || token.AppID != appID {
+		return models.APIToken{}, http.StatusForbidden, "令牌无权访问该应用"
+	}
+
+	if !token.Permission.Allows(required) {
+		return models.APIToken{}, http.StatusForbidden, "令牌权限不足"
+	}
+
+	// 记录最后使用时间
+	now := time.Now()
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) >= tokenTouchInterval {
+		err := models.UpdateTokens(MetadataStore, func(tokenList *models.TokenList) error {
+			models.TouchToken(tokenList, token.ID, now)
+			return nil
+		})
+		if err != nil {
+			slog.ErrorContext(ctx, "更新令牌最后使用时间失败", "token", token.ID, "error", err)
+		}
+	}
+
+	return token, http.StatusOK, ""
+}
+
+// 解析令牌明文，格式为 hut_<令牌ID>_<密钥>
+func parseToken(rawToken string) (string, string, bool) {
+	if !strings.HasPrefix(rawToken, tokenPrefix) {
+		return "", "", false
+	}
+
+	tokenID, secret, found := strings.Cut(strings.TrimPrefix(rawToken, tokenPrefix), "_")
+	if !found || tokenID == "" || secret == "" {
+		return "", "", false
+	}
+	return tokenID, secret, true
+}
+
+// 从Authorization请求头中提取Bearer令牌
+func bearerToken(c *gin.Context) string {
+	header := c.GetHeader("Authorization")
+	if len(header) > 7 && strings.EqualFold(header[:7], "Bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+	return ""
+}
+
+// 生成指定字节数的随机十六进制字符串
+func randomHex(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
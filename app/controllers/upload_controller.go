@@ -0,0 +1,727 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"hotupdate/app/metrics"
+	"hotupdate/app/models"
+	"hotupdate/app/utils"
+)
+
+const (
+	// 上传会话在最后一次接收分片后的有效期
+	uploadSessionTTL = 24 * time.Hour
+	// 过期上传会话的清理间隔
+	uploadCleanupInterval = 10 * time.Minute
+	// 发布完成后会话保留的时间，供客户端查询发布结果
+	uploadResultTTL = time.Hour
+	// 发布任务队列的长度
+	finalizeQueueSize = 16
+	// 单个分片的大小上限
+	maxChunkSize = 256 << 20
+	// 分片校验失败的状态码，沿用tus协议的约定
+	statusChecksumMismatch = 460
+)
+
+// 每个上传会话一把锁，保证同一会话的分片按顺序写入，且不与完成、取消和清理并发
+var (
+	uploadLocksMu sync.Mutex
+	uploadLocks   = make(map[string]*sync.Mutex)
+)
+
+// 注册分片上传路由并启动过期会话清理协程
+func setupUploadRoutes(r *gin.Engine) {
+	uploaderAuth := RoleRequired(models.RoleUploader)
+
+	r.POST("/api/apps/:app_id/uploads", uploaderAuth, CreateUpload)
+	r.HEAD("/api/apps/:app_id/uploads/:upload_id", uploaderAuth, UploadProgress)
+	r.GET("/api/apps/:app_id/uploads/:upload_id", uploaderAuth, GetUpload)
+	r.PUT("/api/apps/:app_id/uploads/:upload_id", uploaderAuth, UploadChunk)
+	r.POST("/api/apps/:app_id/uploads/:upload_id/finalize", uploaderAuth, FinalizeUpload)
+	r.DELETE("/api/apps/:app_id/uploads/:upload_id", uploaderAuth, AbortUpload)
+
+	go runUploadJanitor()
+	go runFinalizeWorker()
+}
+
+// CreateUpload 创建分片上传会话，版本信息在创建时提供，全部分片上传完成后发布
+func CreateUpload(c *gin.Context) {
+	appID := c.Param("app_id")
+
+	appList, err := models.LoadApps(MetadataStore)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "无法加载应用列表"})
+		return
+	}
+	app, exists := models.GetApp(appList, appID)
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "应用不存在"})
+		return
+	}
+
+	channel, ok := requestChannel(c, appID, c.PostForm("channel"))
+	if !ok {
+		return
+	}
+
+	// 在开始上传之前拒绝格式错误、重复或不递增的版本号
+	versionID := c.PostForm("version_id")
+	if !checkNewVersion(c, app, channel, versionID) {
+		return
+	}
+
+	size, err := strconv.ParseInt(c.PostForm("size"), 10, 64)
+	if err != nil || size <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "文件大小无效"})
+		return
+	}
+
+	expectedSHA256 := strings.ToLower(c.PostForm("sha256"))
+	if expectedSHA256 != "" {
+		if decoded, err := hex.DecodeString(expectedSHA256); err != nil || len(decoded) != sha256.Size {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "SHA-256格式无效"})
+			return
+		}
+	}
+
+	forceUpdate := c.PostForm("force") == "true"
+	if forceUpdate && !callerRole(c).AtLeast(models.RoleReleaseManager) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "权限不足，设置强制更新需要角色: " + string(models.RoleReleaseManager)})
+		return
+	}
+
+	rollout, ok := requestRollout(c)
+	if !ok {
+		return
+	}
+
+	compatibility, ok := requestCompatibility(c, app)
+	if !ok {
+		return
+	}
+
+	uploadID, err := randomHex(16)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "无法生成上传会话"})
+		return
+	}
+
+	now := time.Now()
+	session := models.UploadSession{
+		ID:            uploadID,
+		AppID:         appID,
+		VersionID:     versionID,
+		Name:          c.PostForm("name"),
+		Description:   c.PostForm("description"),
+		ReleaseNotes:  c.PostForm("release_notes"),
+		Force:         forceUpdate,
+		Cumulative:    c.PostForm("cumulative") == "true",
+		Channel:       channel,
+		Rollout:       rollout,
+		Compatibility: compatibility,
+		Size:          size,
+		SHA256:        expectedSHA256,
+		CreatedBy:     c.GetString("username"),
+		CreatedAt:     now,
+		UpdatedAt:     now,
+		ExpiresAt:     now.Add(uploadSessionTTL),
+	}
+
+	// 先保存会话再创建临时文件，清理协程只删除没有对应会话的临时文件
+	err = models.UpdateUploadSessions(MetadataStore, func(sessionList *models.UploadSessionList) error {
+		models.SetUploadSession(sessionList, session)
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "无法保存上传会话"})
+		return
+	}
+
+	if err := os.MkdirAll(uploadPartDir(), 0755); err != nil {
+		slog.ErrorContext(c.Request.Context(), "创建上传临时目录失败", "error", err)
+	}
+	file, err := os.Create(uploadPartPath(uploadID))
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "创建上传临时文件失败", "error", err)
+		removeUploadSession(c.Request.Context(), uploadID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "无法创建上传会话"})
+		return
+	}
+	file.Close()
+
+	slog.InfoContext(c.Request.Context(), "已创建上传会话", "app", appID, "upload_id", uploadID, "version", versionID, "size", size)
+	c.Header("Location", uploadURL(appID, uploadID))
+	c.JSON(http.StatusCreated, gin.H{"message": "上传会话已创建", "upload": session})
+}
+
+// UploadProgress 以响应头返回上传进度，客户端断线重连后据此从Upload-Offset继续上传
+func UploadProgress(c *gin.Context) {
+	session, ok := findUploadSession(c)
+	if !ok {
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	setUploadHeaders(c, session)
+	c.Status(http.StatusOK)
+}
+
+// GetUpload 获取上传会话信息和进度
+func GetUpload(c *gin.Context) {
+	session, ok := findUploadSession(c)
+	if !ok {
+		return
+	}
+
+	setUploadHeaders(c, session)
+	respondUploadStatus(c, http.StatusOK, session)
+}
+
+// UploadChunk 接收一个分片。请求体为分片内容，Upload-Offset为分片在文件中的起始位置，
+// 必须等于已接收的字节数；Upload-Checksum为分片的校验和，格式为"sha256 <base64>"
+func UploadChunk(c *gin.Context) {
+	uploadID := c.Param("upload_id")
+
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Upload-Offset无效"})
+		return
+	}
+
+	expectedSum, err := parseUploadChecksum(c.GetHeader("Upload-Checksum"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	unlock := lockUpload(uploadID)
+	defer unlock()
+
+	session, ok := findUploadSession(c)
+	if !ok {
+		return
+	}
+
+	if session.Status != "" && session.Status != models.UploadFailed {
+		c.JSON(http.StatusConflict, gin.H{"error": "上传已提交发布，不能再上传分片", "status": session.Status})
+		return
+	}
+
+	if offset != session.Offset {
+		setUploadHeaders(c, session)
+		c.JSON(http.StatusConflict, gin.H{"error": "Upload-Offset与已接收的字节数不一致", "offset": session.Offset})
+		return
+	}
+
+	file, err := os.OpenFile(uploadPartPath(uploadID), os.O_WRONLY, 0)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "打开上传临时文件失败", "upload_id", uploadID, "error", err)
+		c.JSON(http.StatusGone, gin.H{"error": "上传数据已丢失，请重新创建上传会话"})
+		return
+	}
+	defer file.Close()
+
+	if info, err := file.Stat(); err != nil || info.Size() < session.Offset {
+		c.JSON(http.StatusGone, gin.H{"error": "上传数据已丢失，请重新创建上传会话"})
+		return
+	}
+
+	// 丢弃上次中断的请求写入的未确认数据
+	if err := file.Truncate(offset); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "无法写入上传数据"})
+		return
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "无法写入上传数据"})
+		return
+	}
+
+	// 多读一个字节，用于判断分片是否超出文件剩余大小
+	remaining := session.Size - offset
+	limit := remaining
+	if limit > maxChunkSize {
+		limit = maxChunkSize
+	}
+	hash := sha256.New()
+	written, err := io.Copy(file, io.TeeReader(io.LimitReader(c.Request.Body, limit+1), hash))
+
+	// 写入失败或校验不通过时截断回分片起始位置，客户端可以重新上传该分片
+	rollback := func() {
+		if err := file.Truncate(offset); err != nil {
+			slog.ErrorContext(c.Request.Context(), "回滚上传分片失败", "upload_id", uploadID, "error", err)
+		}
+	}
+
+	switch {
+	case err != nil:
+		rollback()
+		slog.ErrorContext(c.Request.Context(), "接收上传分片失败", "upload_id", uploadID, "error", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "分片接收不完整"})
+		return
+	case written > limit:
+		rollback()
+		if limit < remaining {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "分片大小超过上限", "maxChunkSize": maxChunkSize})
+		} else {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "分片超出文件剩余大小", "remaining": remaining})
+		}
+		return
+	case written == 0:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "分片内容为空"})
+		return
+	case !bytes.Equal(hash.Sum(nil), expectedSum):
+		rollback()
+		setUploadHeaders(c, session)
+		c.JSON(statusChecksumMismatch, gin.H{"error": "分片SHA-256校验失败"})
+		return
+	}
+
+	if err := file.Sync(); err != nil {
+		rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "无法写入上传数据"})
+		return
+	}
+
+	now := time.Now()
+	err = models.UpdateUploadSessions(MetadataStore, func(sessionList *models.UploadSessionList) error {
+		stored, exists := models.GetUploadSession(sessionList, uploadID)
+		if !exists {
+			return newRequestError(http.StatusNotFound, "上传会话不存在或已过期")
+		}
+		stored.Offset = offset + written
+		stored.UpdatedAt = now
+		stored.ExpiresAt = now.Add(uploadSessionTTL)
+		models.SetUploadSession(sessionList, stored)
+		session = stored
+		return nil
+	})
+	if err != nil {
+		rollback()
+		respondError(c, err, "无法保存上传进度")
+		return
+	}
+
+	setUploadHeaders(c, session)
+	c.JSON(http.StatusOK, gin.H{"upload": session})
+}
+
+// FinalizeUpload 提交发布：确认文件已全部上传后返回202，由后台任务校验整个文件并发布为新版本。
+// 客户端通过GET会话地址轮询status，completed时响应中带有发布的版本，failed时带有失败原因
+func FinalizeUpload(c *gin.Context) {
+	appID := c.Param("app_id")
+	uploadID := c.Param("upload_id")
+
+	unlock := lockUpload(uploadID)
+	defer unlock()
+
+	session, ok := findUploadSession(c)
+	if !ok {
+		return
+	}
+
+	switch session.Status {
+	case models.UploadFinalizing:
+		respondUploadStatus(c, http.StatusAccepted, session)
+		return
+	case models.UploadCompleted:
+		respondUploadStatus(c, http.StatusOK, session)
+		return
+	}
+
+	if !session.Complete() {
+		setUploadHeaders(c, session)
+		c.JSON(http.StatusConflict, gin.H{"error": "文件尚未上传完成", "offset": session.Offset, "size": session.Size})
+		return
+	}
+
+	if info, err := os.Stat(uploadPartPath(uploadID)); err != nil || info.Size() != session.Size {
+		c.JSON(http.StatusGone, gin.H{"error": "上传数据已丢失，请重新创建上传会话"})
+		return
+	}
+
+	// 先保存发布状态再提交任务，之后的分片、取消和重复提交都会看到该状态
+	session, err := setUploadStatus(uploadID, func(stored *models.UploadSession) {
+		stored.Status = models.UploadFinalizing
+		stored.Error, stored.ErrorCode, stored.ErrorEntry = "", "", ""
+		stored.ExpiresAt = time.Now().Add(uploadSessionTTL)
+	})
+	if err != nil {
+		respondError(c, err, "无法保存上传会话")
+		return
+	}
+
+	if !scheduleFinalize(finalizeJob{appID: appID, uploadID: uploadID, requestID: c.GetString("requestID")}) {
+		setUploadStatus(uploadID, func(stored *models.UploadSession) { stored.Status = "" })
+		c.Header("Retry-After", "30")
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "发布任务过多，请稍后重新提交"})
+		return
+	}
+
+	slog.InfoContext(c.Request.Context(), "已提交发布上传的版本", "app", appID, "upload_id", uploadID, "version", session.VersionID)
+	respondUploadStatus(c, http.StatusAccepted, session)
+}
+
+// 返回上传会话的发布状态，已发布时附带发布的版本
+func respondUploadStatus(c *gin.Context, status int, session models.UploadSession) {
+	body := gin.H{"upload": session}
+	switch session.Status {
+	case models.UploadFinalizing:
+		c.Header("Location", uploadURL(session.AppID, session.ID))
+		c.Header("Retry-After", "5")
+		body["message"] = "正在发布，请稍后查询上传会话的状态"
+	case models.UploadCompleted:
+		body["message"] = "版本创建成功"
+		if versionList, err := models.LoadVersions(MetadataStore, session.AppID); err == nil {
+			if version, exists := models.GetVersion(versionList, session.VersionID); exists {
+				body["version"] = version
+			}
+		}
+	}
+	c.JSON(status, body)
+}
+
+// finalizeJob 一个发布上传版本的后台任务
+type finalizeJob struct {
+	appID     string
+	uploadID  string
+	requestID string // 提交发布的请求ID，后台任务记录的日志带有该ID
+}
+
+// 发布任务队列，由单个后台协程顺序处理。校验和保存几GB的更新包需要较长时间，不占用请求和会话锁
+var finalizeJobs = make(chan finalizeJob, finalizeQueueSize)
+
+// 提交发布任务，队列已满时返回false
+func scheduleFinalize(job finalizeJob) bool {
+	select {
+	case finalizeJobs <- job:
+		return true
+	default:
+		return false
+	}
+}
+
+// 后台顺序处理发布任务，先重新提交服务重启前未完成的任务
+func runFinalizeWorker() {
+	resumeFinalizeJobs()
+	for job := range finalizeJobs {
+		finalizeUpload(job)
+	}
+}
+
+// 重新提交服务重启前处于发布中的上传会话
+func resumeFinalizeJobs() {
+	sessionList, err := models.LoadUploadSessions(MetadataStore)
+	if err != nil {
+		slog.Error("加载上传会话失败", "error", err)
+		return
+	}
+	for _, session := range sessionList.Sessions {
+		if session.Status != models.UploadFinalizing {
+			continue
+		}
+		if !scheduleFinalize(finalizeJob{appID: session.AppID, uploadID: session.ID}) {
+			failUpload(context.Background(), session.ID, errors.New("发布任务过多，请重新提交发布"))
+		}
+	}
+}
+
+// 校验上传的文件并发布为新版本，结果记录在上传会话中。发布成功后删除临时文件，
+// 会话保留uploadResultTTL供客户端查询结果
+func finalizeUpload(job finalizeJob) {
+	ctx := context.Background()
+	if job.requestID != "" {
+		ctx = utils.WithRequestID(ctx, job.requestID)
+	}
+
+	sessionList, err := models.LoadUploadSessions(MetadataStore)
+	if err != nil {
+		failUpload(ctx, job.uploadID, err)
+		return
+	}
+	session, exists := models.GetUploadSession(sessionList, job.uploadID)
+	if !exists || session.Status != models.UploadFinalizing {
+		return
+	}
+
+	file, err := os.Open(uploadPartPath(job.uploadID))
+	if err != nil {
+		failUpload(ctx, job.uploadID, errors.New("上传数据已丢失，请重新创建上传会话"))
+		return
+	}
+	defer file.Close()
+
+	appList, err := models.LoadApps(MetadataStore)
+	if err != nil {
+		failUpload(ctx, job.uploadID, err)
+		return
+	}
+	app, exists := models.GetApp(appList, job.appID)
+	if !exists {
+		failUpload(ctx, job.uploadID, errors.New("应用不存在"))
+		return
+	}
+
+	newVersion, err := publishVersion(ctx, app, versionRequest{
+		ID:            session.VersionID,
+		Name:          session.Name,
+		Description:   session.Description,
+		ReleaseNotes:  session.ReleaseNotes,
+		Force:         session.Force,
+		Cumulative:    session.Cumulative,
+		Channel:       session.Channel,
+		Rollout:       session.Rollout,
+		Publisher:     session.CreatedBy,
+		SHA256:        session.SHA256,
+		Compatibility: session.Compatibility,
+	}, file, session.Size)
+	if err != nil {
+		failUpload(ctx, job.uploadID, err)
+		return
+	}
+
+	unlock := lockUpload(job.uploadID)
+	defer unlock()
+	if _, err := setUploadStatus(job.uploadID, func(stored *models.UploadSession) {
+		stored.Status = models.UploadCompleted
+		stored.ExpiresAt = time.Now().Add(uploadResultTTL)
+	}); err != nil {
+		slog.ErrorContext(ctx, "保存上传会话失败", "upload_id", job.uploadID, "error", err)
+	}
+	if err := os.Remove(uploadPartPath(job.uploadID)); err != nil && !os.IsNotExist(err) {
+		slog.ErrorContext(ctx, "删除上传临时文件失败", "error", err)
+	}
+	observeUpload(app.ID, metrics.UploadResumable, newVersion, session.CreatedAt)
+}
+
+// 记录发布失败，已上传的数据保留，客户端可以重新提交发布
+func failUpload(ctx context.Context, uploadID string, err error) {
+	var reqErr *requestError
+	var pkgErr *models.PackageError
+	message := err.Error()
+	switch {
+	case errors.As(err, &reqErr):
+		message = reqErr.message
+	case errors.As(err, &pkgErr):
+		message = pkgErr.Message
+	default:
+		slog.ErrorContext(ctx, "发布上传的版本失败", "upload_id", uploadID, "error", err)
+	}
+
+	unlock := lockUpload(uploadID)
+	defer unlock()
+	_, err = setUploadStatus(uploadID, func(stored *models.UploadSession) {
+		stored.Status = models.UploadFailed
+		stored.Error = message
+		if pkgErr != nil {
+			stored.ErrorCode, stored.ErrorEntry = pkgErr.Code, pkgErr.Entry
+		}
+		stored.ExpiresAt = time.Now().Add(uploadSessionTTL)
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "保存上传会话失败", "upload_id", uploadID, "error", err)
+	}
+}
+
+// 修改上传会话的发布状态并返回修改后的会话，调用方需持有会话的锁
+func setUploadStatus(uploadID string, fn func(stored *models.UploadSession)) (models.UploadSession, error) {
+	var session models.UploadSession
+	err := models.UpdateUploadSessions(MetadataStore, func(sessionList *models.UploadSessionList) error {
+		stored, exists := models.GetUploadSession(sessionList, uploadID)
+		if !exists {
+			return newRequestError(http.StatusNotFound, "上传会话不存在或已过期")
+		}
+		fn(&stored)
+		stored.UpdatedAt = time.Now()
+		models.SetUploadSession(sessionList, stored)
+		session = stored
+		return nil
+	})
+	return session, err
+}
+
+// AbortUpload 取消上传，删除会话和已上传的数据
+func AbortUpload(c *gin.Context) {
+	uploadID := c.Param("upload_id")
+
+	unlock := lockUpload(uploadID)
+	defer unlock()
+
+	session, ok := findUploadSession(c)
+	if !ok {
+		return
+	}
+	if session.Status == models.UploadFinalizing {
+		c.JSON(http.StatusConflict, gin.H{"error": "正在发布，不能取消上传"})
+		return
+	}
+
+	removeUploadSession(c.Request.Context(), uploadID)
+
+	slog.InfoContext(c.Request.Context(), "上传会话已取消", "upload_id", uploadID)
+	c.JSON(http.StatusOK, gin.H{"message": "上传已取消"})
+}
+
+// 查找当前请求的上传会话，会话不属于该应用或已过期时视为不存在
+func findUploadSession(c *gin.Context) (models.UploadSession, bool) {
+	sessionList, err := models.LoadUploadSessions(MetadataStore)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "无法加载上传会话"})
+		return models.UploadSession{}, false
+	}
+
+	session, exists := models.GetUploadSession(sessionList, c.Param("upload_id"))
+	if !exists || session.AppID != c.Param("app_id") || time.Now().After(session.ExpiresAt) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "上传会话不存在或已过期"})
+		return models.UploadSession{}, false
+	}
+	return session, true
+}
+
+// 设置上传进度响应头
+func setUploadHeaders(c *gin.Context, session models.UploadSession) {
+	c.Header("Upload-Offset", strconv.FormatInt(session.Offset, 10))
+	c.Header("Upload-Length", strconv.FormatInt(session.Size, 10))
+	c.Header("Upload-Expires", session.ExpiresAt.UTC().Format(http.TimeFormat))
+}
+
+// 解析Upload-Checksum请求头，返回SHA-256摘要
+func parseUploadChecksum(header string) ([]byte, error) {
+	if header == "" {
+		return nil, errors.New("缺少Upload-Checksum，分片必须附带SHA-256校验和")
+	}
+
+	algorithm, encoded, found := strings.Cut(strings.TrimSpace(header), " ")
+	if !found || !strings.EqualFold(algorithm, "sha256") {
+		return nil, errors.New("Upload-Checksum格式无效，应为\"sha256 <base64>\"")
+	}
+
+	sum, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil || len(sum) != sha256.Size {
+		return nil, errors.New("Upload-Checksum格式无效，应为\"sha256 <base64>\"")
+	}
+	return sum, nil
+}
+
+// 获取上传会话的锁，返回解锁函数
+func lockUpload(uploadID string) func() {
+	uploadLocksMu.Lock()
+	mu, ok := uploadLocks[uploadID]
+	if !ok {
+		mu = &sync.Mutex{}
+		uploadLocks[uploadID] = mu
+	}
+	uploadLocksMu.Unlock()
+
+	mu.Lock()
+	return mu.Unlock
+}
+
+// 删除上传会话和临时文件，调用方需持有会话的锁
+func removeUploadSession(ctx context.Context, uploadID string) {
+	err := models.UpdateUploadSessions(MetadataStore, func(sessionList *models.UploadSessionList) error {
+		models.DeleteUploadSession(sessionList, uploadID)
+		return nil
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "删除上传会话失败", "upload_id", uploadID, "error", err)
+	}
+
+	if err := os.Remove(uploadPartPath(uploadID)); err != nil && !os.IsNotExist(err) {
+		slog.ErrorContext(ctx, "删除上传临时文件失败", "error", err)
+	}
+
+	uploadLocksMu.Lock()
+	delete(uploadLocks, uploadID)
+	uploadLocksMu.Unlock()
+}
+
+// 定期清理过期的上传会话
+func runUploadJanitor() {
+	cleanupUploads()
+
+	ticker := time.NewTicker(uploadCleanupInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		cleanupUploads()
+	}
+}
+
+// 删除过期的上传会话，以及没有对应会话的临时文件
+func cleanupUploads() {
+	sessionList, err := models.LoadUploadSessions(MetadataStore)
+	if err != nil {
+		slog.Error("加载上传会话失败", "error", err)
+		return
+	}
+
+	active := make(map[string]bool, len(sessionList.Sessions))
+	for _, session := range sessionList.Sessions {
+		if !time.Now().After(session.ExpiresAt) || session.Status == models.UploadFinalizing {
+			active[session.ID] = true
+			continue
+		}
+
+		unlock := lockUpload(session.ID)
+		// 等待锁期间会话可能刚接收了分片而被续期，重新确认
+		if current, err := models.LoadUploadSessions(MetadataStore); err == nil {
+			if stored, exists := models.GetUploadSession(current, session.ID); exists && (!time.Now().After(stored.ExpiresAt) || stored.Status == models.UploadFinalizing) {
+				active[session.ID] = true
+				unlock()
+				continue
+			}
+		}
+		removeUploadSession(context.Background(), session.ID)
+		unlock()
+		slog.Info("已清理过期的上传会话", "upload_id", session.ID, "app", session.AppID,
+			"version", session.VersionID, "offset", session.Offset, "size", session.Size)
+	}
+
+	entries, err := os.ReadDir(uploadPartDir())
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		uploadID, isPart := strings.CutSuffix(entry.Name(), ".part")
+		if !isPart || active[uploadID] {
+			continue
+		}
+		// 会话可能在加载列表之后才创建，跳过刚创建的文件
+		if info, err := entry.Info(); err != nil || time.Since(info.ModTime()) < uploadCleanupInterval {
+			continue
+		}
+		if err := os.Remove(filepath.Join(uploadPartDir(), entry.Name())); err != nil {
+			slog.Error("删除上传临时文件失败", "error", err)
+		}
+	}
+}
+
+// 上传临时文件目录。无论使用哪种存储后端，分片都先写入本地磁盘，完成后再保存到存储
+func uploadPartDir() string {
+	return filepath.Join(UploadDir, ".uploads")
+}
+
+// 上传会话的临时文件路径
+func uploadPartPath(uploadID string) string {
+	return filepath.Join(uploadPartDir(), uploadID+".part")
+}
+
+// 获取上传会话的地址
+func uploadURL(appID string, uploadID string) string {
+	return "/api/apps/" + appID + "/uploads/" + uploadID
+}
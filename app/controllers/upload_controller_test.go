@@ -0,0 +1,313 @@
+package controllers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"hotupdate/app/models"
+)
+
+// 创建上传会话并返回会话ID
+func createTestUpload(t *testing.T, cookie *http.Cookie, appID string, versionID string, data []byte) string {
+	t.Helper()
+	sum := sha256.Sum256(data)
+	w := postForm("/api/apps/"+appID+"/uploads", url.Values{
+		"version_id": {versionID},
+		"size":       {strconv.Itoa(len(data))},
+		"sha256":     {hex.EncodeToString(sum[:])},
+	}, cookie)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("创建上传会话返回 %d: %s", w.Code, w.Body.String())
+	}
+	var body struct {
+		Upload models.UploadSession `json:"upload"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	return body.Upload.ID
+}
+
+// 上传一个分片，checksum为Upload-Checksum中的SHA-256摘要
+func putChunk(cookie *http.Cookie, appID string, uploadID string, offset int64, body io.Reader, checksum []byte) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPut, uploadURL(appID, uploadID), body)
+	req.Header.Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	req.Header.Set("Upload-Checksum", "sha256 "+base64.StdEncoding.EncodeToString(checksum))
+	req.AddCookie(cookie)
+	w := httptest.NewRecorder()
+	testServer.ServeHTTP(w, req)
+	return w
+}
+
+// 计算分片的SHA-256摘要
+func chunkSum(data []byte) []byte {
+	sum := sha256.Sum256(data)
+	return sum[:]
+}
+
+// 读取上传会话的状态
+func getTestUpload(t *testing.T, cookie *http.Cookie, appID string, uploadID string) (int, models.UploadSession) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, uploadURL(appID, uploadID), nil)
+	req.AddCookie(cookie)
+	w := httptest.NewRecorder()
+	testServer.ServeHTTP(w, req)
+	var body struct {
+		Upload models.UploadSession `json:"upload"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &body)
+	return w.Code, body.Upload
+}
+
+// 读取到一半时返回错误的请求体，模拟上传中断的连接
+type brokenReader struct {
+	data []byte
+}
+
+func (r *brokenReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, errors.New("连接已断开")
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+// 上传临时文件的当前大小
+func partSize(t *testing.T, uploadID string) int64 {
+	t.Helper()
+	info, err := os.Stat(uploadPartPath(uploadID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.Size()
+}
+
+// Upload-Offset与已接收的字节数不一致时返回409和服务端的进度
+func TestUploadOffsetMismatch(t *testing.T) {
+	cookie := testLogin(t, "upload-offset")
+	appID := "upload-offset"
+	addTestVersion(t, appID, "2.0.0", testArtifactData(1024))
+	data := testArtifactData(4096)
+	uploadID := createTestUpload(t, cookie, appID, "2.1.0", data)
+
+	w := putChunk(cookie, appID, uploadID, 100, bytes.NewReader(data[100:200]), chunkSum(data[100:200]))
+	if w.Code != http.StatusConflict {
+		t.Fatalf("偏移不一致时返回 %d，期望409", w.Code)
+	}
+	if got := w.Header().Get("Upload-Offset"); got != "0" {
+		t.Errorf("Upload-Offset = %s，期望0", got)
+	}
+
+	if w := putChunk(cookie, appID, uploadID, 0, bytes.NewReader(data[:100]), chunkSum(data[:100])); w.Code != http.StatusOK {
+		t.Fatalf("上传第一个分片返回 %d: %s", w.Code, w.Body.String())
+	}
+	// 重复发送已确认的分片
+	w = putChunk(cookie, appID, uploadID, 0, bytes.NewReader(data[:100]), chunkSum(data[:100]))
+	if w.Code != http.StatusConflict || w.Header().Get("Upload-Offset") != "100" {
+		t.Errorf("重复上传分片返回 %d，Upload-Offset = %s", w.Code, w.Header().Get("Upload-Offset"))
+	}
+}
+
+// 分片接收失败或超出剩余大小时截断回分片起始位置，之后可以重新上传该分片
+func TestUploadChunkRollback(t *testing.T) {
+	cookie := testLogin(t, "upload-rollback")
+	appID := "upload-rollback"
+	addTestVersion(t, appID, "2.0.0", testArtifactData(1024))
+	data := testArtifactData(4096)
+	uploadID := createTestUpload(t, cookie, appID, "2.1.0", data)
+
+	if w := putChunk(cookie, appID, uploadID, 0, bytes.NewReader(data[:1000]), chunkSum(data[:1000])); w.Code != http.StatusOK {
+		t.Fatalf("上传第一个分片返回 %d: %s", w.Code, w.Body.String())
+	}
+
+	// 连接在分片中途断开
+	chunk := data[1000:3000]
+	w := putChunk(cookie, appID, uploadID, 1000, &brokenReader{data: chunk[:500]}, chunkSum(chunk))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("分片接收不完整时返回 %d，期望400", w.Code)
+	}
+	if size := partSize(t, uploadID); size != 1000 {
+		t.Errorf("接收失败后临时文件为 %d 字节，期望截断回1000", size)
+	}
+
+	// 分片超出文件剩余大小
+	oversized := append(append([]byte(nil), data[1000:]...), 0)
+	w = putChunk(cookie, appID, uploadID, 1000, bytes.NewReader(oversized), chunkSum(oversized))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("分片超出剩余大小时返回 %d，期望413", w.Code)
+	}
+	if size := partSize(t, uploadID); size != 1000 {
+		t.Errorf("分片过大被拒绝后临时文件为 %d 字节，期望1000", size)
+	}
+
+	if _, session := getTestUpload(t, cookie, appID, uploadID); session.Offset != 1000 {
+		t.Errorf("失败的分片不应计入进度，offset = %d", session.Offset)
+	}
+	if w := putChunk(cookie, appID, uploadID, 1000, bytes.NewReader(chunk), chunkSum(chunk)); w.Code != http.StatusOK {
+		t.Fatalf("重新上传分片返回 %d: %s", w.Code, w.Body.String())
+	}
+	if size := partSize(t, uploadID); size != 3000 {
+		t.Errorf("重新上传后临时文件为 %d 字节，期望3000", size)
+	}
+}
+
+// 分片的SHA-256与Upload-Checksum不一致时返回460并丢弃该分片
+func TestUploadChecksumMismatch(t *testing.T) {
+	cookie := testLogin(t, "upload-checksum")
+	appID := "upload-checksum"
+	addTestVersion(t, appID, "2.0.0", testArtifactData(1024))
+	data := testArtifactData(4096)
+	uploadID := createTestUpload(t, cookie, appID, "2.1.0", data)
+
+	w := putChunk(cookie, appID, uploadID, 0, bytes.NewReader(data[:2048]), chunkSum(data[:2047]))
+	if w.Code != statusChecksumMismatch {
+		t.Fatalf("校验失败时返回 %d，期望%d", w.Code, statusChecksumMismatch)
+	}
+	if got := w.Header().Get("Upload-Offset"); got != "0" {
+		t.Errorf("Upload-Offset = %s，期望0", got)
+	}
+	if size := partSize(t, uploadID); size != 0 {
+		t.Errorf("校验失败后临时文件为 %d 字节，期望0", size)
+	}
+
+	req := httptest.NewRequest(http.MethodPut, uploadURL(appID, uploadID), bytes.NewReader(data))
+	req.Header.Set("Upload-Offset", "0")
+	req.AddCookie(cookie)
+	w = httptest.NewRecorder()
+	testServer.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("缺少Upload-Checksum时返回 %d，期望400", w.Code)
+	}
+}
+
+// 全部分片上传完成后提交发布返回202，轮询会话直到发布完成
+func TestUploadFinalize(t *testing.T) {
+	cookie := testLogin(t, "upload-finalize")
+	appID := "upload-finalize"
+	addTestVersion(t, appID, "2.0.0", testArtifactData(1024))
+	data := testZipData(t, map[string]string{"Content/Paks/patch.pak": string(testArtifactData(64 << 10))})
+	uploadID := createTestUpload(t, cookie, appID, "2.1.0", data)
+
+	if w := postForm(uploadURL(appID, uploadID)+"/finalize", nil, cookie); w.Code != http.StatusConflict {
+		t.Errorf("未上传完成时提交发布返回 %d，期望409", w.Code)
+	}
+
+	half := int64(len(data) / 2)
+	for _, chunk := range [][2]int64{{0, half}, {half, int64(len(data))}} {
+		part := data[chunk[0]:chunk[1]]
+		if w := putChunk(cookie, appID, uploadID, chunk[0], bytes.NewReader(part), chunkSum(part)); w.Code != http.StatusOK {
+			t.Fatalf("上传分片返回 %d: %s", w.Code, w.Body.String())
+		}
+	}
+
+	w := postForm(uploadURL(appID, uploadID)+"/finalize", nil, cookie)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("提交发布返回 %d: %s", w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("Location"); got != uploadURL(appID, uploadID) {
+		t.Errorf("Location = %s", got)
+	}
+
+	var session models.UploadSession
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		var code int
+		code, session = getTestUpload(t, cookie, appID, uploadID)
+		if code != http.StatusOK {
+			t.Fatalf("查询上传会话返回 %d", code)
+		}
+		if session.Status != models.UploadFinalizing {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("发布超时")
+		}
+	}
+	if session.Status != models.UploadCompleted {
+		t.Fatalf("发布状态为 %s: %s", session.Status, session.Error)
+	}
+
+	versionList, err := models.LoadVersions(MetadataStore, appID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	version, exists := models.GetVersion(versionList, "2.1.0")
+	if !exists {
+		t.Fatal("发布完成后版本列表中没有该版本")
+	}
+	if version.FileSize != int64(len(data)) {
+		t.Errorf("版本大小 %d，期望 %d", version.FileSize, len(data))
+	}
+	if channel, _ := models.GetChannel(versionList, models.DefaultChannel); !channel.Contains("2.1.0") {
+		t.Error("未指定渠道的上传应发布到默认渠道")
+	}
+	if _, err := os.Stat(uploadPartPath(uploadID)); !os.IsNotExist(err) {
+		t.Error("发布完成后应删除上传临时文件")
+	}
+}
+
+// 清理协程删除过期的会话和临时文件，保留未过期的会话
+func TestUploadJanitorExpiresSessions(t *testing.T) {
+	cookie := testLogin(t, "upload-janitor")
+	appID := "upload-janitor"
+	addTestVersion(t, appID, "2.0.0", testArtifactData(1024))
+	data := testArtifactData(4096)
+	expired := createTestUpload(t, cookie, appID, "2.1.0", data)
+	active := createTestUpload(t, cookie, appID, "2.1.0", data)
+
+	err := models.UpdateUploadSessions(MetadataStore, func(sessionList *models.UploadSessionList) error {
+		session, _ := models.GetUploadSession(sessionList, expired)
+		session.ExpiresAt = time.Now().Add(-time.Minute)
+		models.SetUploadSession(sessionList, session)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 没有对应会话且已超过清理间隔的临时文件
+	orphan := uploadPartPath("orphan")
+	if err := os.WriteFile(orphan, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * uploadCleanupInterval)
+	if err := os.Chtimes(orphan, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	cleanupUploads()
+
+	if code, _ := getTestUpload(t, cookie, appID, expired); code != http.StatusNotFound {
+		t.Errorf("过期会话返回 %d，期望404", code)
+	}
+	sessionList, err := models.LoadUploadSessions(MetadataStore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, exists := models.GetUploadSession(sessionList, expired); exists {
+		t.Error("过期会话未被删除")
+	}
+	for _, path := range []string{uploadPartPath(expired), orphan} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("临时文件 %s 未被删除", path)
+		}
+	}
+
+	if code, _ := getTestUpload(t, cookie, appID, active); code != http.StatusOK {
+		t.Errorf("未过期的会话返回 %d", code)
+	}
+	if _, err := os.Stat(uploadPartPath(active)); err != nil {
+		t.Errorf("未过期会话的临时文件被删除: %v", err)
+	}
+}
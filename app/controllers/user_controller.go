@@ -0,0 +1,326 @@
+package controllers
+
+import (
+	"log/slog"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+
+	"hotupdate/app/models"
+)
+
+// 用户名格式：字母开头，可包含字母、数字、点、横线和下划线
+var usernamePattern = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9._-]{1,63}$`)
+
+// 注册用户管理路由，只有全局所有者可以管理用户
+func setupUserRoutes(r *gin.Engine) {
+	ownerAuth := RoleRequired(models.RoleOwner)
+
+	r.GET("/api/users", ownerAuth, ListUsers)
+	r.POST("/api/users", ownerAuth, CreateUser)
+	r.PATCH("/api/users/:username", ownerAuth, UpdateUser)
+	r.DELETE("/api/users/:username", ownerAuth, DeleteUser)
+	r.PUT("/api/users/:username/roles/:scope", ownerAuth, GrantRole)
+	r.DELETE("/api/users/:username/roles/:scope", ownerAuth, RevokeRole)
+}
+
+// ListUsers 列出所有用户
+func ListUsers(c *gin.Context) {
+	userList, err := models.LoadUsers(MetadataStore)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "无法加载用户列表"})
+		return
+	}
+
+	users := make([]models.User, 0, len(userList.Users))
+	for _, user := range userList.Users {
+		users = append(users, user.Public())
+	}
+
+	c.JSON(http.StatusOK, gin.H{"users": users})
+}
+
+// CreateUser 创建用户
+func CreateUser(c *gin.Context) {
+	username := c.PostForm("username")
+	password := c.PostForm("password")
+
+	if !usernamePattern.MatchString(username) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "用户名格式无效，需以字母开头，只能包含字母、数字、点、横线和下划线"})
+		return
+	}
+
+	if len(password) < 8 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "密码长度不能少于8位"})
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "无法保存密码"})
+		return
+	}
+
+	now := time.Now()
+	user := models.User{
+		Username:     username,
+		PasswordHash: string(hash),
+		Roles:        map[string]models.Role{},
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+
+	// 创建时可以同时授予一个角色
+	if role := models.Role(c.PostForm("role")); role != "" {
+		if !role.Valid() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的角色，可选值：owner、release-manager、uploader、viewer"})
+			return
+		}
+		user.Roles[c.DefaultPostForm("scope", models.AllApps)] = role
+	}
+
+	err = models.UpdateUsers(MetadataStore, func(userList *models.UserList) error {
+		if _, exists := models.GetUser(userList, username); exists {
+			return newRequestError(http.StatusBadRequest, "用户名已存在")
+		}
+		models.AddUser(userList, user)
+		return nil
+	})
+	if err != nil {
+		respondError(c, err, "保存用户列表失败")
+		return
+	}
+
+	slog.InfoContext(c.Request.Context(), "已创建用户", "user", username, "operator", c.GetString("username"))
+	c.JSON(http.StatusOK, gin.H{"message": "用户创建成功", "user": user.Public()})
+}
+
+// UpdateUser 修改用户密码或启用状态
+func UpdateUser(c *gin.Context) {
+	username := c.Param("username")
+
+	var passwordHash string
+	if password, ok := c.GetPostForm("password"); ok {
+		if len(password) < 8 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "密码长度不能少于8位"})
+			return
+		}
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "无法保存密码"})
+			return
+		}
+		passwordHash = string(hash)
+	}
+
+	disabled, setDisabled := c.GetPostForm("disabled")
+	if setDisabled && disabled == "true" && username == c.GetString("username") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不能禁用当前登录的用户"})
+		return
+	}
+
+	var user models.User
+	err := models.UpdateUsers(MetadataStore, func(userList *models.UserList) error {
+		var exists bool
+		user, exists = models.GetUser(userList, username)
+		if !exists {
+			return newRequestError(http.StatusNotFound, "用户不存在")
+		}
+
+		if passwordHash != "" {
+			user.PasswordHash = passwordHash
+		}
+		if setDisabled {
+			user.Disabled = disabled == "true"
+		}
+		user.UpdatedAt = time.Now()
+		models.AddUser(userList, user)
+
+		if models.CountGlobalOwners(userList) == 0 {
+			return newRequestError(http.StatusBadRequest, "至少需要保留一个可用的全局所有者")
+		}
+		return nil
+	})
+	if err != nil {
+		respondError(c, err, "保存用户列表失败")
+		return
+	}
+
+	// 修改密码或禁用后，让该用户的已有会话失效
+	if username != c.GetString("username") {
+		revokeUserSessions(username, "")
+	}
+
+	slog.InfoContext(c.Request.Context(), "已更新用户", "user", username, "operator", c.GetString("username"))
+	c.JSON(http.StatusOK, gin.H{"message": "用户更新成功", "user": user.Public()})
+}
+
+// ChangePassword 当前登录用户修改自己的密码，需提供当前密码。修改后该用户的其他会话失效
+func ChangePassword(c *gin.Context) {
+	username := c.GetString("username")
+	currentPassword := c.PostForm("current_password")
+	newPassword := c.PostForm("new_password")
+
+	if len(newPassword) < 8 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "密码长度不能少于8位"})
+		return
+	}
+	if newPassword == currentPassword {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "新密码不能与当前密码相同"})
+		return
+	}
+	if _, ok := checkCredentials(c.Request.Context(), username, currentPassword); !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "当前密码错误"})
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "无法保存密码"})
+		return
+	}
+
+	err = models.UpdateUsers(MetadataStore, func(userList *models.UserList) error {
+		user, exists := models.GetUser(userList, username)
+		if !exists {
+			return newRequestError(http.StatusNotFound, "用户不存在")
+		}
+		user.PasswordHash = string(hash)
+		user.MustChangePassword = false
+		user.UpdatedAt = time.Now()
+		models.AddUser(userList, user)
+		return nil
+	})
+	if err != nil {
+		respondError(c, err, "保存用户列表失败")
+		return
+	}
+
+	token, _ := c.Cookie(SessionCookieName)
+	revokeUserSessions(username, token)
+
+	slog.InfoContext(c.Request.Context(), "用户已修改密码", "user", username)
+	c.JSON(http.StatusOK, gin.H{"message": "密码修改成功"})
+}
+
+// DeleteUser 删除用户
+func DeleteUser(c *gin.Context) {
+	username := c.Param("username")
+
+	if username == c.GetString("username") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不能删除当前登录的用户"})
+		return
+	}
+
+	err := models.UpdateUsers(MetadataStore, func(userList *models.UserList) error {
+		if _, exists := models.GetUser(userList, username); !exists {
+			return newRequestError(http.StatusNotFound, "用户不存在")
+		}
+
+		models.DeleteUser(userList, username)
+
+		if models.CountGlobalOwners(userList) == 0 {
+			return newRequestError(http.StatusBadRequest, "至少需要保留一个可用的全局所有者")
+		}
+		return nil
+	})
+	if err != nil {
+		respondError(c, err, "保存用户列表失败")
+		return
+	}
+
+	revokeUserSessions(username, "")
+
+	slog.InfoContext(c.Request.Context(), "已删除用户", "user", username, "operator", c.GetString("username"))
+	c.JSON(http.StatusOK, gin.H{"message": "用户删除成功"})
+}
+
+// GrantRole 授予用户在指定应用（或"*"表示全部应用）上的角色
+func GrantRole(c *gin.Context) {
+	username := c.Param("username")
+	scope := c.Param("scope")
+	role := models.Role(c.PostForm("role"))
+
+	if !role.Valid() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的角色，可选值：owner、release-manager、uploader、viewer"})
+		return
+	}
+
+	if scope != models.AllApps {
+		appList, err := models.LoadApps(MetadataStore)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "无法加载应用列表"})
+			return
+		}
+		if _, exists := models.GetApp(appList, scope); !exists {
+			c.JSON(http.StatusNotFound, gin.H{"error": "应用不存在"})
+			return
+		}
+	}
+
+	var user models.User
+	err := models.UpdateUsers(MetadataStore, func(userList *models.UserList) error {
+		var exists bool
+		user, exists = models.GetUser(userList, username)
+		if !exists {
+			return newRequestError(http.StatusNotFound, "用户不存在")
+		}
+
+		if user.Roles == nil {
+			user.Roles = map[string]models.Role{}
+		}
+		user.Roles[scope] = role
+		user.UpdatedAt = time.Now()
+		models.AddUser(userList, user)
+
+		if models.CountGlobalOwners(userList) == 0 {
+			return newRequestError(http.StatusBadRequest, "至少需要保留一个可用的全局所有者")
+		}
+		return nil
+	})
+	if err != nil {
+		respondError(c, err, "保存用户列表失败")
+		return
+	}
+
+	slog.InfoContext(c.Request.Context(), "已授予角色", "user", username, "scope", scope, "role", role, "operator", c.GetString("username"))
+	c.JSON(http.StatusOK, gin.H{"message": "角色授予成功", "user": user.Public()})
+}
+
+// RevokeRole 撤销用户在指定应用上的角色
+func RevokeRole(c *gin.Context) {
+	username := c.Param("username")
+	scope := c.Param("scope")
+
+	var user models.User
+	err := models.UpdateUsers(MetadataStore, func(userList *models.UserList) error {
+		var exists bool
+		user, exists = models.GetUser(userList, username)
+		if !exists {
+			return newRequestError(http.StatusNotFound, "用户不存在")
+		}
+
+		if _, ok := user.Roles[scope]; !ok {
+			return newRequestError(http.StatusNotFound, "用户在该范围上没有角色")
+		}
+
+		delete(user.Roles, scope)
+		user.UpdatedAt = time.Now()
+		models.AddUser(userList, user)
+
+		if models.CountGlobalOwners(userList) == 0 {
+			return newRequestError(http.StatusBadRequest, "至少需要保留一个可用的全局所有者")
+		}
+		return nil
+	})
+	if err != nil {
+		respondError(c, err, "保存用户列表失败")
+		return
+	}
+
+	slog.InfoContext(c.Request.Context(), "已撤销角色", "user", username, "scope", scope, "operator", c.GetString("username"))
+	c.JSON(http.StatusOK, gin.H{"message": "角色撤销成功", "user": user.Public()})
+}
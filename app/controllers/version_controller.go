@@ -1,120 +1,196 @@
 package controllers
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	"mime"
+	"mime/multipart"
 	"net/http"
-	"os"
+	"net/url"
 	"path/filepath"
-	"strconv"
+	"slices"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gin-gonic/gin"
 
+	"hotupdate/app/metrics"
 	"hotupdate/app/models"
+	"hotupdate/app/storage"
+	"hotupdate/app/store"
+	"hotupdate/app/utils"
+	"hotupdate/app/zipdelta"
 )
 
 var (
-	UploadDir    string
-	AppsJsonPath string
-	isReady      = false // 服务就绪标志
+	UploadDir     string
+	MetadataStore store.Store     // 元数据存储（应用、版本、令牌、用户）
+	FileStorage   storage.Storage // 版本文件存储
+	isReady       atomic.Bool     // 服务就绪标志，后台初始化完成后设置
+
+	// 在更新闭包中表示无需保存的哨兵错误
+	errNoChange = errors.New("无需修改")
 )
 
 // SetupVersionController 设置版本控制器
-func SetupVersionController(r *gin.Engine, uploadDirectory string) {
+func SetupVersionController(r *gin.Engine, uploadDirectory string, metadataStore store.Store, fileStorage storage.Storage) {
 	UploadDir = uploadDirectory
-	AppsJsonPath = filepath.Join(UploadDir, "apps.json")
+	MetadataStore = metadataStore
+	FileStorage = fileStorage
 
 	// 健康检查API
 	r.GET("/health", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{
 			"status":  "ok",
-			"ready":   isReady,
+			"ready":   isReady.Load(),
 			"version": "1.0.0",
 			"time":    time.Now().Format(time.RFC3339),
 		})
 	})
 
+	// 校验路径参数，已归档的应用只能查看、恢复或彻底删除
+	r.Use(validatePathParams(), archivedAppGuard())
+
+	// 管理接口按角色鉴权，应用级接口也接受该应用的API令牌
+	viewerAuth := RoleRequired(models.RoleViewer)
+	uploaderAuth := RoleRequired(models.RoleUploader)
+	ownerAuth := RoleRequired(models.RoleOwner)
+
 	// 应用管理API
-	r.POST("/api/apps", CreateApp)
-	r.GET("/api/apps", ListApps)
-	r.GET("/api/apps/:app_id", GetAppInfo)
-	r.DELETE("/api/apps/:app_id", DeleteApp)
+	r.POST("/api/apps", ownerAuth, CreateApp)
+	r.GET("/api/apps", AuthRequired(), ListApps)
+	r.GET("/api/apps/:app_id", viewerAuth, GetAppInfo)
+	r.PATCH("/api/apps/:app_id", ownerAuth, UpdateApp)
+
+	// 应用归档、恢复和彻底删除API
+	setupAppLifecycleRoutes(r)
 
 	// 版本管理API
-	r.POST("/api/apps/:app_id/versions", CreateVersion)
-	r.GET("/api/apps/:app_id/versions", ListVersions)
+	r.POST("/api/apps/:app_id/versions", uploaderAuth, CreateVersion)
+	r.GET("/api/apps/:app_id/versions", viewerAuth, ListVersions)
+
+	// 分片上传API
+	setupUploadRoutes(r)
+
+	// 发布渠道API
+	setupChannelRoutes(r)
+
+	// 灰度发布API
+	setupRolloutRoutes(r)
+
+	// 撤回与回滚API
+	setupYankRoutes(r)
+
+	// 单个版本的查看、修改和删除API
+	setupVersionDetailRoutes(r)
+
+	// 版本采用情况统计API
+	setupAnalyticsRoutes(r)
+
+	// 客户端更新结果上报API
+	setupReportRoutes(r)
+
+	// 令牌管理API
+	setupTokenRoutes(r)
+
+	// 差分包API
+	setupDeltaRoutes(r)
+
+	// 文件清单API
+	setupManifestRoutes(r)
 
 	// 客户端API
 	r.GET("/api/apps/:app_id/check", CheckUpdate)
 	r.GET("/api/apps/:app_id/download/:version/:filename", DownloadFile)
+	r.HEAD("/api/apps/:app_id/download/:version/:filename", DownloadFile)
 
 	// 为了保持向后兼容，保留原有API（不带app_id的路径），但内部会使用"default"应用
-	r.POST("/api/versions", func(c *gin.Context) {
-		c.Params = append(c.Params, gin.Param{Key: "app_id", Value: "default"})
-		CreateVersion(c)
-	})
-	r.GET("/api/versions", func(c *gin.Context) {
+	defaultApp := func(c *gin.Context) {
 		c.Params = append(c.Params, gin.Param{Key: "app_id", Value: "default"})
-		ListVersions(c)
-	})
-	r.GET("/api/check", func(c *gin.Context) {
-		c.Params = append(c.Params, gin.Param{Key: "app_id", Value: "default"})
-		CheckUpdate(c)
-	})
-	r.GET("/api/download/:version/:filename", func(c *gin.Context) {
-		c.Params = append(c.Params, gin.Param{Key: "app_id", Value: "default"})
-		DownloadFile(c)
-	})
+	}
+	r.POST("/api/versions", defaultApp, uploaderAuth, CreateVersion)
+	r.GET("/api/versions", defaultApp, viewerAuth, ListVersions)
+	r.GET("/api/check", defaultApp, CheckUpdate)
+	r.GET("/api/download/:version/:filename", defaultApp, DownloadFile)
+	r.HEAD("/api/download/:version/:filename", defaultApp, DownloadFile)
+
+	// 各应用占用的存储空间，抓取指标时计算
+	metrics.NewGaugeFunc("hotupdate_storage_bytes", "按应用统计的更新包和差分包占用的存储空间（字节）", []string{"app"}, storageUsage)
 
 	// 初始化应用列表，确保至少有一个默认应用
 	go func() {
 		initApps()
-		isReady = true
-		log.Println("热更新服务器初始化完成，所有API已就绪")
+		resumeDeltaJobs()
+		isReady.Store(true)
+		slog.Info("热更新服务器初始化完成，所有API已就绪")
 	}()
 }
 
 // 初始化应用列表，确保至少有一个默认应用
 func initApps() {
-	// 确保apps.json存在
-	appList, err := models.LoadApps(AppsJsonPath)
-	if err != nil {
-		log.Printf("加载应用列表失败: %v", err)
-		appList = &models.AppList{Apps: []models.App{}}
-	}
+	// 检查是否有默认应用，没有则创建
+	err := models.UpdateApps(MetadataStore, func(appList *models.AppList) error {
+		if _, hasDefaultApp := models.GetApp(appList, "default"); hasDefaultApp {
+			return errNoChange
+		}
 
-	// 检查是否有默认应用
-	_, hasDefaultApp := models.GetApp(appList, "default")
-	if !hasDefaultApp {
-		// 创建默认应用
-		defaultApp := models.App{
+		models.AddApp(appList, models.App{
 			ID:          "default",
 			Name:        "默认应用",
 			Description: "系统默认应用",
 			CreatedAt:   time.Now(),
 			UpdatedAt:   time.Now(),
-		}
-		appList = models.AddApp(appList, defaultApp)
-
-		// 保存应用列表
-		if err := models.SaveApps(appList, AppsJsonPath); err != nil {
-			log.Printf("保存应用列表失败: %v", err)
-		}
-
-		// 创建应用目录
-		if err := models.CreateAppDirectories(UploadDir, "default"); err != nil {
-			log.Printf("创建默认应用目录失败: %v", err)
-		}
+		})
+		return nil
+	})
 
+	switch {
+	case err == nil:
 		// 创建初始版本
-		createInitialVersionForApp("default")
+		createInitialVersionForApp(context.Background(), "default")
+	case !errors.Is(err, errNoChange):
+		slog.Error("保存应用列表失败", "error", err)
 	}
 
 	// 初始化完成后标记服务就绪
-	log.Println("应用初始化完成")
+	slog.Info("应用初始化完成")
+}
+
+// 根据版本列表统计各应用占用的存储空间：默认更新包、专用更新包和差分包
+func storageUsage() []metrics.Sample {
+	appList, err := models.LoadApps(MetadataStore)
+	if err != nil {
+		slog.Error("统计存储空间时加载应用列表失败", "error", err)
+		return nil
+	}
+
+	samples := make([]metrics.Sample, 0, len(appList.Apps))
+	for _, app := range appList.Apps {
+		versionList, err := models.LoadVersions(MetadataStore, app.ID)
+		if err != nil {
+			slog.Error("统计存储空间时加载版本列表失败", "app", app.ID, "error", err)
+			continue
+		}
+		var size int64
+		for _, version := range versionList.Versions {
+			size += version.FileSize
+			for _, artifact := range version.Artifacts {
+				size += artifact.FileSize
+			}
+			if version.Delta != nil {
+				size += version.Delta.FileSize
+			}
+		}
+		samples = append(samples, metrics.Sample{LabelValues: []string{app.ID}, Value: float64(size)})
+	}
+	return samples
 }
 
 // CreateApp 创建新应用
@@ -132,97 +208,120 @@ func CreateApp(c *gin.Context) {
 	description := c.PostForm("description")
 
 	// 验证应用ID
-	if appID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "应用ID不能为空"})
+	if err := models.ValidateAppID(appID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// 不允许使用保留的ID
-	if appID == "apps" || appID == "api" || appID == "admin" || appID == "static" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "应用ID不能使用保留字"})
+	// 检查更新模式
+	updateMode := c.DefaultPostForm("update_mode", models.UpdateModeProgressive)
+	if !models.ValidUpdateMode(updateMode) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的更新模式，可选值：progressive、full"})
 		return
 	}
 
-	// 检查是否有初始版本文件上传
-	file, header, err := c.Request.FormFile("initial_file")
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "需要上传初始版本文件"})
+	// 版本号方案，初始版本号需要符合该方案
+	versionScheme := c.PostForm("version_scheme")
+	scheme, ok := models.GetVersionScheme(versionScheme)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的版本号方案，可选值：semver、changelist、date"})
 		return
 	}
-	defer file.Close()
-
-	// 检查文件类型
-	if !strings.HasSuffix(header.Filename, ".zip") {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "只接受ZIP文件"})
+	versionId := c.PostForm("initial_version")
+	if versionId == "" {
+		versionId = "1.0.0"
+	}
+	if err := models.ValidateVersionID(versionId); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := scheme.Validate(versionId); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "初始版本号无效: " + err.Error()})
 		return
 	}
 
-	// 设置创建时间和更新时间
-	now := time.Now()
-	app := models.App{
-		ID:          appID,
-		Name:        name,
-		Description: description,
-		CreatedAt:   now,
-		UpdatedAt:   now,
+	storeURL := strings.TrimSpace(c.PostForm("store_url"))
+	if storeURL != "" && !validStoreURL(storeURL) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "应用商店地址无效"})
+		return
 	}
 
-	// 加载应用列表
-	appList, err := models.LoadApps(AppsJsonPath)
+	packageRules, _, err := requestPackageRules(c, nil)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "无法加载应用列表"})
+		respondError(c, err, "更新包规则无效")
 		return
 	}
 
-	// 检查应用ID是否已存在
-	if _, exists := models.GetApp(appList, app.ID); exists {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "应用ID已存在"})
+	autoHalt, _, err := requestAutoHalt(c, nil)
+	if err != nil {
+		respondError(c, err, "自动终止规则无效")
 		return
 	}
 
-	// 添加新应用
-	appList = models.AddApp(appList, app)
-
-	// 保存应用列表
-	if err := models.SaveApps(appList, AppsJsonPath); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存应用列表失败"})
+	// 检查是否有初始版本文件上传
+	file, header, err := c.Request.FormFile("initial_file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "需要上传初始版本文件"})
 		return
 	}
+	defer file.Close()
 
-	// 创建应用目录
-	if err := models.CreateAppDirectories(UploadDir, app.ID); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建应用目录失败"})
+	// 检查文件类型
+	if !strings.HasSuffix(header.Filename, ".zip") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "只接受ZIP文件"})
 		return
 	}
 
-	// 创建初始版本目录
-	versionId := "1.0.0"
-	appDir := models.GetAppUploadDir(UploadDir, app.ID)
-	versionDir := filepath.Join(appDir, "versions", versionId)
-	if err := os.MkdirAll(versionDir, 0755); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "无法创建版本目录"})
+	// 设置创建时间和更新时间
+	now := time.Now()
+	app := models.App{
+		ID:            appID,
+		Name:          name,
+		Description:   description,
+		UpdateMode:    updateMode,
+		VersionScheme: scheme.Name(),
+		StoreURL:      storeURL,
+		PackageRules:  packageRules,
+		AutoHalt:      autoHalt,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+
+	// 校验初始版本的更新包，读取ZIP内容生成文件清单时同时校验文件内容
+	if _, err := models.ValidatePackage(file, header.Size, packageRules); err != nil {
+		respondError(c, err, "更新包校验失败")
 		return
 	}
-
-	// 保存上传的文件
-	zipPath := filepath.Join(versionDir, "update.zip")
-	out, err := os.Create(zipPath)
+	archiveManifest, err := models.BuildFileManifest(file, header.Size, versionId)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "无法创建初始版本文件"})
+		respondError(c, err, "更新包校验失败")
 		return
 	}
-	defer out.Close()
 
-	_, err = io.Copy(out, file)
+	// 检查应用ID是否已存在并添加新应用，两步在同一次更新中完成
+	err = models.UpdateApps(MetadataStore, func(appList *models.AppList) error {
+		if existing, exists := models.GetApp(appList, app.ID); exists {
+			if existing.Archived() {
+				return newRequestError(http.StatusConflict, "应用ID已归档，彻底删除后才能重新创建")
+			}
+			return newRequestError(http.StatusBadRequest, "应用ID已存在")
+		}
+		models.AddApp(appList, app)
+		return nil
+	})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "无法保存初始版本文件"})
+		respondError(c, err, "保存应用列表失败")
 		return
 	}
 
-	// 获取文件信息
-	fileInfo, err := os.Stat(zipPath)
+	// 保存上传的初始版本文件
+	relPath := filepath.Join("versions", versionId, "update.zip")
+	key := models.GetArtifactKey(app.ID, relPath)
+	hasher, err := putArtifact(c.Request.Context(), key, io.NewSectionReader(file, 0, header.Size), header.Size)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "无法获取文件信息"})
+		slog.ErrorContext(c.Request.Context(), "保存初始版本文件失败", "app", app.ID, "error", err)
+		removeApp(c.Request.Context(), app.ID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "无法保存初始版本文件"})
 		return
 	}
 
@@ -231,25 +330,36 @@ func CreateApp(c *gin.Context) {
 		ID:          versionId,
 		Name:        "初始版本",
 		Description: "系统初始版本",
-		FilePath:    filepath.Join("versions", versionId, "update.zip"),
-		FileSize:    fileInfo.Size(),
+		FilePath:    relPath,
+		FileSize:    hasher.Size(),
+		SHA256:      hasher.SHA256(),
+		MD5:         hasher.MD5(),
+		CRC32:       hasher.CRC32(),
 		CreatedAt:   now,
 		Force:       false,
 	}
 
-	versionList := &models.VersionList{
-		Versions:      []models.Version{initialVersion},
-		LatestVersion: versionId,
-	}
-
 	// 保存版本信息
-	versionJsonPath := models.GetAppVersionsJsonPath(UploadDir, app.ID)
-	if err := models.SaveVersions(versionList, versionJsonPath); err != nil {
+	err = models.UpdateVersions(MetadataStore, app.ID, func(versionList *models.VersionList) error {
+		versionList.Versions = []models.Version{initialVersion}
+		versionList.LatestVersion = versionId
+		models.AddToChannel(versionList, models.DefaultChannel, versionId)
+		return nil
+	})
+	if err != nil {
+		// 回滚已保存的文件和应用，否则留下一个没有版本的应用，且同一ID无法重新创建
+		slog.ErrorContext(c.Request.Context(), "保存初始版本信息失败", "app", app.ID, "error", err)
+		removeArtifacts(c.Request.Context(), []string{key})
+		removeApp(c.Request.Context(), app.ID)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存版本信息失败"})
 		return
 	}
 
-	log.Printf("已创建新应用: %s，并上传初始版本文件", app.ID)
+	if err := models.SaveFileManifest(MetadataStore, app.ID, archiveManifest); err != nil {
+		slog.ErrorContext(c.Request.Context(), "保存文件清单失败", "app", app.ID, "version", versionId, "error", err)
+	}
+
+	slog.InfoContext(c.Request.Context(), "已创建应用", "app", app.ID, "version", versionId, "operator", c.GetString("username"))
 	c.JSON(http.StatusOK, gin.H{
 		"message":        "应用创建成功",
 		"app":            app,
@@ -257,15 +367,25 @@ func CreateApp(c *gin.Context) {
 	})
 }
 
-// ListApps 列出所有应用
+// ListApps 列出当前用户有权访问的应用，archived=true时只列出已归档的应用
 func ListApps(c *gin.Context) {
-	appList, err := models.LoadApps(AppsJsonPath)
+	archived := c.Query("archived") == "true"
+
+	appList, err := models.LoadApps(MetadataStore)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "无法加载应用列表"})
 		return
 	}
 
-	c.JSON(http.StatusOK, appList)
+	user := c.MustGet("user").(models.User)
+	visible := &models.AppList{Apps: []models.App{}}
+	for _, app := range appList.Apps {
+		if user.HasAnyRole(app.ID) && app.Archived() == archived {
+			visible.Apps = append(visible.Apps, app)
+		}
+	}
+
+	c.JSON(http.StatusOK, visible)
 }
 
 // GetAppInfo 获取应用信息
@@ -273,7 +393,7 @@ func GetAppInfo(c *gin.Context) {
 	appID := c.Param("app_id")
 
 	// 加载应用列表
-	appList, err := models.LoadApps(AppsJsonPath)
+	appList, err := models.LoadApps(MetadataStore)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "无法加载应用列表"})
 		return
@@ -287,8 +407,7 @@ func GetAppInfo(c *gin.Context) {
 	}
 
 	// 加载版本信息
-	versionJsonPath := models.GetAppVersionsJsonPath(UploadDir, appID)
-	versionList, err := models.LoadVersions(versionJsonPath)
+	versionList, err := models.LoadVersions(MetadataStore, appID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "无法加载版本列表"})
 		return
@@ -301,80 +420,128 @@ func GetAppInfo(c *gin.Context) {
 	})
 }
 
-// DeleteApp 删除应用
-func DeleteApp(c *gin.Context) {
+// UpdateApp 修改应用名称、描述、检查更新模式、版本号方案、更新包规则和灰度自动终止规则，只修改请求中提供的字段
+func UpdateApp(c *gin.Context) {
 	appID := c.Param("app_id")
 
-	// 不允许删除默认应用
-	if appID == "default" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "不能删除默认应用"})
+	name, setName := c.GetPostForm("name")
+	description, setDescription := c.GetPostForm("description")
+	updateMode, setUpdateMode := c.GetPostForm("update_mode")
+	versionScheme, setVersionScheme := c.GetPostForm("version_scheme")
+	storeURL, setStoreURL := c.GetPostForm("store_url")
+
+	if setUpdateMode && !models.ValidUpdateMode(updateMode) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的更新模式，可选值：progressive、full"})
 		return
 	}
 
-	// 加载应用列表
-	appList, err := models.LoadApps(AppsJsonPath)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "无法加载应用列表"})
+	storeURL = strings.TrimSpace(storeURL)
+	if setStoreURL && storeURL != "" && !validStoreURL(storeURL) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "应用商店地址无效"})
 		return
 	}
 
-	// 检查应用是否存在
-	if _, exists := models.GetApp(appList, appID); !exists {
-		c.JSON(http.StatusNotFound, gin.H{"error": "应用不存在"})
-		return
+	// 更换版本号方案时，已有版本必须符合新方案且保持递增
+	if setVersionScheme {
+		scheme, ok := models.GetVersionScheme(versionScheme)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的版本号方案，可选值：semver、changelist、date"})
+			return
+		}
+		versionList, err := models.LoadVersions(MetadataStore, appID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "无法加载版本列表"})
+			return
+		}
+		for i, version := range versionList.Versions {
+			if err := scheme.Validate(version.ID); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("版本 %s 不符合该方案: %v", version.ID, err)})
+				return
+			}
+			if i > 0 && scheme.Compare(versionList.Versions[i-1].ID, version.ID) >= 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("按该方案版本 %s 不大于上一个版本 %s", version.ID, versionList.Versions[i-1].ID)})
+				return
+			}
+		}
 	}
 
-	// 删除应用
-	appList = models.DeleteApp(appList, appID)
+	var app models.App
+	err := models.UpdateApps(MetadataStore, func(appList *models.AppList) error {
+		var exists bool
+		app, exists = models.GetApp(appList, appID)
+		if !exists {
+			return newRequestError(http.StatusNotFound, "应用不存在")
+		}
 
-	// 保存应用列表
-	if err := models.SaveApps(appList, AppsJsonPath); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存应用列表失败"})
+		if setName {
+			app.Name = name
+		}
+		if setDescription {
+			app.Description = description
+		}
+		if setUpdateMode {
+			app.UpdateMode = updateMode
+		}
+		if setVersionScheme {
+			app.VersionScheme = versionScheme
+		}
+		if setStoreURL {
+			app.StoreURL = storeURL
+		}
+		rules, setRules, err := requestPackageRules(c, app.PackageRules)
+		if err != nil {
+			return err
+		}
+		if setRules {
+			app.PackageRules = rules
+		}
+		autoHalt, setAutoHalt, err := requestAutoHalt(c, app.AutoHalt)
+		if err != nil {
+			return err
+		}
+		if setAutoHalt {
+			app.AutoHalt = autoHalt
+		}
+		app.UpdatedAt = time.Now()
+		models.AddApp(appList, app)
+		return nil
+	})
+	if err != nil {
+		respondError(c, err, "保存应用列表失败")
 		return
 	}
 
-	// 删除应用目录（可选，取决于是否要保留历史数据）
-	// 这里我们不实际删除文件，只是返回成功
-
-	log.Printf("已删除应用: %s", appID)
-	c.JSON(http.StatusOK, gin.H{"message": "应用删除成功"})
+	slog.InfoContext(c.Request.Context(), "已更新应用", "app", appID, "operator", c.GetString("username"))
+	c.JSON(http.StatusOK, gin.H{"message": "应用更新成功", "app": app})
 }
 
 // 为应用创建初始版本
-func createInitialVersionForApp(appID string) {
-	log.Printf("为应用 %s 创建默认初始版本", appID)
-
-	// 应用目录
-	appDir := models.GetAppUploadDir(UploadDir, appID)
-	versionsDir := filepath.Join(appDir, "versions")
+func createInitialVersionForApp(ctx context.Context, appID string) {
+	slog.InfoContext(ctx, "创建默认初始版本", "app", appID)
 
-	// 创建版本目录
 	versionId := "1.0.0"
-	versionDir := filepath.Join(versionsDir, versionId)
-	if err := os.MkdirAll(versionDir, 0755); err != nil {
-		log.Printf("创建初始版本目录失败: %v", err)
-		return
-	}
+	relPath := filepath.Join("versions", versionId, "update.zip")
+	key := models.GetArtifactKey(appID, relPath)
 
 	// 检查是否已存在版本文件
-	zipPath := filepath.Join(versionDir, "update.zip")
-	if _, err := os.Stat(zipPath); !os.IsNotExist(err) {
-		log.Printf("版本文件已存在，跳过创建空文件")
-	} else {
+	if _, err := FileStorage.Stat(ctx, key); err == nil {
+		slog.InfoContext(ctx, "版本文件已存在，跳过创建空文件", "app", appID)
+	} else if errors.Is(err, storage.ErrNotFound) {
 		// 创建空的更新文件
-		emptyFile, err := os.Create(zipPath)
-		if err != nil {
-			log.Printf("创建初始版本文件失败: %v", err)
+		if err := FileStorage.Put(ctx, key, bytes.NewReader(nil), 0); err != nil {
+			slog.ErrorContext(ctx, "创建初始版本文件失败", "app", appID, "error", err)
 			return
 		}
-		emptyFile.Close()
-		log.Printf("已创建空的初始版本文件")
+		slog.InfoContext(ctx, "已创建空的初始版本文件", "app", appID)
+	} else {
+		slog.ErrorContext(ctx, "获取文件信息失败", "app", appID, "error", err)
+		return
 	}
 
-	// 获取文件信息
-	fileInfo, err := os.Stat(zipPath)
+	// 读取文件计算大小和哈希
+	hasher, err := hashStoredFile(ctx, key)
 	if err != nil {
-		log.Printf("获取文件信息失败: %v", err)
+		slog.ErrorContext(ctx, "计算文件哈希失败", "app", appID, "error", err)
 		return
 	}
 
@@ -383,39 +550,44 @@ func createInitialVersionForApp(appID string) {
 		ID:          versionId,
 		Name:        "初始版本",
 		Description: "系统初始版本",
-		FilePath:    filepath.Join("versions", versionId, "update.zip"),
-		FileSize:    fileInfo.Size(),
+		FilePath:    relPath,
+		FileSize:    hasher.Size(),
+		SHA256:      hasher.SHA256(),
+		MD5:         hasher.MD5(),
+		CRC32:       hasher.CRC32(),
 		CreatedAt:   time.Now(),
 		Force:       false,
 	}
 
-	versionList := &models.VersionList{
-		Versions:      []models.Version{initialVersion},
-		LatestVersion: versionId,
-	}
-
 	// 保存版本信息
-	versionJsonPath := models.GetAppVersionsJsonPath(UploadDir, appID)
-	if err := models.SaveVersions(versionList, versionJsonPath); err != nil {
-		log.Printf("保存版本信息失败: %v", err)
+	err = models.UpdateVersions(MetadataStore, appID, func(versionList *models.VersionList) error {
+		versionList.Versions = []models.Version{initialVersion}
+		versionList.LatestVersion = versionId
+		models.AddToChannel(versionList, models.DefaultChannel, versionId)
+		return nil
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "保存版本信息失败", "app", appID, "error", err)
 		return
 	}
 
-	log.Printf("应用 %s 初始版本创建成功", appID)
+	slog.InfoContext(ctx, "初始版本创建成功", "app", appID)
 }
 
 // CreateVersion 创建新版本
 func CreateVersion(c *gin.Context) {
+	startTime := time.Now()
 	appID := c.Param("app_id")
 
 	// 验证应用是否存在
-	appList, err := models.LoadApps(AppsJsonPath)
+	appList, err := models.LoadApps(MetadataStore)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "无法加载应用列表"})
 		return
 	}
 
-	if _, exists := models.GetApp(appList, appID); !exists {
+	app, exists := models.GetApp(appList, appID)
+	if !exists {
 		c.JSON(http.StatusNotFound, gin.H{"error": "应用不存在"})
 		return
 	}
@@ -432,10 +604,34 @@ func CreateVersion(c *gin.Context) {
 	name := c.PostForm("name")
 	description := c.PostForm("description")
 	forceUpdate := c.PostForm("force") == "true"
+	cumulative := c.PostForm("cumulative") == "true"
 
-	// 验证版本ID
-	if versionID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "版本ID不能为空"})
+	// 新版本发布到指定渠道，未指定时发布到默认渠道
+	channel, ok := requestChannel(c, appID, c.PostForm("channel"))
+	if !ok {
+		return
+	}
+
+	// 验证版本ID，在保存文件之前拒绝格式错误、重复或不递增的版本号
+	if !checkNewVersion(c, app, channel, versionID) {
+		return
+	}
+
+	// 强制更新需要发布经理及以上角色
+	if forceUpdate && !callerRole(c).AtLeast(models.RoleReleaseManager) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "权限不足，设置强制更新需要角色: " + string(models.RoleReleaseManager)})
+		return
+	}
+
+	// 可选的灰度比例，未指定时全量发布
+	rollout, ok := requestRollout(c)
+	if !ok {
+		return
+	}
+
+	// 可选的兼容性要求
+	compatibility, ok := requestCompatibility(c, app)
+	if !ok {
 		return
 	}
 
@@ -453,66 +649,344 @@ func CreateVersion(c *gin.Context) {
 		return
 	}
 
-	// 应用的版本目录
-	appDir := models.GetAppUploadDir(UploadDir, appID)
-	versionDir := filepath.Join(appDir, "versions", versionID)
-	if err := os.MkdirAll(versionDir, 0755); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "无法创建版本目录"})
+	// 平台或纹理格式专用的更新包，没有专用更新包的平台使用file
+	artifacts, ok := requestArtifacts(c)
+	if !ok {
 		return
 	}
-
-	// 保存文件
-	filePath := filepath.Join(versionDir, "update.zip")
-	out, err := os.Create(filePath)
+	defer closeArtifactUploads(artifacts)
+
+	newVersion, err := publishVersion(c.Request.Context(), app, versionRequest{
+		ID:            versionID,
+		Name:          name,
+		Description:   description,
+		ReleaseNotes:  c.PostForm("release_notes"),
+		Force:         forceUpdate,
+		Cumulative:    cumulative,
+		Channel:       channel,
+		Rollout:       rollout,
+		Publisher:     c.GetString("username"),
+		SHA256:        c.PostForm("sha256"),
+		Compatibility: compatibility,
+		Artifacts:     artifacts,
+	}, file, header.Size)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "无法创建文件"})
+		respondError(c, err, "无法保存版本列表")
 		return
 	}
-	defer out.Close()
+	observeUpload(appID, metrics.UploadDirect, newVersion, startTime)
+
+	c.JSON(http.StatusOK, gin.H{"message": "版本创建成功", "version": newVersion})
+}
 
-	_, err = io.Copy(out, file)
+// 上传文件之前检查新版本号，失败时输出错误响应。发布时还会在版本列表锁内再次检查
+func checkNewVersion(c *gin.Context, app models.App, channel string, versionID string) bool {
+	versionList, err := models.LoadVersions(MetadataStore, app.ID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "无法保存文件"})
-		return
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "无法加载版本列表"})
+		return false
+	}
+
+	if err := validateNewVersion(app.Scheme(), versionList, channel, versionID); err != nil {
+		respondError(c, err, "无法校验版本号")
+		return false
+	}
+	return true
+}
+
+// 校验新版本号：符合应用的版本号方案、不与任何渠道中的已有版本重复，且大于目标渠道中的所有版本
+// （包括已撤回的版本）。其他渠道的版本不参与比较，beta渠道已有1.1.0-beta.1时仍可向stable渠道发布1.0.1
+func validateNewVersion(scheme models.VersionScheme, versionList *models.VersionList, channelName string, versionID string) error {
+	if err := models.ValidateVersionID(versionID); err != nil {
+		return newRequestError(http.StatusBadRequest, err.Error())
+	}
+	if err := scheme.Validate(versionID); err != nil {
+		return newRequestError(http.StatusBadRequest, "版本号格式无效: "+err.Error())
+	}
+
+	if _, exists := models.GetVersion(versionList, versionID); exists {
+		return newRequestError(http.StatusConflict, "版本已存在")
+	}
+	channel, _ := models.GetChannel(versionList, channelName)
+	for _, id := range channel.Versions {
+		if scheme.Compare(versionID, id) <= 0 {
+			return newRequestError(http.StatusConflict, fmt.Sprintf("版本号必须大于渠道 %s 中的已有版本 %s", channelName, id))
+		}
+	}
+	return nil
+}
+
+// 读取上传请求中的兼容性要求，都未指定时返回nil。格式无效时输出错误响应
+func requestCompatibility(c *gin.Context, app models.App) (*models.Compatibility, bool) {
+	compatibility := &models.Compatibility{
+		MinClientVersion: strings.TrimSpace(c.PostForm("min_client_version")),
+		BaseBuild:        strings.TrimSpace(c.PostForm("base_build")),
+	}
+
+	if compatibility.MinClientVersion != "" && !app.Scheme().Comparable(compatibility.MinClientVersion) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "最低客户端版本格式无效"})
+		return nil, false
+	}
+
+	// 平台以逗号分隔，如 windows,android
+	for _, platform := range strings.Split(c.PostForm("platforms"), ",") {
+		platform = strings.ToLower(strings.TrimSpace(platform))
+		if platform == "" {
+			continue
+		}
+		if !models.ValidPlatform(platform) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的平台名称: " + platform})
+			return nil, false
+		}
+		if !slices.Contains(compatibility.Platforms, platform) {
+			compatibility.Platforms = append(compatibility.Platforms, platform)
+		}
+	}
+
+	if compatibility.Empty() {
+		return nil, true
+	}
+	return compatibility, true
+}
+
+// versionRequest 发布新版本所需的信息
+type versionRequest struct {
+	ID            string
+	Name          string
+	Description   string
+	ReleaseNotes  string
+	Force         bool
+	Cumulative    bool
+	Channel       string                // 发布到的渠道
+	Rollout       int                   // 灰度比例，0或100表示全量发布
+	Compatibility *models.Compatibility // 兼容性要求，为空时不限制
+	Publisher     string                // 发布者
+	SHA256        string                // 上传方提供的SHA-256，为空时不校验
+	Artifacts     []artifactUpload      // 平台或纹理格式专用的更新包
+}
+
+// artifactUpload 发布版本时上传的专用更新包
+type artifactUpload struct {
+	Key  string
+	File multipart.File
+	Size int64
+}
+
+// 读取上传请求中的专用更新包，表单字段为 file_<键>，如 file_windows、file_android-astc。
+// 格式无效时输出错误响应；成功时调用方负责关闭文件
+func requestArtifacts(c *gin.Context) ([]artifactUpload, bool) {
+	form := c.Request.MultipartForm
+	if form == nil {
+		return nil, true
+	}
+
+	var uploads []artifactUpload
+	for field, headers := range form.File {
+		key, found := strings.CutPrefix(field, "file_")
+		if !found {
+			continue
+		}
+		if !models.ValidArtifactKey(key) || len(headers) != 1 {
+			closeArtifactUploads(uploads)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的更新包字段: " + field})
+			return nil, false
+		}
+		if !strings.HasSuffix(headers[0].Filename, ".zip") {
+			closeArtifactUploads(uploads)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "只接受ZIP文件: " + field})
+			return nil, false
+		}
+		file, err := headers[0].Open()
+		if err != nil {
+			closeArtifactUploads(uploads)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "上传文件失败: " + field})
+			return nil, false
+		}
+		uploads = append(uploads, artifactUpload{Key: key, File: file, Size: headers[0].Size})
 	}
 
-	// 获取文件信息
-	fileInfo, err := os.Stat(filePath)
+	// 表单字段没有顺序，按键排序使版本信息稳定
+	sort.Slice(uploads, func(i, j int) bool { return uploads[i].Key < uploads[j].Key })
+	return uploads, true
+}
+
+// 关闭上传的专用更新包文件
+func closeArtifactUploads(uploads []artifactUpload) {
+	for _, upload := range uploads {
+		upload.File.Close()
+	}
+}
+
+// 保存专用更新包并计算哈希
+func saveVersionArtifact(ctx context.Context, appID string, versionID string, upload artifactUpload) (models.Artifact, error) {
+	relPath := filepath.Join("versions", versionID, models.ArtifactFileName(upload.Key))
+	hasher, err := putArtifact(ctx, models.GetArtifactKey(appID, relPath), io.NewSectionReader(upload.File, 0, upload.Size), upload.Size)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "无法获取文件信息"})
-		return
+		slog.ErrorContext(ctx, "保存更新包失败", "app", appID, "version", versionID, "artifact", upload.Key, "error", err)
+		return models.Artifact{}, newRequestError(http.StatusInternalServerError, "无法保存文件")
+	}
+
+	return models.Artifact{
+		Key:      upload.Key,
+		FilePath: relPath,
+		FileSize: hasher.Size(),
+		SHA256:   hasher.SHA256(),
+		MD5:      hasher.MD5(),
+		CRC32:    hasher.CRC32(),
+	}, nil
+}
+
+// 在专用更新包的校验错误中注明是哪个更新包
+func artifactPackageError(key string, err error) error {
+	var pkgErr *models.PackageError
+	if errors.As(err, &pkgErr) {
+		return &models.PackageError{Code: pkgErr.Code, Message: fmt.Sprintf("更新包 %s %s", key, pkgErr.Message), Entry: pkgErr.Entry}
 	}
+	return err
+}
+
+// 删除未能发布的版本文件
+func removeArtifacts(ctx context.Context, keys []string) {
+	for _, key := range keys {
+		if err := FileStorage.Delete(ctx, key); err != nil {
+			slog.ErrorContext(ctx, "删除未发布的版本文件失败", "error", err)
+		}
+	}
+}
+
+// 记录发布版本时上传的更新包总大小和上传耗时
+func observeUpload(appID string, method string, version models.Version, startTime time.Time) {
+	size := version.FileSize
+	for _, artifact := range version.Artifacts {
+		size += artifact.FileSize
+	}
+	metrics.UploadBytes.Observe(float64(size), appID, method)
+	metrics.UploadDuration.Observe(time.Since(startTime).Seconds(), appID, method)
+}
 
-	// 加载现有版本列表
-	versionJsonPath := models.GetAppVersionsJsonPath(UploadDir, appID)
-	versionList, err := models.LoadVersions(versionJsonPath)
+// 发布新版本：生成文件清单、保存更新包并校验哈希、写入版本列表，然后在后台生成差分包。
+// 表单上传和分片上传共用此流程
+func publishVersion(ctx context.Context, app models.App, req versionRequest, file io.ReaderAt, size int64) (models.Version, error) {
+	appID := app.ID
+
+	// 保存任何文件之前校验全部更新包，校验失败时不写入版本目录
+	if _, err := models.ValidatePackage(file, size, app.PackageRules); err != nil {
+		return models.Version{}, err
+	}
+	for _, upload := range req.Artifacts {
+		if _, err := models.ValidatePackage(upload.File, upload.Size, app.PackageRules); err != nil {
+			return models.Version{}, artifactPackageError(upload.Key, err)
+		}
+	}
+
+	// 读取ZIP内容为每个更新包生成文件清单，同时校验文件内容的CRC，每个更新包只解压一次
+	archiveManifest, err := models.BuildFileManifest(file, size, req.ID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "无法加载版本列表"})
-		return
+		return models.Version{}, err
+	}
+	manifests := []*models.FileManifest{archiveManifest}
+	for _, upload := range req.Artifacts {
+		manifest, err := models.BuildFileManifest(upload.File, upload.Size, req.ID)
+		if err != nil {
+			return models.Version{}, artifactPackageError(upload.Key, err)
+		}
+		manifest.Artifact = upload.Key
+		manifests = append(manifests, manifest)
+	}
+
+	// 保存文件，同时计算哈希
+	relPath := filepath.Join("versions", req.ID, "update.zip")
+	key := models.GetArtifactKey(appID, relPath)
+	hasher, err := putArtifact(ctx, key, io.NewSectionReader(file, 0, size), size)
+	if err != nil {
+		slog.ErrorContext(ctx, "保存版本文件失败", "app", appID, "version", req.ID, "error", err)
+		return models.Version{}, newRequestError(http.StatusInternalServerError, "无法保存文件")
+	}
+
+	// 上传方提供了SHA-256时，校验文件在传输中没有损坏
+	if req.SHA256 != "" && !strings.EqualFold(req.SHA256, hasher.SHA256()) {
+		if err := FileStorage.Delete(ctx, key); err != nil {
+			slog.ErrorContext(ctx, "删除校验失败的文件失败", "error", err)
+		}
+		return models.Version{}, newRequestError(http.StatusBadRequest, "文件SHA-256校验失败，实际值: "+hasher.SHA256())
+	}
+
+	// 保存平台或纹理格式专用的更新包，任何一个失败时删除已保存的全部文件
+	keys := []string{key}
+	var artifacts []models.Artifact
+	for _, upload := range req.Artifacts {
+		artifact, err := saveVersionArtifact(ctx, appID, req.ID, upload)
+		if err != nil {
+			removeArtifacts(ctx, keys)
+			return models.Version{}, err
+		}
+		keys = append(keys, models.GetArtifactKey(appID, artifact.FilePath))
+		artifacts = append(artifacts, artifact)
 	}
 
 	// 创建新版本信息
 	newVersion := models.Version{
-		ID:          versionID,
-		Name:        name,
-		Description: description,
-		FilePath:    filepath.Join("versions", versionID, "update.zip"),
-		FileSize:    fileInfo.Size(),
-		CreatedAt:   time.Now(),
-		Force:       forceUpdate,
+		ID:            req.ID,
+		Name:          req.Name,
+		Description:   req.Description,
+		ReleaseNotes:  req.ReleaseNotes,
+		FilePath:      relPath,
+		FileSize:      hasher.Size(),
+		SHA256:        hasher.SHA256(),
+		MD5:           hasher.MD5(),
+		CRC32:         hasher.CRC32(),
+		CreatedAt:     time.Now(),
+		Force:         req.Force,
+		Cumulative:    req.Cumulative,
+		Compatibility: req.Compatibility,
+		Artifacts:     artifacts,
+	}
+	if req.Rollout > 0 && req.Rollout < 100 {
+		newVersion.Rollout = &models.Rollout{
+			Percentage: req.Rollout,
+			Status:     models.RolloutActive,
+			UpdatedBy:  req.Publisher,
+			UpdatedAt:  newVersion.CreatedAt,
+		}
 	}
 
-	// 添加到版本列表
-	versionList = models.AddVersion(versionList, newVersion)
+	// 添加到版本列表和渠道并保存，记录渠道的上一个版本用于生成差分包
+	var previousVersionID string
+	err = models.UpdateVersions(MetadataStore, appID, func(versionList *models.VersionList) error {
+		if err := validateNewVersion(app.Scheme(), versionList, req.Channel, req.ID); err != nil {
+			return err
+		}
+		channel, exists := models.GetChannel(versionList, req.Channel)
+		if !exists {
+			return newRequestError(http.StatusNotFound, "渠道不存在")
+		}
+		previousVersionID = channel.Latest()
+		models.AddVersion(versionList, newVersion)
+		models.AddToChannel(versionList, req.Channel, newVersion.ID)
+		return nil
+	})
+	if err != nil {
+		removeArtifacts(ctx, keys)
+		var reqErr *requestError
+		if errors.As(err, &reqErr) {
+			return models.Version{}, err
+		}
+		return models.Version{}, fmt.Errorf("保存版本列表失败: %w", err)
+	}
 
-	// 保存版本列表
-	if err := models.SaveVersions(versionList, versionJsonPath); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "无法保存版本列表"})
-		return
+	for _, manifest := range manifests {
+		if err := models.SaveFileManifest(MetadataStore, appID, manifest); err != nil {
+			slog.ErrorContext(ctx, "保存文件清单失败", "app", appID, "version", req.ID, "artifact", manifest.Artifact, "error", err)
+		}
 	}
 
-	log.Printf("应用 %s 已创建新版本: %s", appID, versionID)
-	c.JSON(http.StatusOK, gin.H{"message": "版本创建成功", "version": newVersion})
+	// 后台生成从上一个版本到此版本的差分包
+	if previousVersionID != "" {
+		scheduleDelta(ctx, appID, previousVersionID, req.ID)
+	}
+
+	slog.InfoContext(ctx, "已创建版本", "app", appID, "version", req.ID, "channel", req.Channel)
+	return newVersion, nil
 }
 
 // ListVersions 列出所有版本
@@ -520,7 +994,7 @@ func ListVersions(c *gin.Context) {
 	appID := c.Param("app_id")
 
 	// 验证应用是否存在
-	appList, err := models.LoadApps(AppsJsonPath)
+	appList, err := models.LoadApps(MetadataStore)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "无法加载应用列表"})
 		return
@@ -531,8 +1005,7 @@ func ListVersions(c *gin.Context) {
 		return
 	}
 
-	versionJsonPath := models.GetAppVersionsJsonPath(UploadDir, appID)
-	versionList, err := models.LoadVersions(versionJsonPath)
+	versionList, err := models.LoadVersions(MetadataStore, appID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "无法加载版本列表"})
 		return
@@ -546,13 +1019,14 @@ func CheckUpdate(c *gin.Context) {
 	appID := c.Param("app_id")
 
 	// 验证应用是否存在
-	appList, err := models.LoadApps(AppsJsonPath)
+	appList, err := models.LoadApps(MetadataStore)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "无法加载应用列表"})
 		return
 	}
 
-	if _, exists := models.GetApp(appList, appID); !exists {
+	app, exists := models.GetApp(appList, appID)
+	if !exists {
 		c.JSON(http.StatusNotFound, gin.H{"error": "应用不存在"})
 		return
 	}
@@ -563,77 +1037,103 @@ func CheckUpdate(c *gin.Context) {
 		return
 	}
 
+	// 按应用的版本号方案比较版本，无法解析的客户端版本号直接拒绝
+	scheme := app.Scheme()
+	if !scheme.Comparable(clientVersion) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "版本号格式无效"})
+		return
+	}
+
 	// 加载版本列表
-	versionJsonPath := models.GetAppVersionsJsonPath(UploadDir, appID)
-	versionList, err := models.LoadVersions(versionJsonPath)
+	versionList, err := models.LoadVersions(MetadataStore, appID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "无法加载版本列表"})
 		return
 	}
 
-	// 如果没有版本
-	if len(versionList.Versions) == 0 {
-		c.JSON(http.StatusOK, gin.H{
-			"hasUpdate": false,
-			"message":   "没有可用更新",
-		})
+	// 只在客户端所在的渠道内计算更新路径
+	channel := c.DefaultQuery("channel", models.DefaultChannel)
+	versions, exists := models.ChannelVersions(versionList, channel)
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "渠道不存在"})
 		return
 	}
 
-	// 获取所有版本
-	versions := versionList.Versions
+	// 去掉已撤回的版本、灰度中该设备还不能更新到的版本和其他平台的版本
+	platform := strings.ToLower(c.Query("platform"))
+	versions = visibleVersions(versions, appID, clientVersion, c.Query("device_id"), platform)
 
-	// 最新版本
-	latestVersion := versions[len(versions)-1]
+	// 去掉与客户端基础包不兼容的版本，没有兼容的更新时引导客户端重新安装
+	versions, incompatible := compatibleVersions(scheme, versions, clientVersion, c.Query("base_build"))
 
-	// 比较版本号
-	versionCompare := compareVersions(clientVersion, latestVersion.ID)
-	hasUpdate := versionCompare < 0 || latestVersion.Force
+	// 最新版本，客户端所在的已撤回版本只用于定位，不算在内
+	latestIndex := len(versions) - 1
+	for latestIndex >= 0 && versions[latestIndex].Yanked != nil {
+		latestIndex--
+	}
 
-	if !hasUpdate {
-		// 没有更新
+	// 如果没有版本
+	if latestIndex < 0 {
+		if incompatible != nil {
+			respondReinstall(c, app, incompatible)
+			return
+		}
+		recordCheck(c, appID, metrics.OutcomeUpToDate, "")
 		c.JSON(http.StatusOK, gin.H{
 			"hasUpdate": false,
-			"message":   "您的版本已是最新",
+			"message":   "没有可用更新",
 		})
 		return
 	}
+	latestVersion := versions[latestIndex]
 
-	// 渐进式更新：找到客户端应该更新到的下一个版本
-	var nextVersion models.Version
+	// 客户端当前版本已撤回并指定了修复版本时，引导客户端更新到修复版本（回滚时为更早的版本）
+	var yanked *models.Yank
+	if current, exists := models.GetVersion(versionList, clientVersion); exists {
+		clientChannel, _ := models.GetChannel(versionList, channel)
+		yanked = clientChannel.YankOf(current)
+	}
 	var updatePath []models.Version
-
-	// 查找客户端当前版本在版本列表中的位置
-	clientVersionIndex := -1
-	for i, v := range versions {
-		if v.ID == clientVersion {
-			clientVersionIndex = i
-			break
-		}
+	rollback := false
+	if yanked != nil {
+		updatePath, rollback = yankedUpdatePath(versions, clientVersion, yanked.FixVersion)
 	}
 
-	// 如果找不到客户端版本，或者客户端版本是最后一个版本，则返回一个空的更新路径
-	if clientVersionIndex == -1 {
-		// 客户端版本不在列表中，找到列表中第一个比客户端版本新的版本
-		for _, v := range versions {
-			if compareVersions(clientVersion, v.ID) < 0 {
-				updatePath = append(updatePath, v)
-				break
+	if len(updatePath) == 0 {
+		// 比较版本号
+		versionCompare := scheme.Compare(clientVersion, latestVersion.ID)
+		hasUpdate := versionCompare < 0 || latestVersion.Force
+
+		if !hasUpdate {
+			if incompatible != nil {
+				respondReinstall(c, app, incompatible)
+				return
 			}
+			// 没有更新
+			recordCheck(c, appID, metrics.OutcomeUpToDate, "")
+			c.JSON(http.StatusOK, gin.H{
+				"hasUpdate": false,
+				"message":   "您的版本已是最新",
+			})
+			return
 		}
-	} else if clientVersionIndex < len(versions)-1 {
-		// 客户端版本在列表中，且不是最新版本，添加下一个版本
-		nextVersion = versions[clientVersionIndex+1]
-		updatePath = append(updatePath, nextVersion)
-	}
 
-	// 如果强制更新，直接返回最新版本
-	if latestVersion.Force && len(updatePath) == 0 {
-		updatePath = append(updatePath, latestVersion)
+		// 计算从客户端版本到最新版本的更新路径
+		updatePath = resolveUpdatePath(scheme, versions, clientVersion)
+
+		// 如果强制更新，直接返回最新版本
+		if latestVersion.Force && len(updatePath) == 0 {
+			updatePath = append(updatePath, latestVersion)
+		}
 	}
 
 	// 如果没有找到合适的更新路径，则返回没有更新
 	if len(updatePath) == 0 {
+		if incompatible != nil {
+			respondReinstall(c, app, incompatible)
+			return
+		}
+		recordCheck(c, appID, metrics.OutcomeUpToDate, "")
 		c.JSON(http.StatusOK, gin.H{
 			"hasUpdate": false,
 			"message":   "没有可用更新",
@@ -642,19 +1142,117 @@ func CheckUpdate(c *gin.Context) {
 	}
 
 	// 返回客户端应该更新的下一个版本
+	// 按客户端的平台和纹理格式选择更新包
+	flavor := strings.ToLower(c.Query("flavor"))
 	nextUpdateVersion := updatePath[0]
+	nextArtifact, err := hashedArtifact(c.Request.Context(), appID, nextUpdateVersion, nextUpdateVersion.ResolveArtifact(platform, flavor))
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "计算文件哈希失败", "app", appID, "version", nextUpdateVersion.ID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "无法计算更新包哈希"})
+		return
+	}
+	updateURL := versionDownloadURL(appID, nextUpdateVersion.ID, nextArtifact)
 
-	c.JSON(http.StatusOK, gin.H{
+	response := gin.H{
 		"hasUpdate":      true,
 		"isProgressive":  true,
 		"appID":          appID,
+		"channel":        channel,
 		"currentVersion": clientVersion,
 		"latestVersion":  latestVersion.ID,
 		"nextVersion":    nextUpdateVersion.ID,
-		"updateUrl":      fmt.Sprintf("/api/apps/%s/download/%s/update.zip", appID, nextUpdateVersion.ID),
+		"updateUrl":      updateURL,
 		"updateInfo":     nextUpdateVersion,
-		"hasMoreUpdates": compareVersions(nextUpdateVersion.ID, latestVersion.ID) < 0,
-	})
+		"sha256":         nextArtifact.SHA256,
+		"md5":            nextArtifact.MD5,
+		"crc32":          nextArtifact.CRC32,
+		"hasMoreUpdates": scheme.Compare(nextUpdateVersion.ID, latestVersion.ID) < 0,
+	}
+
+	// 告知客户端当前版本已撤回，rollback表示需要降级到更早的版本
+	if yanked != nil {
+		response["currentVersionYanked"] = true
+		response["yankReason"] = yanked.Reason
+	}
+	if rollback {
+		response["rollback"] = true
+	}
+
+	if nextArtifact.Key != "" {
+		response["artifact"] = nextArtifact.Key
+	}
+
+	// 有从客户端当前版本出发的差分包时，一并返回，客户端可以代替完整包下载。差分包只针对默认更新包
+	if deltaAvailable(nextUpdateVersion, nextArtifact, clientVersion) {
+		response["delta"] = deltaInfo(appID, nextUpdateVersion)
+	}
+
+	// 签名更新清单，客户端据此确认更新信息来自服务器且未被篡改
+	manifest, err := versionManifest(appID, channel, nextUpdateVersion, nextArtifact, clientVersion, rollback)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "签名更新清单失败", "app", appID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "无法签名更新清单"})
+		return
+	}
+	if manifest != nil {
+		response["manifest"] = manifest
+	}
+
+	// 完整路径模式：一次返回到最新版本的全部步骤，客户端按顺序下载应用
+	if resolveUpdateMode(c, app) == models.UpdateModeFull {
+		steps := make([]gin.H, 0, len(updatePath))
+		var totalSize int64
+		fromVersion := clientVersion
+		for i, version := range updatePath {
+			artifact, err := hashedArtifact(c.Request.Context(), appID, version, version.ResolveArtifact(platform, flavor))
+			if err != nil {
+				slog.ErrorContext(c.Request.Context(), "计算文件哈希失败", "app", appID, "version", version.ID, "error", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "无法计算更新包哈希"})
+				return
+			}
+			size := artifact.FileSize
+			step := gin.H{
+				"version":    version.ID,
+				"url":        versionDownloadURL(appID, version.ID, artifact),
+				"size":       artifact.FileSize,
+				"sha256":     artifact.SHA256,
+				"cumulative": version.Cumulative,
+				"force":      version.Force,
+			}
+			if artifact.Key != "" {
+				step["artifact"] = artifact.Key
+			}
+			if deltaAvailable(version, artifact, fromVersion) {
+				step["delta"] = deltaInfo(appID, version)
+				size = version.Delta.FileSize
+			}
+			stepManifest, err := versionManifest(appID, channel, version, artifact, fromVersion, rollback && i == 0)
+			if err != nil {
+				slog.ErrorContext(c.Request.Context(), "签名更新清单失败", "app", appID, "error", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "无法签名更新清单"})
+				return
+			}
+			if stepManifest != nil {
+				step["manifest"] = stepManifest
+			}
+			steps = append(steps, step)
+			totalSize += size
+			fromVersion = version.ID
+		}
+
+		response["isProgressive"] = false
+		response["updateMode"] = models.UpdateModeFull
+		response["updatePath"] = steps
+		response["totalSize"] = totalSize
+		response["hasMoreUpdates"] = false
+	}
+
+	outcome := metrics.OutcomeUpdate
+	if nextUpdateVersion.Force {
+		outcome = metrics.OutcomeForced
+	}
+	recordCheck(c, appID, outcome, nextUpdateVersion.ID)
+	c.JSON(http.StatusOK, response)
 }
 
 // DownloadFile 下载文件
@@ -664,7 +1262,7 @@ func DownloadFile(c *gin.Context) {
 	filename := c.Param("filename")
 
 	// 验证应用是否存在
-	appList, err := models.LoadApps(AppsJsonPath)
+	appList, err := models.LoadApps(MetadataStore)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "无法加载应用列表"})
 		return
@@ -675,58 +1273,592 @@ func DownloadFile(c *gin.Context) {
 		return
 	}
 
-	// 构造文件路径
-	appDir := models.GetAppUploadDir(UploadDir, appID)
-	filePath := filepath.Join(appDir, "versions", version, filename)
+	// 构造对象键
+	key := models.GetArtifactKey(appID, filepath.Join("versions", version, filename))
+
+	// 对象存储支持预签名地址时，重定向到存储直接下载
+	if downloadURL, err := FileStorage.PresignGet(c.Request.Context(), key, 0); err == nil {
+		if _, err := FileStorage.Stat(c.Request.Context(), key); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "文件不存在"})
+			return
+		}
+		// 重定向后无法得知客户端是否下载完成：版本按已被下载保护，不能再删除；
+		// 统计中单独记为重定向次数，不计入完整下载。HEAD请求不计
+		if c.Request.Method == http.MethodGet {
+			markDownloaded(c.Request.Context(), appID, version)
+			recordRedirect(appID, version)
+		}
+		c.Redirect(http.StatusFound, downloadURL)
+		return
+	} else if !errors.Is(err, storage.ErrPresignNotSupported) {
+		slog.ErrorContext(c.Request.Context(), "生成下载地址失败", "app", appID, "key", key, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "无法生成下载地址"})
+		return
+	}
+
+	// 由服务器提供下载，附带基于内容哈希的强ETag供客户端校验和断点续传
+	setIntegrityHeaders(c, appID, version, filename)
+	serveArtifact(c, key, filename)
+	if written := c.Writer.Size(); written > 0 {
+		metrics.DownloadBytes.Add(float64(written), appID, version)
+	}
+	// 文件完整发送后才记录版本已被下载，不存在的文件、失败和未完成的请求都不计
+	if c.Request.Method == http.MethodGet && downloadCompleted(c) {
+		markDownloaded(c.Request.Context(), appID, version)
+		recordDownload(appID, version)
+	}
+}
+
+// 提供存储中的文件下载，支持Range/If-Range断点续传以及If-None-Match、If-Modified-Since条件请求。
+// 调用前应设置ETag响应头，http.ServeContent据此判断条件请求
+func serveArtifact(c *gin.Context, key string, filename string) {
+	ctx := c.Request.Context()
+
+	contentType := mime.TypeByExtension(filepath.Ext(filename))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	// 同一版本号的文件内容可能因删除后重新上传而变化，允许缓存但每次使用前需用ETag验证
+	c.Header("Cache-Control", "public, no-cache")
+
+	// 支持按范围读取的存储只读取请求的部分，不下载整个对象
+	if getter, ok := FileStorage.(storage.RangeGetter); ok {
+		info, err := FileStorage.Stat(ctx, key)
+		if errors.Is(err, storage.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "文件不存在"})
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "获取文件信息失败", "key", key, "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "无法读取文件"})
+			return
+		}
+
+		content := &rangeReadSeeker{ctx: ctx, getter: getter, key: key, size: info.Size}
+		defer content.Close()
+		http.ServeContent(c.Writer, c.Request, filename, info.ModTime, content)
+		return
+	}
 
-	// 检查文件是否存在
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
+	object, err := FileStorage.Get(ctx, key)
+	if errors.Is(err, storage.ErrNotFound) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "文件不存在"})
 		return
 	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "读取文件失败", "key", key, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "无法读取文件"})
+		return
+	}
+	defer object.Close()
 
-	// 提供文件下载
-	c.Header("Content-Description", "File Transfer")
-	c.Header("Content-Transfer-Encoding", "binary")
-	c.Header("Content-Disposition", "attachment; filename="+filename)
-	c.Header("Content-Type", "application/octet-stream")
-	c.File(filePath)
+	if rs, ok := object.ReadCloser.(io.ReadSeeker); ok {
+		http.ServeContent(c.Writer, c.Request, filename, object.Info.ModTime, rs)
+		return
+	}
+	c.DataFromReader(http.StatusOK, object.Info.Size, contentType, object, nil)
 }
 
-// 比较版本号，返回：
-// -1 如果 v1 < v2
-//
-//	0 如果 v1 == v2
-//	1 如果 v1 > v2
-func compareVersions(v1, v2 string) int {
-	v1Parts := strings.Split(v1, ".")
-	v2Parts := strings.Split(v2, ".")
+// rangeReadSeeker 将按范围读取的存储包装为io.ReadSeeker，供http.ServeContent使用。
+// 每次Seek到新位置后，下一次Read从该位置重新发起范围读取
+type rangeReadSeeker struct {
+	ctx    context.Context
+	getter storage.RangeGetter
+	key    string
+	size   int64
+	offset int64
+	body   io.ReadCloser
+}
 
-	// 获取最大长度
-	maxLen := len(v1Parts)
-	if len(v2Parts) > maxLen {
-		maxLen = len(v2Parts)
+func (r *rangeReadSeeker) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	if r.body == nil {
+		body, err := r.getter.GetRange(r.ctx, r.key, r.offset, r.size-r.offset)
+		if err != nil {
+			return 0, err
+		}
+		r.body = body
 	}
 
-	// 补齐短的版本号
-	for len(v1Parts) < maxLen {
-		v1Parts = append(v1Parts, "0")
+	n, err := r.body.Read(p)
+	r.offset += int64(n)
+	return n, err
+}
+
+func (r *rangeReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	var target int64
+	switch whence {
+	case io.SeekStart:
+		target = offset
+	case io.SeekCurrent:
+		target = r.offset + offset
+	case io.SeekEnd:
+		target = r.size + offset
+	default:
+		return 0, errors.New("无效的whence")
 	}
-	for len(v2Parts) < maxLen {
-		v2Parts = append(v2Parts, "0")
+	if target < 0 {
+		return 0, errors.New("无效的偏移量")
 	}
 
-	// 逐段比较
-	for i := 0; i < maxLen; i++ {
-		num1, _ := strconv.Atoi(v1Parts[i])
-		num2, _ := strconv.Atoi(v2Parts[i])
+	if target != r.offset {
+		r.Close()
+		r.offset = target
+	}
+	return target, nil
+}
+
+func (r *rangeReadSeeker) Close() error {
+	if r.body == nil {
+		return nil
+	}
+	err := r.body.Close()
+	r.body = nil
+	return err
+}
 
-		if num1 < num2 {
-			return -1
-		} else if num1 > num2 {
-			return 1
+// 计算客户端版本之后的更新路径：从客户端的下一个版本一直到最新版本。
+// 路径中有累积版本时，直接跳到最后一个累积版本，省去它之前的中间版本
+func resolveUpdatePath(scheme models.VersionScheme, versions []models.Version, clientVersion string) []models.Version {
+	// 查找客户端当前版本之后的第一个版本
+	start := -1
+	for i, v := range versions {
+		if v.ID == clientVersion {
+			start = i + 1
+			break
+		}
+	}
+	if start == -1 {
+		// 客户端版本不在列表中，找到列表中第一个比客户端版本新的版本
+		start = len(versions)
+		for i, v := range versions {
+			if scheme.Compare(clientVersion, v.ID) < 0 {
+				start = i
+				break
+			}
+		}
+	}
+
+	for i := len(versions) - 1; i > start; i-- {
+		if versions[i].Cumulative {
+			start = i
+			break
+		}
+	}
+
+	return versions[start:]
+}
+
+// 计算已撤回版本上的客户端到修复版本的更新路径，返回的布尔值表示是否为回滚（修复版本更早）。
+// 修复版本对该客户端不可见时返回nil，按正常流程计算
+func yankedUpdatePath(versions []models.Version, clientVersion string, fixVersion string) ([]models.Version, bool) {
+	if fixVersion == "" {
+		return nil, false
+	}
+
+	fixIndex, clientIndex := -1, -1
+	for i, version := range versions {
+		switch version.ID {
+		case fixVersion:
+			fixIndex = i
+		case clientVersion:
+			clientIndex = i
 		}
 	}
+	if fixIndex < 0 {
+		return nil, false
+	}
 
-	return 0
+	// 从修复版本开始，跳过其后已撤回的版本（即客户端当前版本）
+	path := make([]models.Version, 0, len(versions)-fixIndex)
+	for _, version := range versions[fixIndex:] {
+		if version.Yanked == nil {
+			path = append(path, version)
+		}
+	}
+	for i := len(path) - 1; i > 0; i-- {
+		if path[i].Cumulative {
+			path = path[i:]
+			break
+		}
+	}
+
+	if len(path) == 0 {
+		return nil, false
+	}
+
+	// 路径的第一个版本在客户端当前版本之前时需要降级
+	for i, version := range versions {
+		if version.ID == path[0].ID {
+			return path, i < clientIndex
+		}
+	}
+	return path, false
+}
+
+// 去掉不向该客户端提供的版本：已撤回的版本和灰度中未选中该设备的版本，
+// 以及它们之后的非累积版本（依赖这些版本的改动），直到下一个可以提供的累积版本。
+// 客户端当前版本始终保留，用于定位更新路径的起点
+func visibleVersions(versions []models.Version, appID string, clientVersion string, deviceID string, platform string) []models.Version {
+	visible := make([]models.Version, 0, len(versions))
+	blocked := false
+	for _, version := range versions {
+		if version.ID == clientVersion {
+			visible = append(visible, version)
+			blocked = false
+			continue
+		}
+		// 其他平台的版本与该客户端无关，直接跳过，不影响之后的版本
+		if !version.Compatibility.SupportsPlatform(platform) {
+			continue
+		}
+		if version.Cumulative {
+			blocked = false
+		}
+		if version.Yanked != nil || !version.Rollout.Includes(appID, version.ID, deviceID) {
+			blocked = true
+		}
+		if !blocked {
+			visible = append(visible, version)
+		}
+	}
+	return visible
+}
+
+// 判断应用商店地址是否有效：需要包含协议和主机，如https://或market://
+func validStoreURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && u.Scheme != "" && u.Host != ""
+}
+
+// 去掉客户端不能热更新到的版本：要求其他基础包或更高客户端版本的版本，
+// 以及它们之后的非累积版本，直到下一个兼容的累积版本。
+// 返回比客户端新的第一个不兼容版本的原因，没有时为nil
+func compatibleVersions(scheme models.VersionScheme, versions []models.Version, clientVersion string, baseBuild string) ([]models.Version, error) {
+	compatible := make([]models.Version, 0, len(versions))
+	var incompatible error
+	blocked := false
+	for _, version := range versions {
+		if version.ID == clientVersion {
+			compatible = append(compatible, version)
+			blocked = false
+			continue
+		}
+		if version.Cumulative {
+			blocked = false
+		}
+		if err := version.Compatibility.Check(scheme, clientVersion, baseBuild); err != nil {
+			blocked = true
+			if incompatible == nil && scheme.Compare(clientVersion, version.ID) < 0 {
+				incompatible = fmt.Errorf("版本 %s %w", version.ID, err)
+			}
+		}
+		if !blocked {
+			compatible = append(compatible, version)
+		}
+	}
+	return compatible, incompatible
+}
+
+// 客户端没有兼容的热更新路径，返回需要重新安装完整客户端的响应
+func respondReinstall(c *gin.Context, app models.App, reason error) {
+	recordCheck(c, app.ID, metrics.OutcomeReinstall, "")
+	c.JSON(http.StatusOK, gin.H{
+		"hasUpdate":         false,
+		"reinstallRequired": true,
+		"storeUrl":          app.StoreURL,
+		"reason":            reason.Error(),
+		"message":           "当前客户端无法热更新，请重新安装完整客户端",
+	})
+}
+
+// 获取本次请求使用的更新模式，查询参数mode优先于应用设置
+func resolveUpdateMode(c *gin.Context, app models.App) string {
+	if mode := c.Query("mode"); models.ValidUpdateMode(mode) {
+		return mode
+	}
+	if app.UpdateMode != "" {
+		return app.UpdateMode
+	}
+	return models.UpdateModeProgressive
+}
+
+// 获取版本更新包的下载地址
+func versionDownloadURL(appID string, versionID string, artifact models.Artifact) string {
+	return fmt.Sprintf("/api/apps/%s/download/%s/%s", appID, versionID, models.ArtifactFileName(artifact.Key))
+}
+
+// 判断能否用差分包从fromVersion更新，差分包只针对默认更新包生成
+func deltaAvailable(version models.Version, artifact models.Artifact, fromVersion string) bool {
+	return artifact.Key == "" && version.Delta.Available(fromVersion)
+}
+
+// 生成版本的签名更新清单，有从fromVersion出发的差分包时包含差分包信息；未配置签名密钥时返回nil
+func versionManifest(appID string, channel string, version models.Version, artifact models.Artifact, fromVersion string, rollback bool) (*SignedManifest, error) {
+	manifest := models.UpdateManifest{
+		AppID:    appID,
+		Channel:  channel,
+		Version:  version.ID,
+		Artifact: artifact.Key,
+		SHA256:   artifact.SHA256,
+		Size:     artifact.FileSize,
+		URL:      versionDownloadURL(appID, version.ID, artifact),
+		Force:    version.Force,
+		Rollback: rollback,
+		IssuedAt: time.Now().UTC(),
+	}
+	if version.Compatibility != nil {
+		manifest.MinClientVersion = version.Compatibility.MinClientVersion
+		manifest.BaseBuild = version.Compatibility.BaseBuild
+	}
+	if deltaAvailable(version, artifact, fromVersion) {
+		manifest.DeltaFrom = version.Delta.FromVersion
+		manifest.DeltaSHA256 = version.Delta.SHA256
+		manifest.DeltaSize = version.Delta.FileSize
+		manifest.DeltaURL = deltaDownloadURL(appID, version)
+	}
+	return signManifest(manifest)
+}
+
+// 检查更新响应中的差分包信息
+func deltaInfo(appID string, version models.Version) gin.H {
+	return gin.H{
+		"fromVersion": version.Delta.FromVersion,
+		"url":         deltaDownloadURL(appID, version),
+		"size":        version.Delta.FileSize,
+		"sha256":      version.Delta.SHA256,
+		"format":      zipdelta.Format,
+	}
+}
+
+// 保存版本文件并在写入过程中计算哈希，size为上传方声明的大小（未知时为-1）
+func putArtifact(ctx context.Context, key string, r io.Reader, size int64) (*utils.Hasher, error) {
+	hasher := utils.NewHasher()
+	if err := FileStorage.Put(ctx, key, io.TeeReader(r, hasher), size); err != nil {
+		return nil, err
+	}
+
+	if size >= 0 && hasher.Size() != size {
+		FileStorage.Delete(ctx, key)
+		return nil, fmt.Errorf("文件大小不一致: 声明 %d 字节，实际 %d 字节", size, hasher.Size())
+	}
+	return hasher, nil
+}
+
+// 读取已存储的文件并计算哈希
+func hashStoredFile(ctx context.Context, key string) (*utils.Hasher, error) {
+	object, err := FileStorage.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer object.Close()
+
+	hasher := utils.NewHasher()
+	if _, err := io.Copy(hasher, object); err != nil {
+		return nil, err
+	}
+	return hasher, nil
+}
+
+// 为版本文件设置ETag和Digest响应头
+func setIntegrityHeaders(c *gin.Context, appID string, versionID string, filename string) {
+	versionList, err := models.LoadVersions(MetadataStore, appID)
+	if err != nil {
+		return
+	}
+
+	version, exists := models.GetVersion(versionList, versionID)
+	if !exists {
+		return
+	}
+	artifact, exists := version.ArtifactByFile(filename)
+	if !exists {
+		return
+	}
+
+	// 专用更新包在保存时就计算了哈希
+	if artifact.Key != "" {
+		c.Header("ETag", artifact.ETag())
+		c.Header("Digest", digestHeader(artifact.SHA256, artifact.MD5))
+		return
+	}
+
+	// 哈希功能之前上传的版本没有记录哈希，首次下载时计算并保存
+	version, err = ensureVersionHash(c.Request.Context(), appID, version)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "计算文件哈希失败", "app", appID, "version", versionID, "error", err)
+		return
+	}
+
+	c.Header("ETag", version.ETag())
+	c.Header("Digest", digestHeader(version.SHA256, version.MD5))
+}
+
+// hashCall 进行中的版本哈希计算，同一版本的并发请求等待同一次计算
+type hashCall struct {
+	done    chan struct{}
+	version models.Version
+	err     error
+}
+
+var (
+	hashingMu sync.Mutex
+	hashing   = map[string]*hashCall{} // 应用ID/版本ID -> 进行中的计算
+)
+
+// 哈希功能之前上传的版本没有记录默认更新包的哈希，计算并保存后返回带有哈希的版本。
+// 计算不受发起请求的客户端断开影响，同一版本同时只计算一次
+func ensureVersionHash(ctx context.Context, appID string, version models.Version) (models.Version, error) {
+	if version.SHA256 != "" {
+		return version, nil
+	}
+
+	id := appID + "/" + version.ID
+	hashingMu.Lock()
+	call, running := hashing[id]
+	if !running {
+		call = &hashCall{done: make(chan struct{})}
+		hashing[id] = call
+	}
+	hashingMu.Unlock()
+
+	if !running {
+		call.version, call.err = hashVersion(context.WithoutCancel(ctx), appID, version)
+		hashingMu.Lock()
+		delete(hashing, id)
+		hashingMu.Unlock()
+		close(call.done)
+	}
+
+	select {
+	case <-call.done:
+		return call.version, call.err
+	case <-ctx.Done():
+		return version, ctx.Err()
+	}
+}
+
+// 计算版本默认更新包的哈希并保存到版本信息
+func hashVersion(ctx context.Context, appID string, version models.Version) (models.Version, error) {
+	hasher, err := hashStoredFile(ctx, models.GetArtifactKey(appID, version.FilePath))
+	if err != nil {
+		return version, err
+	}
+	version.SHA256 = hasher.SHA256()
+	version.MD5 = hasher.MD5()
+	version.CRC32 = hasher.CRC32()
+
+	err = models.UpdateVersions(MetadataStore, appID, func(versionList *models.VersionList) error {
+		stored, exists := models.GetVersion(versionList, version.ID)
+		if !exists || stored.SHA256 != "" {
+			return errNoChange
+		}
+		stored.SHA256, stored.MD5, stored.CRC32 = version.SHA256, version.MD5, version.CRC32
+		models.SetVersion(versionList, stored)
+		return nil
+	})
+	if err != nil && !errors.Is(err, errNoChange) {
+		// 哈希已经算出，下次请求会重新计算并保存
+		slog.ErrorContext(ctx, "保存文件哈希失败", "app", appID, "version", version.ID, "error", err)
+	}
+	return version, nil
+}
+
+// 获取客户端要下载的更新包，默认更新包没有记录哈希时先计算哈希，检查更新的响应和签名清单中都包含哈希
+func hashedArtifact(ctx context.Context, appID string, version models.Version, artifact models.Artifact) (models.Artifact, error) {
+	if artifact.Key != "" || artifact.SHA256 != "" {
+		return artifact, nil
+	}
+	version, err := ensureVersionHash(ctx, appID, version)
+	if err != nil {
+		return artifact, err
+	}
+	return version.DefaultArtifact(), nil
+}
+
+// 生成Digest响应头，md5为空时只包含SHA-256
+func digestHeader(sha256 string, md5 string) string {
+	digests := []string{utils.DigestHeader("sha-256", sha256)}
+	if md5 != "" {
+		digests = append(digests, utils.DigestHeader("md5", md5))
+	}
+	return strings.Join(digests, ",")
+}
+
+// 从应用列表中移除应用，用于创建应用失败时回滚
+func removeApp(ctx context.Context, appID string) {
+	err := models.UpdateApps(MetadataStore, func(appList *models.AppList) error {
+		models.DeleteApp(appList, appID)
+		return nil
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "回滚应用失败", "app", appID, "error", err)
+	}
+}
+
+// 路径参数在错误提示中的名称
+var pathParamNames = map[string]string{
+	"filename":  "文件名",
+	"upload_id": "上传会话ID",
+	"token_id":  "令牌ID",
+}
+
+// validatePathParams 在处理请求之前校验路径参数中的应用ID、版本ID、文件名和会话ID，
+// 参数无效时返回400，处理函数可以直接用它们拼接存储路径
+func validatePathParams() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		for _, param := range c.Params {
+			var err error
+			switch param.Key {
+			case "app_id":
+				err = models.ValidateAppID(param.Value)
+			case "version", "version_id", "from", "to":
+				err = models.ValidateVersionID(param.Value)
+			case "filename", "upload_id", "token_id":
+				if !models.ValidFileName(param.Value) {
+					err = errors.New(pathParamNames[param.Key] + "无效")
+				}
+			}
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		c.Next()
+	}
+}
+
+// requestError 在元数据更新闭包中返回的业务错误，带有HTTP状态码和提示信息
+type requestError struct {
+	status  int
+	message string
+}
+
+func (e *requestError) Error() string {
+	return e.message
+}
+
+// 创建业务错误
+func newRequestError(status int, message string) error {
+	return &requestError{status: status, message: message}
+}
+
+// 输出错误响应：业务错误使用其状态码和提示，其他错误记录日志并返回500
+func respondError(c *gin.Context, err error, message string) {
+	var reqErr *requestError
+	if errors.As(err, &reqErr) {
+		c.JSON(reqErr.status, gin.H{"error": reqErr.message})
+		return
+	}
+	var pkgErr *models.PackageError
+	if errors.As(err, &pkgErr) {
+		respondPackageError(c, pkgErr)
+		return
+	}
+	slog.ErrorContext(c.Request.Context(), message, "error", err)
+	c.JSON(http.StatusInternalServerError, gin.H{"error": message})
 }
@@ -0,0 +1,282 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"hotupdate/app/models"
+	"hotupdate/app/storage"
+	"hotupdate/app/store"
+)
+
+// 测试共用的服务器。控制器使用包级的存储和后台任务，整个测试只初始化一次，
+// 各测试使用不同的应用或版本号避免互相影响
+var testServer *gin.Engine
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	dir, err := os.MkdirTemp("", "hotupdate-test-*")
+	if err != nil {
+		panic(err)
+	}
+	// 上传目录之外的文件，任何请求都不应读到它
+	if err := os.WriteFile(filepath.Join(dir, "secret.txt"), []byte(testSecret), 0600); err != nil {
+		panic(err)
+	}
+	uploadDir := filepath.Join(dir, "uploads")
+	testServer = gin.New()
+	testServer.SetTrustedProxies(nil)
+	metadataStore := store.NewJSONStore(uploadDir)
+	SetupAuthController(testServer, metadataStore, "", "", 0)
+	SetupVersionController(testServer, uploadDir, metadataStore, &checkedStorage{Storage: storage.NewLocalStorage(uploadDir), root: uploadDir})
+
+	// 等待后台初始化（创建默认应用及其初始版本1.0.0）完成
+	for deadline := time.Now().Add(5 * time.Second); !isReady.Load(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			panic("服务器初始化超时")
+		}
+	}
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// addTestVersion 创建应用（不存在时）并发布一个默认更新包内容为data的版本
+func addTestVersion(t testing.TB, appID string, versionID string, data []byte) models.Version {
+	t.Helper()
+
+	err := models.UpdateApps(MetadataStore, func(appList *models.AppList) error {
+		if _, exists := models.GetApp(appList, appID); !exists {
+			models.AddApp(appList, models.App{ID: appID, Name: appID, CreatedAt: time.Now(), UpdatedAt: time.Now()})
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	relPath := filepath.Join("versions", versionID, "update.zip")
+	if err := FileStorage.Put(context.Background(), models.GetArtifactKey(appID, relPath), bytes.NewReader(data), int64(len(data))); err != nil {
+		t.Fatal(err)
+	}
+
+	sum := sha256.Sum256(data)
+	version := models.Version{
+		ID:        versionID,
+		FilePath:  relPath,
+		FileSize:  int64(len(data)),
+		SHA256:    hex.EncodeToString(sum[:]),
+		CreatedAt: time.Now(),
+	}
+	err = models.UpdateVersions(MetadataStore, appID, func(versionList *models.VersionList) error {
+		models.AddVersion(versionList, version)
+		models.AddToChannel(versionList, models.DefaultChannel, versionID)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return version
+}
+
+// 向测试服务器发送请求并返回响应
+func serve(method string, target string, header map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	for name, value := range header {
+		req.Header.Set(name, value)
+	}
+	w := httptest.NewRecorder()
+	testServer.ServeHTTP(w, req)
+	return w
+}
+
+func testArtifactData(size int) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(1)).Read(data)
+	return data
+}
+
+// 两个下载路由都支持断点续传，返回相同的ETag
+var downloadRoutes = []struct {
+	name  string
+	appID string
+	url   string // 下载地址，%s为版本号
+}{
+	{"应用路由", "game", "/api/apps/game/download/%s/update.zip"},
+	{"旧路由", "default", "/api/download/%s/update.zip"},
+}
+
+func TestDownloadRange(t *testing.T) {
+	data := testArtifactData(64 << 10)
+	for _, route := range downloadRoutes {
+		t.Run(route.name, func(t *testing.T) {
+			url := fmt.Sprintf(route.url, "2.0.0")
+			version := addTestVersion(t, route.appID, "2.0.0", data)
+			etag := `"` + version.SHA256 + `"`
+
+			w := serve(http.MethodGet, url, nil)
+			if w.Code != http.StatusOK {
+				t.Fatalf("完整下载返回 %d: %s", w.Code, w.Body.String())
+			}
+			if got := w.Header().Get("ETag"); got != etag {
+				t.Errorf("ETag = %s，期望 %s", got, etag)
+			}
+			if got := w.Header().Get("Accept-Ranges"); got != "bytes" {
+				t.Errorf("Accept-Ranges = %q", got)
+			}
+			if !bytes.Equal(w.Body.Bytes(), data) {
+				t.Error("完整下载的内容不一致")
+			}
+
+			w = serve(http.MethodGet, url, map[string]string{"Range": "bytes=100-199"})
+			if w.Code != http.StatusPartialContent {
+				t.Fatalf("范围请求返回 %d", w.Code)
+			}
+			if got, want := w.Header().Get("Content-Range"), fmt.Sprintf("bytes 100-199/%d", len(data)); got != want {
+				t.Errorf("Content-Range = %q，期望 %q", got, want)
+			}
+			if !bytes.Equal(w.Body.Bytes(), data[100:200]) {
+				t.Error("范围请求的内容不一致")
+			}
+
+			// 只请求末尾部分
+			w = serve(http.MethodGet, url, map[string]string{"Range": "bytes=-10"})
+			if w.Code != http.StatusPartialContent || !bytes.Equal(w.Body.Bytes(), data[len(data)-10:]) {
+				t.Errorf("后缀范围请求返回 %d", w.Code)
+			}
+		})
+	}
+}
+
+func TestDownloadIfRange(t *testing.T) {
+	data := testArtifactData(64 << 10)
+	for _, route := range downloadRoutes {
+		t.Run(route.name, func(t *testing.T) {
+			url := fmt.Sprintf(route.url, "2.1.0")
+			version := addTestVersion(t, route.appID, "2.1.0", data)
+
+			// ETag一致时只返回请求的范围
+			w := serve(http.MethodGet, url, map[string]string{
+				"Range":    "bytes=1000-",
+				"If-Range": `"` + version.SHA256 + `"`,
+			})
+			if w.Code != http.StatusPartialContent {
+				t.Fatalf("If-Range匹配时返回 %d", w.Code)
+			}
+			if !bytes.Equal(w.Body.Bytes(), data[1000:]) {
+				t.Error("续传的内容不一致")
+			}
+
+			// 文件已变化（ETag过期）时返回完整文件，客户端应丢弃已下载的部分
+			w = serve(http.MethodGet, url, map[string]string{
+				"Range":    "bytes=1000-",
+				"If-Range": `"0000000000000000000000000000000000000000000000000000000000000000"`,
+			})
+			if w.Code != http.StatusOK {
+				t.Fatalf("If-Range过期时返回 %d", w.Code)
+			}
+			if w.Header().Get("Content-Range") != "" {
+				t.Errorf("If-Range过期时不应返回Content-Range: %s", w.Header().Get("Content-Range"))
+			}
+			if !bytes.Equal(w.Body.Bytes(), data) {
+				t.Error("If-Range过期时应返回完整文件")
+			}
+		})
+	}
+}
+
+func TestDownloadRangeNotSatisfiable(t *testing.T) {
+	data := testArtifactData(4 << 10)
+	for _, route := range downloadRoutes {
+		t.Run(route.name, func(t *testing.T) {
+			url := fmt.Sprintf(route.url, "2.2.0")
+			addTestVersion(t, route.appID, "2.2.0", data)
+
+			for _, rangeHeader := range []string{
+				fmt.Sprintf("bytes=%d-", len(data)),
+				fmt.Sprintf("bytes=%d-%d", len(data)+10, len(data)+20),
+			} {
+				w := serve(http.MethodGet, url, map[string]string{"Range": rangeHeader})
+				if w.Code != http.StatusRequestedRangeNotSatisfiable {
+					t.Errorf("%s 返回 %d，期望416", rangeHeader, w.Code)
+				}
+				if got, want := w.Header().Get("Content-Range"), fmt.Sprintf("bytes */%d", len(data)); got != want {
+					t.Errorf("%s 的Content-Range = %q，期望 %q", rangeHeader, got, want)
+				}
+			}
+		})
+	}
+}
+
+// 从一个路由下载一部分后，从另一个路由续传，ETag相同时得到完整的文件
+func TestDownloadResumeAcrossRoutes(t *testing.T) {
+	data := testArtifactData(64 << 10)
+	addTestVersion(t, "default", "2.3.0", data)
+	const split = 20000
+
+	first := serve(http.MethodGet, "/api/apps/default/download/2.3.0/update.zip", map[string]string{"Range": fmt.Sprintf("bytes=0-%d", split-1)})
+	if first.Code != http.StatusPartialContent {
+		t.Fatalf("第一段返回 %d", first.Code)
+	}
+	etag := first.Header().Get("ETag")
+
+	rest := serve(http.MethodGet, "/api/download/2.3.0/update.zip", map[string]string{
+		"Range":    fmt.Sprintf("bytes=%d-", split),
+		"If-Range": etag,
+	})
+	if rest.Code != http.StatusPartialContent {
+		t.Fatalf("续传返回 %d", rest.Code)
+	}
+	if rest.Header().Get("ETag") != etag {
+		t.Errorf("两个路由的ETag不同：%s 和 %s", etag, rest.Header().Get("ETag"))
+	}
+	if got := append(first.Body.Bytes(), rest.Body.Bytes()...); !bytes.Equal(got, data) {
+		t.Error("续传后的文件不完整")
+	}
+}
+
+// 只有下载到文件末尾才记录版本已被下载，不存在的文件和部分下载不计
+func TestDownloadMarksCompleteDownloads(t *testing.T) {
+	data := testArtifactData(4 << 10)
+	addTestVersion(t, "game", "2.4.0", data)
+
+	downloaded := func() bool {
+		versionList, err := models.LoadVersions(MetadataStore, "game")
+		if err != nil {
+			t.Fatal(err)
+		}
+		version, _ := models.GetVersion(versionList, "2.4.0")
+		return version.DownloadedAt != nil
+	}
+
+	if w := serve(http.MethodGet, "/api/apps/game/download/2.4.0/missing.zip", nil); w.Code != http.StatusNotFound {
+		t.Fatalf("不存在的文件返回 %d", w.Code)
+	}
+	serve(http.MethodHead, "/api/apps/game/download/2.4.0/update.zip", nil)
+	serve(http.MethodGet, "/api/apps/game/download/2.4.0/update.zip", map[string]string{"Range": "bytes=0-99"})
+	if downloaded() {
+		t.Fatal("未完成的下载不应记录为已下载")
+	}
+
+	serve(http.MethodGet, "/api/apps/game/download/2.4.0/update.zip", map[string]string{"Range": "bytes=100-"})
+	if !downloaded() {
+		t.Fatal("下载到文件末尾后应记录为已下载")
+	}
+}
@@ -0,0 +1,235 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"hotupdate/app/models"
+)
+
+// 注册单个版本的查看、修改和删除路由
+func setupVersionDetailRoutes(r *gin.Engine) {
+	r.GET("/api/apps/:app_id/versions/:version_id", RoleRequired(models.RoleViewer), GetVersionInfo)
+	r.PATCH("/api/apps/:app_id/versions/:version_id", RoleRequired(models.RoleUploader), UpdateVersion)
+	r.DELETE("/api/apps/:app_id/versions/:version_id", RoleRequired(models.RoleOwner), DeleteVersion)
+}
+
+// GetVersionInfo 获取版本详情和所在的渠道
+func GetVersionInfo(c *gin.Context) {
+	appID := c.Param("app_id")
+	versionID := c.Param("version_id")
+
+	versionList, err := models.LoadVersions(MetadataStore, appID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "无法加载版本列表"})
+		return
+	}
+
+	version, exists := models.GetVersion(versionList, versionID)
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "版本不存在"})
+		return
+	}
+
+	channels := []string{}
+	for _, channel := range versionList.Channels {
+		if channel.Contains(versionID) {
+			channels = append(channels, channel.Name)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"version":  version,
+		"channels": channels,
+		"latest":   versionList.LatestVersion == versionID,
+	})
+}
+
+// UpdateVersion 修改版本的名称、描述、发布说明和强制更新标记，只修改请求中提供的字段。
+// 更新包和版本号不能修改，需要发布新版本
+func UpdateVersion(c *gin.Context) {
+	appID := c.Param("app_id")
+	versionID := c.Param("version_id")
+
+	name, setName := c.GetPostForm("name")
+	description, setDescription := c.GetPostForm("description")
+	releaseNotes, setReleaseNotes := c.GetPostForm("release_notes")
+	force, setForce := c.GetPostForm("force")
+
+	if !setName && !setDescription && !setReleaseNotes && !setForce {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "没有要修改的字段"})
+		return
+	}
+	if setForce && force != "true" && force != "false" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "force必须是true或false"})
+		return
+	}
+
+	// 与上传时一致，修改强制更新需要发布经理及以上角色
+	if setForce && !callerRole(c).AtLeast(models.RoleReleaseManager) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "权限不足，修改强制更新需要角色: " + string(models.RoleReleaseManager)})
+		return
+	}
+
+	var updated models.Version
+	err := models.UpdateVersions(MetadataStore, appID, func(versionList *models.VersionList) error {
+		version, exists := models.GetVersion(versionList, versionID)
+		if !exists {
+			return newRequestError(http.StatusNotFound, "版本不存在")
+		}
+
+		if setName {
+			version.Name = name
+		}
+		if setDescription {
+			version.Description = description
+		}
+		if setReleaseNotes {
+			version.ReleaseNotes = releaseNotes
+		}
+		if setForce {
+			version.Force = force == "true"
+		}
+		models.SetVersion(versionList, version)
+		updated = version
+		return nil
+	})
+	if err != nil {
+		respondError(c, err, "无法保存版本列表")
+		return
+	}
+
+	slog.InfoContext(c.Request.Context(), "已修改版本", "app", appID, "version", versionID, "operator", c.GetString("username"))
+	c.JSON(http.StatusOK, gin.H{"message": "版本更新成功", "version": updated})
+}
+
+// DeleteVersion 删除从未被下载过的版本及其全部文件。
+// 最新版本、其他版本依赖的版本和修复版本不能删除；已经发布给客户端的版本应改用撤回
+func DeleteVersion(c *gin.Context) {
+	appID := c.Param("app_id")
+	versionID := c.Param("version_id")
+
+	var deleted models.Version
+	err := models.UpdateVersions(MetadataStore, appID, func(versionList *models.VersionList) error {
+		version, exists := models.GetVersion(versionList, versionID)
+		if !exists {
+			return newRequestError(http.StatusNotFound, "版本不存在")
+		}
+		if err := checkVersionDeletable(versionList, version); err != nil {
+			return err
+		}
+		models.DeleteVersion(versionList, versionID)
+		deleted = version
+		return nil
+	})
+	if err != nil {
+		respondError(c, err, "无法保存版本列表")
+		return
+	}
+
+	if err := models.DeleteFileManifests(MetadataStore, appID, deleted); err != nil {
+		slog.ErrorContext(c.Request.Context(), "删除文件清单失败", "app", appID, "version", versionID, "error", err)
+	}
+	if err := models.DeleteReportDevices(MetadataStore, appID, versionID); err != nil {
+		slog.ErrorContext(c.Request.Context(), "删除上报设备失败", "app", appID, "version", versionID, "error", err)
+	}
+	removeArtifacts(context.Background(), versionArtifactKeys(appID, deleted))
+
+	slog.InfoContext(c.Request.Context(), "已删除版本", "app", appID, "version", versionID, "operator", c.GetString("username"))
+	c.JSON(http.StatusOK, gin.H{"message": "版本删除成功", "version": deleted})
+}
+
+// 检查版本能否删除：没有被下载过、不是任何渠道的最新版本、不是已撤回版本的修复版本，
+// 并且渠道中之后的非累积版本不依赖它（否则更早的客户端会缺少它的改动）
+func checkVersionDeletable(versionList *models.VersionList, version models.Version) error {
+	if version.DownloadedAt != nil {
+		return newRequestError(http.StatusConflict, "版本已被下载过，不能删除，请改用撤回")
+	}
+	if versionList.LatestVersion == version.ID {
+		return newRequestError(http.StatusConflict, "不能删除最新版本")
+	}
+
+	for _, channel := range versionList.Channels {
+		if channel.Latest() == version.ID {
+			return newRequestError(http.StatusConflict, fmt.Sprintf("版本是渠道 %s 的最新版本，不能删除", channel.Name))
+		}
+		if !channel.Contains(version.ID) {
+			continue
+		}
+
+		after := false
+		for _, id := range channel.Versions {
+			if id == version.ID {
+				after = true
+				continue
+			}
+			if !after {
+				continue
+			}
+			next, _ := models.GetVersion(versionList, id)
+			if next.Cumulative {
+				break
+			}
+			if channel.YankOf(next) == nil {
+				return newRequestError(http.StatusConflict, fmt.Sprintf("渠道 %s 中的非累积版本 %s 依赖此版本，不能删除", channel.Name, id))
+			}
+		}
+	}
+
+	for _, other := range versionList.Versions {
+		if other.Yanked != nil && other.Yanked.FixVersion == version.ID {
+			return newRequestError(http.StatusConflict, fmt.Sprintf("版本是已撤回版本 %s 的修复版本，不能删除", other.ID))
+		}
+	}
+	for _, channel := range versionList.Channels {
+		for id, yank := range channel.Yanked {
+			if yank.FixVersion == version.ID {
+				return newRequestError(http.StatusConflict, fmt.Sprintf("版本是渠道 %s 中回滚撤回的版本 %s 的修复版本，不能删除", channel.Name, id))
+			}
+		}
+	}
+	return nil
+}
+
+// 获取版本在存储中的全部文件：默认更新包、专用更新包和差分包
+func versionArtifactKeys(appID string, version models.Version) []string {
+	keys := []string{models.GetArtifactKey(appID, version.FilePath)}
+	for _, artifact := range version.Artifacts {
+		keys = append(keys, models.GetArtifactKey(appID, artifact.FilePath))
+	}
+	if version.Delta != nil && version.Delta.FilePath != "" {
+		keys = append(keys, models.GetArtifactKey(appID, version.Delta.FilePath))
+	}
+	return keys
+}
+
+// 记录版本首次被下载的时间，之后该版本不能再删除
+func markDownloaded(ctx context.Context, appID string, versionID string) {
+	versionList, err := models.LoadVersions(MetadataStore, appID)
+	if err != nil {
+		return
+	}
+	if version, exists := models.GetVersion(versionList, versionID); !exists || version.DownloadedAt != nil {
+		return
+	}
+
+	err = models.UpdateVersions(MetadataStore, appID, func(versionList *models.VersionList) error {
+		version, exists := models.GetVersion(versionList, versionID)
+		if !exists || version.DownloadedAt != nil {
+			return errNoChange
+		}
+		now := time.Now()
+		version.DownloadedAt = &now
+		models.SetVersion(versionList, version)
+		return nil
+	})
+	if err != nil && !errors.Is(err, errNoChange) {
+		slog.ErrorContext(ctx, "记录下载时间失败", "app", appID, "version", versionID, "error", err)
+	}
+}
@@ -0,0 +1,177 @@
+package controllers
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"hotupdate/app/models"
+)
+
+// 注册撤回和回滚路由，需要发布经理及以上角色
+func setupYankRoutes(r *gin.Engine) {
+	releaseAuth := RoleRequired(models.RoleReleaseManager)
+
+	r.POST("/api/apps/:app_id/versions/:version_id/yank", releaseAuth, YankVersion)
+	r.POST("/api/apps/:app_id/versions/:version_id/unyank", releaseAuth, UnyankVersion)
+	r.POST("/api/apps/:app_id/channels/:channel/rollback", releaseAuth, RollbackChannel)
+}
+
+// YankVersion 撤回版本：检查更新不再提供此版本，已安装的客户端仍可下载。
+// 指定fix_version时，已安装此版本的客户端会被引导更新到该版本
+func YankVersion(c *gin.Context) {
+	appID := c.Param("app_id")
+	versionID := c.Param("version_id")
+	reason := c.PostForm("reason")
+	fixVersion := c.PostForm("fix_version")
+
+	if fixVersion == versionID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "修复版本不能是被撤回的版本本身"})
+		return
+	}
+
+	var yanked models.Version
+	err := models.UpdateVersions(MetadataStore, appID, func(versionList *models.VersionList) error {
+		version, exists := models.GetVersion(versionList, versionID)
+		if !exists {
+			return newRequestError(http.StatusNotFound, "版本不存在")
+		}
+		if version.Yanked != nil {
+			return newRequestError(http.StatusConflict, "版本已撤回")
+		}
+		if fixVersion != "" {
+			fix, exists := models.GetVersion(versionList, fixVersion)
+			if !exists {
+				return newRequestError(http.StatusNotFound, "修复版本不存在")
+			}
+			if fix.Yanked != nil {
+				return newRequestError(http.StatusBadRequest, "修复版本已撤回")
+			}
+		}
+
+		version.Yanked = &models.Yank{
+			Reason:     reason,
+			FixVersion: fixVersion,
+			YankedBy:   c.GetString("username"),
+			YankedAt:   time.Now(),
+		}
+		models.SetVersion(versionList, version)
+		models.RefreshLatestVersion(versionList)
+		yanked = version
+		return nil
+	})
+	if err != nil {
+		respondError(c, err, "无法保存版本列表")
+		return
+	}
+
+	slog.InfoContext(c.Request.Context(), "已撤回版本", "app", appID, "version", versionID, "fix_version", fixVersion, "reason", reason, "operator", c.GetString("username"))
+	c.JSON(http.StatusOK, gin.H{"message": "版本已撤回", "version": yanked})
+}
+
+// UnyankVersion 取消撤回（包括渠道回滚产生的撤回），版本重新出现在检查更新中
+func UnyankVersion(c *gin.Context) {
+	appID := c.Param("app_id")
+	versionID := c.Param("version_id")
+
+	var restored models.Version
+	err := models.UpdateVersions(MetadataStore, appID, func(versionList *models.VersionList) error {
+		version, exists := models.GetVersion(versionList, versionID)
+		if !exists {
+			return newRequestError(http.StatusNotFound, "版本不存在")
+		}
+		// 同时取消版本级的撤回和渠道回滚产生的撤回
+		channelYanked := models.ClearChannelYanks(versionList, versionID)
+		if version.Yanked == nil && !channelYanked {
+			return newRequestError(http.StatusConflict, "版本没有被撤回")
+		}
+
+		version.Yanked = nil
+		models.SetVersion(versionList, version)
+		models.RefreshLatestVersion(versionList)
+		restored = version
+		return nil
+	})
+	if err != nil {
+		respondError(c, err, "无法保存版本列表")
+		return
+	}
+
+	slog.InfoContext(c.Request.Context(), "已取消撤回版本", "app", appID, "version", versionID, "operator", c.GetString("username"))
+	c.JSON(http.StatusOK, gin.H{"message": "版本已恢复", "version": restored})
+}
+
+// RollbackChannel 将渠道回滚到之前的版本：在该渠道中撤回此版本之后的所有版本，
+// 已安装这些版本的客户端会被引导降级到目标版本。撤回只对该渠道生效，其他渠道中的同一版本不受影响
+func RollbackChannel(c *gin.Context) {
+	appID := c.Param("app_id")
+	channelName := c.Param("channel")
+	targetID := c.PostForm("version_id")
+	reason := c.PostForm("reason")
+
+	if targetID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "版本ID不能为空"})
+		return
+	}
+
+	var yankedIDs []string
+	err := models.UpdateVersions(MetadataStore, appID, func(versionList *models.VersionList) error {
+		var channel *models.Channel
+		for i := range versionList.Channels {
+			if versionList.Channels[i].Name == channelName {
+				channel = &versionList.Channels[i]
+				break
+			}
+		}
+		if channel == nil {
+			return newRequestError(http.StatusNotFound, "渠道不存在")
+		}
+		if !channel.Contains(targetID) {
+			return newRequestError(http.StatusBadRequest, "目标版本不在该渠道中")
+		}
+		if target, _ := models.GetVersion(versionList, targetID); channel.YankOf(target) != nil {
+			return newRequestError(http.StatusBadRequest, "目标版本已撤回")
+		}
+
+		now := time.Now()
+		after := false
+		for _, id := range channel.Versions {
+			if id == targetID {
+				after = true
+				continue
+			}
+			if !after {
+				continue
+			}
+			version, exists := models.GetVersion(versionList, id)
+			if !exists || channel.YankOf(version) != nil {
+				continue
+			}
+			if channel.Yanked == nil {
+				channel.Yanked = map[string]*models.Yank{}
+			}
+			channel.Yanked[id] = &models.Yank{
+				Reason:     reason,
+				FixVersion: targetID,
+				YankedBy:   c.GetString("username"),
+				YankedAt:   now,
+			}
+			yankedIDs = append(yankedIDs, id)
+		}
+		if len(yankedIDs) == 0 {
+			return newRequestError(http.StatusConflict, "目标版本已是该渠道的最新版本")
+		}
+		channel.UpdatedAt = now
+		return nil
+	})
+	if err != nil {
+		respondError(c, err, "无法保存版本列表")
+		return
+	}
+
+	slog.InfoContext(c.Request.Context(), "已回滚渠道", "app", appID, "channel", channelName, "version", targetID,
+		"yanked", yankedIDs, "operator", c.GetString("username"))
+	c.JSON(http.StatusOK, gin.H{"message": "回滚成功", "version": targetID, "yanked": yankedIDs})
+}
@@ -0,0 +1,85 @@
+package metrics
+
+import (
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 检查更新的结果
+const (
+	OutcomeUpToDate  = "up_to_date" // 没有可用更新
+	OutcomeUpdate    = "update"     // 提供了更新
+	OutcomeForced    = "forced"     // 提供了强制更新
+	OutcomeReinstall = "reinstall"  // 无法热更新，需要重新安装完整客户端
+)
+
+// 上传方式
+const (
+	UploadDirect    = "direct"    // 一次请求上传
+	UploadResumable = "resumable" // 分片上传
+)
+
+var (
+	// HTTPRequests 按路由统计的请求数
+	HTTPRequests = NewCounterVec("hotupdate_http_requests_total",
+		"按路由、方法和状态码统计的HTTP请求数", "route", "method", "status")
+
+	// HTTPRequestDuration 按路由统计的请求耗时
+	HTTPRequestDuration = NewHistogramVec("hotupdate_http_request_duration_seconds",
+		"按路由和方法统计的HTTP请求耗时（秒）",
+		[]float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}, "route", "method")
+
+	// CheckUpdates 检查更新的结果
+	CheckUpdates = NewCounterVec("hotupdate_check_update_total",
+		"按应用统计的检查更新结果：up_to_date、update、forced、reinstall", "app", "outcome")
+
+	// UpdateReports 客户端上报的更新结果
+	UpdateReports = NewCounterVec("hotupdate_update_reports_total",
+		"按应用统计的客户端上报更新结果：applied、download_failed、hash_mismatch、mount_failed", "app", "outcome")
+
+	// DownloadBytes 服务器直接提供下载的字节数，重定向到对象存储的下载不计
+	DownloadBytes = NewCounterVec("hotupdate_download_bytes_total",
+		"按应用和版本统计的更新包下载字节数", "app", "version")
+
+	// UploadBytes 发布版本时上传的更新包大小
+	UploadBytes = NewHistogramVec("hotupdate_upload_bytes",
+		"按应用和上传方式统计的更新包大小（字节），包括全部专用更新包",
+		[]float64{1 << 20, 10 << 20, 50 << 20, 100 << 20, 500 << 20, 1 << 30, 2 << 30, 5 << 30, 10 << 30}, "app", "method")
+
+	// UploadDuration 发布版本时上传更新包的耗时
+	UploadDuration = NewHistogramVec("hotupdate_upload_duration_seconds",
+		"按应用和上传方式统计的上传耗时（秒），分片上传从创建会话开始计算",
+		[]float64{1, 5, 10, 30, 60, 120, 300, 600, 1800, 3600, 7200}, "app", "method")
+)
+
+// Instrument 记录每个请求的路由、状态码和耗时，同时输出访问日志。
+// 路由使用注册时的路径模板（如/api/apps/:app_id/check），没有匹配的路由统一记为unmatched，避免标签数量无限增长
+func Instrument() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		startTime := time.Now()
+
+		c.Next()
+
+		latency := time.Since(startTime)
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		method := c.Request.Method
+		statusCode := c.Writer.Status()
+
+		HTTPRequests.Inc(route, method, strconv.Itoa(statusCode))
+		HTTPRequestDuration.Observe(latency.Seconds(), route, method)
+
+		slog.InfoContext(c.Request.Context(), "HTTP请求",
+			"status", statusCode,
+			"latency_ms", latency.Milliseconds(),
+			"ip", c.ClientIP(),
+			"method", method,
+			"uri", c.Request.RequestURI,
+		)
+	}
+}
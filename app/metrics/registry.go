@@ -0,0 +1,288 @@
+package metrics
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 按Prometheus文本格式（0.0.4）输出指标，只实现本服务用到的计数器、直方图和按需计算的仪表盘
+
+// collector 可以输出自身样本的指标
+type collector interface {
+	write(w *bufio.Writer)
+}
+
+var (
+	registryMu sync.Mutex
+	registry   []collector
+)
+
+// 注册指标，输出时按注册顺序
+func register(c collector) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry = append(registry, c)
+}
+
+// 一组标签值对应的序列
+type series struct {
+	labelValues []string
+	value       float64
+
+	// 直方图专用：各桶的计数（不累计）和观测值总和
+	buckets []uint64
+	sum     float64
+}
+
+// 指标的公共部分：名称、说明、标签名和按标签值索引的序列
+type metric struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+// 获取标签值对应的序列，不存在时创建。标签值数量必须与标签名一致
+func (m *metric) get(labelValues []string, newSeries func() *series) *series {
+	if len(labelValues) != len(m.labels) {
+		panic(fmt.Sprintf("指标 %s 需要 %d 个标签值，实际 %d 个", m.name, len(m.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	s, ok := m.series[key]
+	if !ok {
+		s = newSeries()
+		s.labelValues = append([]string(nil), labelValues...)
+		m.series[key] = s
+	}
+	return s
+}
+
+// 按标签值排序后的序列，保证每次输出的顺序一致
+func (m *metric) sorted() []*series {
+	list := make([]*series, 0, len(m.series))
+	for _, s := range m.series {
+		list = append(list, s)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return strings.Join(list[i].labelValues, "\xff") < strings.Join(list[j].labelValues, "\xff")
+	})
+	return list
+}
+
+// 输出HELP和TYPE行
+func (m *metric) writeHeader(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", m.name, escapeHelp(m.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", m.name, m.kind)
+}
+
+// CounterVec 按标签区分的计数器
+type CounterVec struct {
+	metric
+}
+
+// NewCounterVec 创建并注册计数器
+func NewCounterVec(name string, help string, labels ...string) *CounterVec {
+	c := &CounterVec{metric{name: name, help: help, kind: "counter", labels: labels, series: map[string]*series{}}}
+	register(c)
+	return c
+}
+
+// Inc 计数加1
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add 计数增加value，value不能为负数
+func (c *CounterVec) Add(value float64, labelValues ...string) {
+	if value < 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.get(labelValues, func() *series { return &series{} }).value += value
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writeHeader(w)
+	for _, s := range c.sorted() {
+		writeSample(w, c.name, c.labels, s.labelValues, "", "", s.value)
+	}
+}
+
+// HistogramVec 按标签区分的直方图
+type HistogramVec struct {
+	metric
+	bounds []float64
+}
+
+// NewHistogramVec 创建并注册直方图，buckets为各桶的上界（升序，不包括+Inf）
+func NewHistogramVec(name string, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{
+		metric: metric{name: name, help: help, kind: "histogram", labels: labels, series: map[string]*series{}},
+		bounds: buckets,
+	}
+	register(h)
+	return h
+}
+
+// Observe 记录一次观测值
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.get(labelValues, func() *series { return &series{buckets: make([]uint64, len(h.bounds)+1)} })
+	s.buckets[sort.SearchFloat64s(h.bounds, value)]++
+	s.sum += value
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.writeHeader(w)
+	for _, s := range h.sorted() {
+		var count uint64
+		for i, bound := range h.bounds {
+			count += s.buckets[i]
+			writeSample(w, h.name+"_bucket", h.labels, s.labelValues, "le", formatFloat(bound), float64(count))
+		}
+		count += s.buckets[len(h.bounds)]
+		writeSample(w, h.name+"_bucket", h.labels, s.labelValues, "le", "+Inf", float64(count))
+		writeSample(w, h.name+"_sum", h.labels, s.labelValues, "", "", s.sum)
+		writeSample(w, h.name+"_count", h.labels, s.labelValues, "", "", float64(count))
+	}
+}
+
+// Sample 按需计算的仪表盘的一个样本
+type Sample struct {
+	LabelValues []string
+	Value       float64
+}
+
+// GaugeFunc 在每次抓取时调用fn计算样本的仪表盘
+type GaugeFunc struct {
+	metric
+	fn func() []Sample
+}
+
+// NewGaugeFunc 创建并注册按需计算的仪表盘
+func NewGaugeFunc(name string, help string, labels []string, fn func() []Sample) *GaugeFunc {
+	g := &GaugeFunc{metric: metric{name: name, help: help, kind: "gauge", labels: labels}, fn: fn}
+	register(g)
+	return g
+}
+
+func (g *GaugeFunc) write(w *bufio.Writer) {
+	g.writeHeader(w)
+	samples := g.fn()
+	sort.Slice(samples, func(i, j int) bool {
+		return strings.Join(samples[i].LabelValues, "\xff") < strings.Join(samples[j].LabelValues, "\xff")
+	})
+	for _, sample := range samples {
+		if len(sample.LabelValues) == len(g.labels) {
+			writeSample(w, g.name, g.labels, sample.LabelValues, "", "", sample.Value)
+		}
+	}
+}
+
+// WriteText 以Prometheus文本格式输出全部已注册的指标
+func WriteText(out io.Writer) error {
+	registryMu.Lock()
+	collectors := append([]collector(nil), registry...)
+	registryMu.Unlock()
+
+	w := bufio.NewWriter(out)
+	for _, c := range collectors {
+		c.write(w)
+	}
+	return w.Flush()
+}
+
+// Handler 提供指标抓取的HTTP处理器
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		WriteText(w)
+	})
+}
+
+// Config 指标接口的访问控制
+type Config struct {
+	Token  string `json:"token"`  // 抓取令牌，设置后请求必须携带请求头 Authorization: Bearer <token>
+	Listen string `json:"listen"` // 单独的监听地址（如127.0.0.1:9100），设置后指标只在该地址提供，不再由主端口提供
+}
+
+// TokenHandler 要求请求携带抓取令牌的指标处理器，token为空时不检查
+func TokenHandler(token string) http.Handler {
+	handler := Handler()
+	if token == "" {
+		return handler
+	}
+	expected := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+			http.Error(w, "抓取令牌无效", http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// 输出一行样本，extraName不为空时追加一个标签（直方图的le）
+func writeSample(w *bufio.Writer, name string, labels []string, labelValues []string, extraName string, extraValue string, value float64) {
+	w.WriteString(name)
+	if len(labels) > 0 || extraName != "" {
+		w.WriteByte('{')
+		for i, label := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", label, escapeLabel(labelValues[i]))
+		}
+		if extraName != "" {
+			if len(labels) > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", extraName, extraValue)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(value))
+	w.WriteByte('\n')
+}
+
+// 格式化样本值
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// 转义标签值中的反斜杠、双引号和换行
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+// 转义说明中的反斜杠和换行
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
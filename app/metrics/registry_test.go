@@ -0,0 +1,113 @@
+package metrics
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "更新testdata中的期望输出")
+
+// 按顺序输出指标，与期望输出文件比较
+func checkGolden(t *testing.T, name string, collectors ...collector) {
+	t.Helper()
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	for _, c := range collectors {
+		c.write(w)
+	}
+	w.Flush()
+
+	golden := filepath.Join("testdata", name+".golden")
+	if *update {
+		if err := os.WriteFile(golden, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("输出与 %s 不一致：\n%s", golden, buf.String())
+	}
+}
+
+func TestCounterText(t *testing.T) {
+	counter := NewCounterVec("test_requests_total", "请求数", "route", "status")
+	counter.Inc("/b", "200")
+	counter.Add(2.5, "/a", "500")
+	counter.Inc("/a", "200")
+	counter.Add(-1, "/a", "200") // 计数器不能减少
+
+	plain := NewCounterVec("test_events_total", "没有标签的计数器")
+	plain.Add(1e21)
+
+	checkGolden(t, "counter", counter, plain)
+}
+
+func TestHistogramText(t *testing.T) {
+	histogram := NewHistogramVec("test_duration_seconds", "耗时（秒）", []float64{0.1, 1, 10}, "method")
+	for _, value := range []float64{0.05, 0.1, 0.5, 3, 100} {
+		histogram.Observe(value, "GET")
+	}
+	histogram.Observe(0.2, "POST")
+
+	checkGolden(t, "histogram", histogram)
+}
+
+func TestGaugeFuncText(t *testing.T) {
+	gauge := NewGaugeFunc("test_storage_bytes", "占用空间", []string{"app"}, func() []Sample {
+		return []Sample{
+			{LabelValues: []string{"b"}, Value: 2048},
+			{LabelValues: []string{"a"}, Value: math.Inf(1)},
+			{LabelValues: []string{"c"}, Value: math.NaN()},
+			{LabelValues: []string{"x", "y"}, Value: 1}, // 标签数量不一致的样本被跳过
+		}
+	})
+
+	checkGolden(t, "gauge", gauge)
+}
+
+// 标签值中的反斜杠、双引号和换行，以及说明中的反斜杠和换行需要转义
+func TestEscapingText(t *testing.T) {
+	counter := NewCounterVec("test_escaped_total", "说明中的\\反斜杠\n和换行，\"双引号\"不转义", "value")
+	counter.Inc(`C:\path`)
+	counter.Inc(`say "hi"`)
+	counter.Inc("line1\nline2")
+	counter.Inc("中文")
+
+	checkGolden(t, "escaping", counter)
+}
+
+func TestTokenHandler(t *testing.T) {
+	handler := TokenHandler("secret")
+	for header, want := range map[string]int{
+		"":                http.StatusUnauthorized,
+		"Bearer wrong":    http.StatusUnauthorized,
+		"Bearer secret":   http.StatusOK,
+		"Bearer secret ":  http.StatusUnauthorized,
+		"Basic secret":    http.StatusUnauthorized,
+		"bearer secret":   http.StatusUnauthorized,
+		"Bearer secretxx": http.StatusUnauthorized,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != want {
+			t.Errorf("Authorization %q 返回 %d，期望 %d", header, w.Code, want)
+		}
+		if w.Code == http.StatusOK && w.Header().Get("Content-Type") != "text/plain; version=0.0.4; charset=utf-8" {
+			t.Errorf("Content-Type = %q", w.Header().Get("Content-Type"))
+		}
+	}
+}
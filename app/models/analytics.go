@@ -0,0 +1,189 @@
+package models
+
+import (
+	"errors"
+	"path"
+	"time"
+
+	"hotupdate/app/store"
+)
+
+// AnalyticsRetentionDays 统计数据保留的天数
+const AnalyticsRetentionDays = 90
+
+// AnalyticsDateLayout 统计数据按天保存，日期使用服务器本地时区
+const AnalyticsDateLayout = "2006-01-02"
+
+// 每天分别估算设备数的客户端版本和平台数量上限，超出的计入AnalyticsOther，
+// 避免客户端上报大量不同的版本号或平台时统计无限增长
+const (
+	maxAnalyticsVersions  = 100
+	maxAnalyticsPlatforms = 20
+)
+
+// AnalyticsOther 超出数量上限的客户端版本和平台
+const AnalyticsOther = "other"
+
+// DailyAnalytics 表示应用一天的检查更新和下载统计。设备数用固定大小的HyperLogLog估算，
+// 文档大小与设备数无关
+type DailyAnalytics struct {
+	Date      string                  `json:"date"`             // 日期（YYYY-MM-DD）
+	Checks    int                     `json:"checks"`           // 检查更新次数，包括没有提供设备ID的请求
+	Active    DeviceSketch            `json:"active,omitempty"` // 当天检查更新的设备
+	Versions  map[string]DeviceSketch `json:"versions"`         // 客户端版本 -> 当天上报该版本的设备，当天更新过的设备计入两个版本
+	Platforms map[string]DeviceSketch `json:"platforms"`        // 平台 -> 当天检查更新的设备
+	Offered   map[string]int          `json:"offered"`          // 版本 -> 检查更新时提供该版本的次数
+	Downloads map[string]int          `json:"downloads"`        // 版本 -> 服务器完整发送该版本更新包或差分包的次数
+	Redirects map[string]int          `json:"redirects"`        // 版本 -> 重定向到对象存储下载该版本的次数，无法得知客户端是否下载完成
+	Applied   map[string]int          `json:"applied"`          // 版本 -> 当天上报已应用该版本的设备数（每台设备每个版本只计一次）
+}
+
+// NewDailyAnalytics 创建空的每日统计
+func NewDailyAnalytics(date string) *DailyAnalytics {
+	return &DailyAnalytics{
+		Date:      date,
+		Versions:  map[string]DeviceSketch{},
+		Platforms: map[string]DeviceSketch{},
+		Offered:   map[string]int{},
+		Downloads: map[string]int{},
+		Redirects: map[string]int{},
+		Applied:   map[string]int{},
+	}
+}
+
+// AddDevice 记录一台设备检查更新时上报的版本和平台（可以为空）
+func (a *DailyAnalytics) AddDevice(hash string, version string, platform string) {
+	a.Active.Add(hash)
+	addSketch(a.Versions, version, maxAnalyticsVersions, func(sketch *DeviceSketch) { sketch.Add(hash) })
+	if platform != "" {
+		addSketch(a.Platforms, platform, maxAnalyticsPlatforms, func(sketch *DeviceSketch) { sketch.Add(hash) })
+	}
+}
+
+// Merge 将另一份同一天的统计（较晚的数据）累加到当前统计
+func (a *DailyAnalytics) Merge(other *DailyAnalytics) {
+	a.Checks += other.Checks
+	a.Active.Merge(other.Active)
+	for version, sketch := range other.Versions {
+		addSketch(a.Versions, version, maxAnalyticsVersions, func(s *DeviceSketch) { s.Merge(sketch) })
+	}
+	for platform, sketch := range other.Platforms {
+		addSketch(a.Platforms, platform, maxAnalyticsPlatforms, func(s *DeviceSketch) { s.Merge(sketch) })
+	}
+	for version, count := range other.Offered {
+		a.Offered[version] += count
+	}
+	for version, count := range other.Downloads {
+		a.Downloads[version] += count
+	}
+	for version, count := range other.Redirects {
+		a.Redirects[version] += count
+	}
+	for version, count := range other.Applied {
+		a.Applied[version] += count
+	}
+}
+
+// 修改sketches中name对应的估算，数量达到上限时新的名称计入AnalyticsOther
+func addSketch(sketches map[string]DeviceSketch, name string, limit int, fn func(sketch *DeviceSketch)) {
+	if _, exists := sketches[name]; !exists && len(sketches) >= limit {
+		name = AnalyticsOther
+	}
+	sketch := sketches[name]
+	fn(&sketch)
+	sketches[name] = sketch
+}
+
+// AnalyticsKey 获取应用某一天的统计在元数据存储中的键
+func AnalyticsKey(appID string, date string) string {
+	return path.Join("apps", appID, "analytics", date)
+}
+
+// LoadDailyAnalytics 加载应用某一天的统计，没有数据时返回空统计
+func LoadDailyAnalytics(s store.Store, appID string, date string) (*DailyAnalytics, error) {
+	analytics := NewDailyAnalytics(date)
+	if err := s.Load(AnalyticsKey(appID, date), analytics); err != nil && !errors.Is(err, store.ErrNotFound) {
+		return nil, err
+	}
+	analytics.init()
+	return analytics, nil
+}
+
+// UpdateDailyAnalytics 在存储锁内修改应用某一天的统计，fn返回错误时不保存
+func UpdateDailyAnalytics(s store.Store, appID string, date string, fn func(analytics *DailyAnalytics) error) error {
+	analytics := NewDailyAnalytics(date)
+	return s.Update(AnalyticsKey(appID, date), analytics, func() error {
+		analytics.init()
+		return fn(analytics)
+	})
+}
+
+// AnalyticsPrefix 获取应用全部统计在元数据存储中的键前缀
+func AnalyticsPrefix(appID string) string {
+	return path.Join("apps", appID, "analytics") + "/"
+}
+
+// ListAnalyticsDates 列出应用已保存统计的全部日期，按时间先后排列
+func ListAnalyticsDates(s store.Store, appID string) ([]string, error) {
+	keys, err := s.List(AnalyticsPrefix(appID))
+	if err != nil {
+		return nil, err
+	}
+	dates := make([]string, 0, len(keys))
+	for _, key := range keys {
+		date := path.Base(key)
+		if _, err := time.Parse(AnalyticsDateLayout, date); err == nil {
+			dates = append(dates, date)
+		}
+	}
+	return dates, nil
+}
+
+// DeleteAnalytics 删除应用日期早于before（为空时删除全部）的统计
+func DeleteAnalytics(s store.Store, appID string, before string) error {
+	dates, err := ListAnalyticsDates(s, appID)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, date := range dates {
+		if before != "" && date >= before {
+			continue
+		}
+		if err := s.Delete(AnalyticsKey(appID, date)); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// AnalyticsDates 获取截至now（含当天）的最近days天的日期，按时间先后排列
+func AnalyticsDates(now time.Time, days int) []string {
+	dates := make([]string, 0, days)
+	for i := days - 1; i >= 0; i-- {
+		dates = append(dates, now.AddDate(0, 0, -i).Format(AnalyticsDateLayout))
+	}
+	return dates
+}
+
+// 补全从空文档中读出的nil映射
+func (a *DailyAnalytics) init() {
+	if a.Versions == nil {
+		a.Versions = map[string]DeviceSketch{}
+	}
+	if a.Platforms == nil {
+		a.Platforms = map[string]DeviceSketch{}
+	}
+	if a.Offered == nil {
+		a.Offered = map[string]int{}
+	}
+	if a.Downloads == nil {
+		a.Downloads = map[string]int{}
+	}
+	if a.Redirects == nil {
+		a.Redirects = map[string]int{}
+	}
+	if a.Applied == nil {
+		a.Applied = map[string]int{}
+	}
+}
@@ -1,20 +1,45 @@
 package models
 
 import (
-	"encoding/json"
-	"io/ioutil"
-	"os"
+	"errors"
+	"path"
 	"path/filepath"
 	"time"
+
+	"hotupdate/app/store"
 )
 
 // App 表示一个应用项目
 type App struct {
-	ID          string    `json:"id"`          // 应用ID，唯一标识
-	Name        string    `json:"name"`        // 应用名称
-	Description string    `json:"description"` // 应用描述
-	CreatedAt   time.Time `json:"createdAt"`   // 创建时间
-	UpdatedAt   time.Time `json:"updatedAt"`   // 更新时间
+	ID            string    `json:"id"`                      // 应用ID，唯一标识
+	Name          string    `json:"name"`                    // 应用名称
+	Description   string    `json:"description"`             // 应用描述
+	UpdateMode    string    `json:"updateMode"`              // 检查更新的默认模式：progressive（默认）或 full
+	VersionScheme string    `json:"versionScheme,omitempty"` // 版本号方案：semver（默认）、changelist 或 date
+	StoreURL      string    `json:"storeUrl,omitempty"`      // 应用商店地址，客户端无法热更新时引导重新安装
+	CreatedAt     time.Time `json:"createdAt"`               // 创建时间
+	UpdatedAt     time.Time `json:"updatedAt"`               // 更新时间
+
+	PackageRules *PackageRules `json:"packageRules,omitempty"` // 更新包的自定义校验规则
+	AutoHalt     *AutoHalt     `json:"autoHalt,omitempty"`     // 灰度自动终止规则，为空时不自动终止
+	ArchivedAt   *time.Time    `json:"archivedAt,omitempty"`   // 归档时间，为空时应用正常使用
+	ArchivedBy   string        `json:"archivedBy,omitempty"`   // 归档操作者
+}
+
+// Archived 判断应用是否已归档。归档的应用不再提供更新，文件保留到彻底删除
+func (a App) Archived() bool {
+	return a.ArchivedAt != nil
+}
+
+// 检查更新模式
+const (
+	UpdateModeProgressive = "progressive" // 渐进式：每次只返回下一个版本
+	UpdateModeFull        = "full"        // 完整路径：一次返回到最新版本的全部版本
+)
+
+// ValidUpdateMode 判断更新模式是否有效
+func ValidUpdateMode(mode string) bool {
+	return mode == UpdateModeProgressive || mode == UpdateModeFull
 }
 
 // AppList 表示应用列表
@@ -22,36 +47,30 @@ type AppList struct {
 	Apps []App `json:"apps"` // 应用列表
 }
 
-// LoadApps 从文件加载应用信息
-func LoadApps(filePath string) (*AppList, error) {
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
-		return &AppList{
-			Apps: []App{},
-		}, nil
-	}
-
-	data, err := ioutil.ReadFile(filePath)
-	if err != nil {
-		return nil, err
-	}
+// AppsKey 应用列表在元数据存储中的键
+const AppsKey = "apps"
 
+// LoadApps 加载应用列表
+func LoadApps(s store.Store) (*AppList, error) {
 	var appList AppList
-	err = json.Unmarshal(data, &appList)
-	if err != nil {
+	if err := s.Load(AppsKey, &appList); err != nil && !errors.Is(err, store.ErrNotFound) {
 		return nil, err
 	}
-
+	if appList.Apps == nil {
+		appList.Apps = []App{}
+	}
 	return &appList, nil
 }
 
-// SaveApps 保存应用信息到文件
-func SaveApps(appList *AppList, filePath string) error {
-	data, err := json.MarshalIndent(appList, "", "  ")
-	if err != nil {
-		return err
-	}
-
-	return ioutil.WriteFile(filePath, data, 0644)
+// UpdateApps 在存储锁内修改应用列表，fn返回错误时不保存
+func UpdateApps(s store.Store, fn func(appList *AppList) error) error {
+	var appList AppList
+	return s.Update(AppsKey, &appList, func() error {
+		if appList.Apps == nil {
+			appList.Apps = []App{}
+		}
+		return fn(&appList)
+	})
 }
 
 // AddApp 添加新应用
@@ -92,31 +111,7 @@ func DeleteApp(appList *AppList, appID string) *AppList {
 	return appList
 }
 
-// GetAppUploadDir 获取应用上传目录
-func GetAppUploadDir(baseUploadDir string, appID string) string {
-	return filepath.Join(baseUploadDir, "apps", appID)
-}
-
-// GetAppVersionsJsonPath 获取应用版本信息文件路径
-func GetAppVersionsJsonPath(baseUploadDir string, appID string) string {
-	appDir := GetAppUploadDir(baseUploadDir, appID)
-	return filepath.Join(appDir, "versions.json")
-}
-
-// CreateAppDirectories 创建应用所需的目录
-func CreateAppDirectories(baseUploadDir string, appID string) error {
-	appDir := GetAppUploadDir(baseUploadDir, appID)
-	versionsDir := filepath.Join(appDir, "versions")
-
-	// 创建应用目录
-	if err := os.MkdirAll(appDir, 0755); err != nil {
-		return err
-	}
-
-	// 创建版本目录
-	if err := os.MkdirAll(versionsDir, 0755); err != nil {
-		return err
-	}
-
-	return nil
+// GetArtifactKey 获取应用文件在存储中的对象键，relPath为相对于应用目录的路径（如Version.FilePath）
+func GetArtifactKey(appID string, relPath string) string {
+	return path.Join("apps", appID, filepath.ToSlash(relPath))
 }
@@ -0,0 +1,79 @@
+package models
+
+import "path/filepath"
+
+// Artifact 表示版本中某个平台或纹理格式专用的更新包，
+// 如windows、android-astc、android-etc2、ios。没有专用更新包的平台使用版本的默认更新包
+type Artifact struct {
+	Key      string `json:"key"`      // 平台或纹理格式，默认更新包为空
+	FilePath string `json:"filePath"` // 文件路径
+	FileSize int64  `json:"fileSize"` // 文件大小
+	SHA256   string `json:"sha256"`   // 文件SHA-256（十六进制）
+	MD5      string `json:"md5"`      // 文件MD5（十六进制）
+	CRC32    string `json:"crc32"`    // 文件CRC32（十六进制）
+}
+
+// ValidArtifactKey 判断更新包的键是否有效，规则与平台名称相同
+func ValidArtifactKey(key string) bool {
+	return ValidPlatform(key)
+}
+
+// ArtifactFileName 获取更新包的文件名，默认更新包为update.zip
+func ArtifactFileName(key string) string {
+	if key == "" {
+		return "update.zip"
+	}
+	return "update-" + key + ".zip"
+}
+
+// ETag 获取更新包的强ETag，旧版本没有记录哈希时返回空字符串
+func (a Artifact) ETag() string {
+	if a.SHA256 == "" {
+		return ""
+	}
+	return `"` + a.SHA256 + `"`
+}
+
+// DefaultArtifact 获取版本的默认更新包
+func (v Version) DefaultArtifact() Artifact {
+	return Artifact{
+		FilePath: v.FilePath,
+		FileSize: v.FileSize,
+		SHA256:   v.SHA256,
+		MD5:      v.MD5,
+		CRC32:    v.CRC32,
+	}
+}
+
+// ArtifactByFile 根据下载文件名查找更新包，包括默认更新包
+func (v Version) ArtifactByFile(filename string) (Artifact, bool) {
+	if filepath.Base(v.FilePath) == filename {
+		return v.DefaultArtifact(), true
+	}
+	for _, artifact := range v.Artifacts {
+		if filepath.Base(artifact.FilePath) == filename {
+			return artifact, true
+		}
+	}
+	return Artifact{}, false
+}
+
+// ResolveArtifact 获取适用于客户端的更新包：依次查找“平台-纹理格式”和平台的专用更新包，
+// 都没有时使用默认更新包
+func (v Version) ResolveArtifact(platform string, flavor string) Artifact {
+	var keys []string
+	if platform != "" && flavor != "" {
+		keys = append(keys, platform+"-"+flavor)
+	}
+	if platform != "" {
+		keys = append(keys, platform)
+	}
+	for _, key := range keys {
+		for _, artifact := range v.Artifacts {
+			if artifact.Key == key {
+				return artifact
+			}
+		}
+	}
+	return v.DefaultArtifact()
+}
@@ -0,0 +1,146 @@
+package models
+
+import (
+	"time"
+)
+
+// DefaultChannel 默认发布渠道，不能删除。未指定渠道的上传和检查更新都使用此渠道
+const DefaultChannel = "stable"
+
+// Channel 表示应用的一个发布渠道（如stable、beta、internal），每个渠道有各自的版本序列
+type Channel struct {
+	Name        string           `json:"name"`             // 渠道名称，应用内唯一
+	Description string           `json:"description"`      // 渠道描述
+	Versions    []string         `json:"versions"`         // 渠道中的版本ID，与版本列表的顺序一致
+	Yanked      map[string]*Yank `json:"yanked,omitempty"` // 只在此渠道中撤回的版本（渠道回滚），版本ID -> 撤回信息
+	CreatedAt   time.Time        `json:"createdAt"`        // 创建时间
+	UpdatedAt   time.Time        `json:"updatedAt"`        // 最后一次加入版本的时间
+}
+
+// Latest 获取渠道中的最新版本ID，渠道为空时返回空字符串
+func (ch Channel) Latest() string {
+	if len(ch.Versions) == 0 {
+		return ""
+	}
+	return ch.Versions[len(ch.Versions)-1]
+}
+
+// Contains 判断版本是否在渠道中
+func (ch Channel) Contains(versionID string) bool {
+	for _, id := range ch.Versions {
+		if id == versionID {
+			return true
+		}
+	}
+	return false
+}
+
+// YankOf 获取版本在渠道中的撤回信息：版本级的撤回对所有渠道生效，其次是此渠道回滚产生的撤回
+func (ch Channel) YankOf(version Version) *Yank {
+	if version.Yanked != nil {
+		return version.Yanked
+	}
+	return ch.Yanked[version.ID]
+}
+
+// 渠道功能之前的版本列表没有渠道，全部版本视为默认渠道
+func ensureChannels(versionList *VersionList) {
+	if len(versionList.Channels) > 0 {
+		return
+	}
+	channel := Channel{Name: DefaultChannel, Versions: []string{}, CreatedAt: time.Now(), UpdatedAt: time.Now()}
+	for _, version := range versionList.Versions {
+		channel.Versions = append(channel.Versions, version.ID)
+	}
+	versionList.Channels = []Channel{channel}
+}
+
+// GetChannel 根据名称获取渠道
+func GetChannel(versionList *VersionList, name string) (Channel, bool) {
+	for _, channel := range versionList.Channels {
+		if channel.Name == name {
+			return channel, true
+		}
+	}
+	return Channel{}, false
+}
+
+// AddChannel 添加渠道，同名渠道已存在时返回false
+func AddChannel(versionList *VersionList, channel Channel) bool {
+	if _, exists := GetChannel(versionList, channel.Name); exists {
+		return false
+	}
+	versionList.Channels = append(versionList.Channels, channel)
+	return true
+}
+
+// DeleteChannel 删除渠道，渠道中的版本本身不受影响
+func DeleteChannel(versionList *VersionList, name string) *VersionList {
+	for i, channel := range versionList.Channels {
+		if channel.Name == name {
+			versionList.Channels = append(versionList.Channels[:i], versionList.Channels[i+1:]...)
+			break
+		}
+	}
+	return versionList
+}
+
+// ClearChannelYanks 取消版本在所有渠道中的回滚撤回，返回是否有渠道撤回了该版本
+func ClearChannelYanks(versionList *VersionList, versionID string) bool {
+	cleared := false
+	for i := range versionList.Channels {
+		channel := &versionList.Channels[i]
+		if _, ok := channel.Yanked[versionID]; ok {
+			delete(channel.Yanked, versionID)
+			cleared = true
+		}
+	}
+	return cleared
+}
+
+// AddToChannel 将版本加入渠道，按版本列表中的顺序插入。渠道不存在时返回false
+func AddToChannel(versionList *VersionList, name string, versionID string) bool {
+	order := make(map[string]int, len(versionList.Versions))
+	for i, version := range versionList.Versions {
+		order[version.ID] = i
+	}
+
+	for i := range versionList.Channels {
+		channel := &versionList.Channels[i]
+		if channel.Name != name {
+			continue
+		}
+		if channel.Contains(versionID) {
+			return true
+		}
+
+		pos := len(channel.Versions)
+		for pos > 0 && order[channel.Versions[pos-1]] > order[versionID] {
+			pos--
+		}
+		channel.Versions = append(channel.Versions, "")
+		copy(channel.Versions[pos+1:], channel.Versions[pos:])
+		channel.Versions[pos] = versionID
+		channel.UpdatedAt = time.Now()
+		return true
+	}
+	return false
+}
+
+// ChannelVersions 按顺序获取渠道中的版本，渠道不存在时返回false。
+// 返回的版本带有在此渠道中的撤回信息，渠道回滚撤回的版本也视为已撤回
+func ChannelVersions(versionList *VersionList, name string) ([]Version, bool) {
+	channel, exists := GetChannel(versionList, name)
+	if !exists {
+		return nil, false
+	}
+
+	versions := make([]Version, 0, len(channel.Versions))
+	for _, id := range channel.Versions {
+		if version, exists := GetVersion(versionList, id); exists {
+			version.Yanked = channel.YankOf(version)
+			versions = append(versions, version)
+		}
+	}
+	return versions, true
+}
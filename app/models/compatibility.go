@@ -0,0 +1,56 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// Compatibility 表示版本的兼容性要求。热更新包只能应用到特定的基础客户端上，
+// 不满足要求的客户端需要从应用商店重新安装完整客户端
+type Compatibility struct {
+	MinClientVersion string   `json:"minClientVersion,omitempty"` // 可以更新到此版本的最低客户端版本
+	BaseBuild        string   `json:"baseBuild,omitempty"`        // 要求的引擎或基础包构建ID
+	Platforms        []string `json:"platforms,omitempty"`        // 适用的平台，为空时适用于所有平台
+}
+
+// 平台名称：小写字母开头，只包含小写字母、数字、下划线和横线
+var platformPattern = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,31}$`)
+
+// ValidPlatform 判断平台名称是否有效
+func ValidPlatform(platform string) bool {
+	return platformPattern.MatchString(platform)
+}
+
+// Empty 判断是否没有任何兼容性要求
+func (c *Compatibility) Empty() bool {
+	return c == nil || c.MinClientVersion == "" && c.BaseBuild == "" && len(c.Platforms) == 0
+}
+
+// SupportsPlatform 判断版本是否适用于该平台。c为nil或没有限制平台时适用于所有平台；
+// 没有提供平台的客户端只能更新到不限平台的版本
+func (c *Compatibility) SupportsPlatform(platform string) bool {
+	if c == nil || len(c.Platforms) == 0 {
+		return true
+	}
+	for _, p := range c.Platforms {
+		if p == platform {
+			return true
+		}
+	}
+	return false
+}
+
+// Check 判断从fromVersion能否以热更新的方式更新到该版本，不兼容时返回原因。
+// 没有提供基础包构建ID的客户端无法确认兼容，不能更新到要求基础包的版本
+func (c *Compatibility) Check(scheme VersionScheme, fromVersion string, baseBuild string) error {
+	if c == nil {
+		return nil
+	}
+	if c.MinClientVersion != "" && scheme.Compare(fromVersion, c.MinClientVersion) < 0 {
+		return fmt.Errorf("需要客户端版本 %s 及以上", c.MinClientVersion)
+	}
+	if c.BaseBuild != "" && baseBuild != c.BaseBuild {
+		return fmt.Errorf("需要基础包 %s", c.BaseBuild)
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package models
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"hotupdate/app/store"
+)
+
+// FileEntry 表示更新包中的一个文件
+type FileEntry struct {
+	Path           string `json:"path"`           // 包内路径，使用"/"分隔
+	Size           int64  `json:"size"`           // 解压后大小
+	CompressedSize int64  `json:"compressedSize"` // 压缩后大小
+	CRC32          string `json:"crc32"`          // CRC32（十六进制）
+	SHA256         string `json:"sha256"`         // 解压后内容的SHA-256
+}
+
+// FileManifest 表示一个版本更新包的文件清单
+type FileManifest struct {
+	Version   string      `json:"version"`            // 版本ID
+	Artifact  string      `json:"artifact,omitempty"` // 平台或纹理格式专用更新包的键，默认更新包为空
+	FileCount int         `json:"fileCount"`          // 文件数量
+	TotalSize int64       `json:"totalSize"`          // 解压后总大小
+	Files     []FileEntry `json:"files"`              // 按路径排序的文件列表
+	CreatedAt time.Time   `json:"createdAt"`          // 生成时间
+}
+
+// ManifestDiff 表示两个版本文件清单的差异
+type ManifestDiff struct {
+	FromVersion string      `json:"fromVersion"`
+	ToVersion   string      `json:"toVersion"`
+	Added       []FileEntry `json:"added"`       // 新增的文件
+	Changed     []FileEntry `json:"changed"`     // 内容变化的文件（新版本的信息）
+	Removed     []string    `json:"removed"`     // 删除的文件路径
+	Unchanged   int         `json:"unchanged"`   // 未变化的文件数量
+	ChangedSize int64       `json:"changedSize"` // 新增和变化文件的解压后总大小
+}
+
+// FileManifestKey 获取版本文件清单在元数据存储中的键，artifact为专用更新包的键，默认更新包为空
+func FileManifestKey(appID string, versionID string, artifact string) string {
+	if artifact == "" {
+		return "apps/" + appID + "/manifests/" + versionID
+	}
+	return "apps/" + appID + "/artifact-manifests/" + versionID + "/" + artifact
+}
+
+// LoadFileManifest 加载版本更新包的文件清单，不存在时返回store.ErrNotFound
+func LoadFileManifest(s store.Store, appID string, versionID string, artifact string) (*FileManifest, error) {
+	var manifest FileManifest
+	if err := s.Load(FileManifestKey(appID, versionID, artifact), &manifest); err != nil {
+		return nil, err
+	}
+	return &manifest, nil
+}
+
+// SaveFileManifest 保存版本更新包的文件清单
+func SaveFileManifest(s store.Store, appID string, manifest *FileManifest) error {
+	var existing FileManifest
+	return s.Update(FileManifestKey(appID, manifest.Version, manifest.Artifact), &existing, func() error {
+		existing = *manifest
+		return nil
+	})
+}
+
+// DeleteFileManifests 删除版本全部更新包（包括专用更新包）的文件清单
+func DeleteFileManifests(s store.Store, appID string, version Version) error {
+	if err := s.Delete(FileManifestKey(appID, version.ID, "")); err != nil {
+		return err
+	}
+	for _, artifact := range version.Artifacts {
+		if err := s.Delete(FileManifestKey(appID, version.ID, artifact.Key)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BuildFileManifest 读取ZIP更新包，为其中每个文件计算大小、CRC32和SHA-256。
+// 解压时校验CRC和声明的大小，文件内容损坏时返回PackageError
+func BuildFileManifest(r io.ReaderAt, size int64, versionID string) (*FileManifest, error) {
+	reader, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, packageError(PackageCorruptZip, "", "ZIP文件已损坏: %v", err)
+	}
+
+	manifest := &FileManifest{
+		Version:   versionID,
+		Files:     []FileEntry{},
+		CreatedAt: time.Now(),
+	}
+
+	for _, file := range reader.File {
+		// 跳过目录
+		if strings.HasSuffix(file.Name, "/") {
+			continue
+		}
+
+		rc, err := file.Open()
+		if err != nil {
+			return nil, packageError(PackageCorruptZip, file.Name, "无法读取文件: %v", err)
+		}
+		hash := sha256.New()
+		n, err := io.Copy(hash, rc)
+		rc.Close()
+		if err != nil {
+			return nil, packageError(PackageCorruptZip, file.Name, "无法读取文件: %v", err)
+		}
+
+		manifest.Files = append(manifest.Files, FileEntry{
+			Path:           file.Name,
+			Size:           n,
+			CompressedSize: int64(file.CompressedSize64),
+			CRC32:          fmt.Sprintf("%08x", file.CRC32),
+			SHA256:         hex.EncodeToString(hash.Sum(nil)),
+		})
+		manifest.TotalSize += n
+	}
+
+	sort.Slice(manifest.Files, func(i, j int) bool {
+		return manifest.Files[i].Path < manifest.Files[j].Path
+	})
+	manifest.FileCount = len(manifest.Files)
+	return manifest, nil
+}
+
+// DiffFileManifests 比较两个版本的文件清单
+func DiffFileManifests(from *FileManifest, to *FileManifest) ManifestDiff {
+	diff := ManifestDiff{
+		FromVersion: from.Version,
+		ToVersion:   to.Version,
+		Added:       []FileEntry{},
+		Changed:     []FileEntry{},
+		Removed:     []string{},
+	}
+
+	oldFiles := make(map[string]FileEntry, len(from.Files))
+	for _, file := range from.Files {
+		oldFiles[file.Path] = file
+	}
+
+	for _, file := range to.Files {
+		old, exists := oldFiles[file.Path]
+		delete(oldFiles, file.Path)
+
+		switch {
+		case !exists:
+			diff.Added = append(diff.Added, file)
+			diff.ChangedSize += file.Size
+		case old.SHA256 != file.SHA256:
+			diff.Changed = append(diff.Changed, file)
+			diff.ChangedSize += file.Size
+		default:
+			diff.Unchanged++
+		}
+	}
+
+	for _, file := range from.Files {
+		if _, removed := oldFiles[file.Path]; removed {
+			diff.Removed = append(diff.Removed, file.Path)
+		}
+	}
+
+	return diff
+}
+
+// FindFile 在清单中查找文件
+func (m *FileManifest) FindFile(filePath string) (FileEntry, bool) {
+	i := sort.Search(len(m.Files), func(i int) bool { return m.Files[i].Path >= filePath })
+	if i < len(m.Files) && m.Files[i].Path == filePath {
+		return m.Files[i], true
+	}
+	return FileEntry{}, false
+}
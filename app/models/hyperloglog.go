@@ -0,0 +1,79 @@
+package models
+
+import (
+	"encoding/hex"
+	"math"
+	"math/bits"
+)
+
+// 设备数估算使用的HyperLogLog精度：2^12个寄存器，每个估算值占4KB，标准误差约1.6%
+const (
+	sketchPrecision = 12
+	sketchRegisters = 1 << sketchPrecision
+)
+
+// DeviceSketch 估算不同设备数的HyperLogLog。无论设备多少大小固定，合并取寄存器的最大值，
+// 重复合并同一份数据不会重复计数。零值表示没有设备
+type DeviceSketch []byte
+
+// Add 记录一台设备，hash为hashDeviceID计算的16位十六进制哈希
+func (s *DeviceSketch) Add(hash string) {
+	var sum [8]byte
+	if n, err := hex.Decode(sum[:], []byte(hash)); err != nil || n != len(sum) {
+		return
+	}
+	x := uint64(0)
+	for _, b := range sum {
+		x = x<<8 | uint64(b)
+	}
+
+	s.init()
+	index := x >> (64 - sketchPrecision)
+	// 剩余位中第一个1的位置，末尾补1保证不会超过寄存器能表示的范围
+	rank := uint8(bits.LeadingZeros64(x<<sketchPrecision|1<<(sketchPrecision-1)) + 1)
+	if rank > (*s)[index] {
+		(*s)[index] = rank
+	}
+}
+
+// Merge 合并另一份估算
+func (s *DeviceSketch) Merge(other DeviceSketch) {
+	if len(other) != sketchRegisters {
+		return
+	}
+	s.init()
+	for i, rank := range other {
+		if rank > (*s)[i] {
+			(*s)[i] = rank
+		}
+	}
+}
+
+// Count 估算设备数
+func (s DeviceSketch) Count() int {
+	if len(s) != sketchRegisters {
+		return 0
+	}
+	sum := 0.0
+	zeros := 0
+	for _, rank := range s {
+		sum += math.Ldexp(1, -int(rank))
+		if rank == 0 {
+			zeros++
+		}
+	}
+	m := float64(sketchRegisters)
+	estimate := 0.7213 / (1 + 1.079/m) * m * m / sum
+	// 设备较少时改用线性计数，误差更小
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return int(math.Round(estimate))
+}
+
+// 分配寄存器
+func (s *DeviceSketch) init() {
+	if len(*s) != sketchRegisters {
+		*s = make(DeviceSketch, sketchRegisters)
+	}
+}
@@ -0,0 +1,59 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"testing"
+)
+
+func testDeviceHash(i int) string {
+	sum := sha256.Sum256([]byte(fmt.Sprint("device-", i)))
+	return hex.EncodeToString(sum[:8])
+}
+
+func TestDeviceSketchCount(t *testing.T) {
+	for _, n := range []int{0, 1, 10, 1000, 50000, 300000} {
+		var sketch DeviceSketch
+		for i := 0; i < n; i++ {
+			sketch.Add(testDeviceHash(i))
+			// 同一设备多次检查更新只计一次
+			sketch.Add(testDeviceHash(i))
+		}
+		got := sketch.Count()
+		if math.Abs(float64(got-n)) > math.Max(2, 0.05*float64(n)) {
+			t.Errorf("%d台设备估算为 %d", n, got)
+		}
+	}
+}
+
+func TestDeviceSketchMerge(t *testing.T) {
+	var a, b DeviceSketch
+	for i := 0; i < 3000; i++ {
+		a.Add(testDeviceHash(i))
+	}
+	for i := 2000; i < 5000; i++ {
+		b.Add(testDeviceHash(i))
+	}
+	a.Merge(b)
+	// 重复合并不会重复计数
+	a.Merge(b)
+	if got := a.Count(); math.Abs(float64(got-5000)) > 250 {
+		t.Errorf("合并后估算为 %d，期望约5000", got)
+	}
+}
+
+// 客户端上报的版本号过多时，超出上限的计入other
+func TestDailyAnalyticsVersionLimit(t *testing.T) {
+	analytics := NewDailyAnalytics("2024-05-01")
+	for i := 0; i < maxAnalyticsVersions+50; i++ {
+		analytics.AddDevice(testDeviceHash(i), fmt.Sprint("1.0.", i), "")
+	}
+	if len(analytics.Versions) != maxAnalyticsVersions+1 {
+		t.Errorf("记录了 %d 个版本，期望 %d", len(analytics.Versions), maxAnalyticsVersions+1)
+	}
+	if got := analytics.Versions[AnalyticsOther].Count(); got != 50 {
+		t.Errorf("other的设备 %d，期望50", got)
+	}
+}
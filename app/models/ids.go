@@ -0,0 +1,50 @@
+package models
+
+import (
+	"errors"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var (
+	// 应用ID：字母或数字开头，只包含字母、数字、横线和下划线
+	appIDPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]{0,63}$`)
+	// 版本ID：字母或数字开头，只包含字母、数字、点、加号、横线和下划线，各版本号方案在此基础上再做校验
+	versionIDPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9.+_-]{0,127}$`)
+	// 下载文件名：规则与版本ID相同，如update.zip、update-windows.zip
+	fileNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9.+_-]{0,254}$`)
+)
+
+// 不能用作应用ID的保留字
+var reservedAppIDs = []string{"apps", "api", "admin", "static"}
+
+// ValidateAppID 检查应用ID是否有效
+func ValidateAppID(id string) error {
+	if id == "" {
+		return errors.New("应用ID不能为空")
+	}
+	if !appIDPattern.MatchString(id) {
+		return errors.New("应用ID只能包含字母、数字、横线和下划线，以字母或数字开头，最长64个字符")
+	}
+	if slices.Contains(reservedAppIDs, strings.ToLower(id)) {
+		return errors.New("应用ID不能使用保留字")
+	}
+	return nil
+}
+
+// ValidateVersionID 检查版本ID能否安全地用作路径的一部分，与应用的版本号方案无关
+func ValidateVersionID(id string) error {
+	if id == "" {
+		return errors.New("版本ID不能为空")
+	}
+	if !versionIDPattern.MatchString(id) || strings.Contains(id, "..") {
+		return errors.New("版本ID只能包含字母、数字、点、加号、横线和下划线，以字母或数字开头，最长128个字符")
+	}
+	return nil
+}
+
+// ValidFileName 判断下载文件名是否有效，文件名不能包含路径分隔符或..
+func ValidFileName(name string) bool {
+	return fileNamePattern.MatchString(name) && !strings.Contains(name, "..")
+}
@@ -0,0 +1,59 @@
+package models
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// 检查通过校验的名称作为单个路径段使用时不会离开所在目录
+func checkPathSegment(t *testing.T, name string) {
+	t.Helper()
+	if name == "" || name == "." || name == ".." || strings.Contains(name, "..") {
+		t.Fatalf("%q 通过了校验", name)
+	}
+	if strings.ContainsAny(name, "/\\\x00:") || strings.HasPrefix(name, ".") {
+		t.Fatalf("%q 通过了校验，但包含路径分隔符或特殊字符", name)
+	}
+
+	root := filepath.FromSlash("/srv/uploads/apps/game/versions")
+	joined := filepath.Join(root, name, "update.zip")
+	if filepath.Dir(filepath.Dir(joined)) != root || filepath.Base(filepath.Dir(joined)) != name {
+		t.Fatalf("%q 拼接为 %q，离开了 %q", name, joined, root)
+	}
+}
+
+var pathSegmentSeeds = []string{
+	"1.0.0", "1.0.0-beta.1+build.5", "CL12345", "2024.01.15", "update.zip", "update-windows.zip",
+	"", ".", "..", "../x", "1.0/../..", `..\x`, "a..b", ".hidden", "-flag", "a\x00b", "a b", "1.0.0/", "%2e%2e", strings.Repeat("9", 300),
+}
+
+func FuzzValidateVersionID(f *testing.F) {
+	for _, seed := range pathSegmentSeeds {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, id string) {
+		if ValidateVersionID(id) != nil {
+			return
+		}
+		if len(id) > 128 {
+			t.Fatalf("长度为 %d 的版本ID通过了校验", len(id))
+		}
+		checkPathSegment(t, id)
+	})
+}
+
+func FuzzValidFileName(f *testing.F) {
+	for _, seed := range pathSegmentSeeds {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, name string) {
+		if !ValidFileName(name) {
+			return
+		}
+		if len(name) > 255 {
+			t.Fatalf("长度为 %d 的文件名通过了校验", len(name))
+		}
+		checkPathSegment(t, name)
+	})
+}
@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// UpdateManifest 检查更新时签名下发的更新清单，客户端验证签名后只信任清单中的字段
+type UpdateManifest struct {
+	AppID    string    `json:"appId"`              // 应用ID
+	Channel  string    `json:"channel"`            // 发布渠道，客户端应拒绝与自己所在渠道不一致的清单
+	Version  string    `json:"version"`            // 要更新到的版本ID
+	Artifact string    `json:"artifact,omitempty"` // 平台或纹理格式专用更新包的键，使用默认更新包时为空
+	SHA256   string    `json:"sha256"`             // 更新包SHA-256
+	Size     int64     `json:"size"`               // 更新包大小
+	URL      string    `json:"url"`                // 更新包下载地址
+	Force    bool      `json:"force"`              // 是否强制更新
+	Rollback bool      `json:"rollback,omitempty"` // 是否为回滚：当前版本已撤回，需要降级到此版本。客户端只在清单签名有效时允许降级
+	IssuedAt time.Time `json:"issuedAt"`           // 签发时间，客户端可据此拒绝过旧的清单
+
+	// 兼容性要求，客户端应拒绝应用与自己的基础包不一致的更新包
+	MinClientVersion string `json:"minClientVersion,omitempty"` // 最低客户端版本
+	BaseBuild        string `json:"baseBuild,omitempty"`        // 要求的基础包构建ID
+
+	// 可用的差分包，客户端可以下载差分包并应用到当前版本的更新包上，代替下载完整包
+	DeltaFrom   string `json:"deltaFrom,omitempty"`   // 差分包的基础版本
+	DeltaSHA256 string `json:"deltaSha256,omitempty"` // 差分包SHA-256
+	DeltaSize   int64  `json:"deltaSize,omitempty"`   // 差分包大小
+	DeltaURL    string `json:"deltaUrl,omitempty"`    // 差分包下载地址
+}
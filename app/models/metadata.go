@@ -0,0 +1,64 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"path"
+
+	"hotupdate/app/store"
+)
+
+// ImportMetadata 将src中的全部元数据复制到dst，用于从JSON文件迁移到bolt数据库。
+// 包括应用、令牌、用户、分片上传会话，以及各应用的版本列表、文件清单、采用情况统计和上报设备
+func ImportMetadata(dst store.Store, src store.Store) error {
+	keys := []string{AppsKey, TokensKey, UsersKey, UploadsKey}
+
+	appList, err := LoadApps(src)
+	if err != nil {
+		return err
+	}
+	for _, app := range appList.Apps {
+		keys = append(keys, VersionsKey(app.ID))
+		// JSON存储中应用目录下还有版本文件，只列出保存元数据的子目录
+		for _, prefix := range appMetadataPrefixes(app.ID) {
+			appKeys, err := src.List(prefix)
+			if err != nil {
+				return err
+			}
+			keys = append(keys, appKeys...)
+		}
+	}
+
+	for _, key := range keys {
+		if err := copyDocument(dst, src, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 应用的文件清单、采用情况统计和上报设备在元数据存储中的键前缀
+func appMetadataPrefixes(appID string) []string {
+	return []string{
+		path.Join("apps", appID, "manifests") + "/",
+		path.Join("apps", appID, "artifact-manifests") + "/",
+		AnalyticsPrefix(appID),
+		path.Join("apps", appID, "report-devices") + "/",
+	}
+}
+
+// 原样复制一个文档，src中不存在时跳过
+func copyDocument(dst store.Store, src store.Store, key string) error {
+	var document json.RawMessage
+	if err := src.Load(key, &document); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+	var target json.RawMessage
+	return dst.Update(key, &target, func() error {
+		target = document
+		return nil
+	})
+}
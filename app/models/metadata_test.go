@@ -0,0 +1,72 @@
+package models
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"hotupdate/app/store"
+)
+
+func TestImportMetadata(t *testing.T) {
+	dir := t.TempDir()
+	src := store.NewJSONStore(dir)
+	dst, err := store.OpenBoltStore(filepath.Join(dir, "metadata.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+
+	now := time.Now()
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	must(UpdateApps(src, func(appList *AppList) error {
+		AddApp(appList, App{ID: "game", Name: "game", CreatedAt: now, UpdatedAt: now})
+		return nil
+	}))
+	must(UpdateVersions(src, "game", func(versionList *VersionList) error {
+		AddVersion(versionList, Version{ID: "1.0.0", CreatedAt: now})
+		return nil
+	}))
+	must(UpdateTokens(src, func(tokenList *TokenList) error { return nil }))
+	must(UpdateUsers(src, func(userList *UserList) error { return nil }))
+	must(UpdateUploadSessions(src, func(sessionList *UploadSessionList) error { return nil }))
+	must(SaveFileManifest(src, "game", &FileManifest{Version: "1.0.0"}))
+	must(SaveFileManifest(src, "game", &FileManifest{Version: "1.0.0", Artifact: "android"}))
+	must(UpdateDailyAnalytics(src, "game", "2024-05-01", func(analytics *DailyAnalytics) error {
+		analytics.Checks = 7
+		return nil
+	}))
+	hash := testDeviceHash(1)
+	must(UpdateReportDevices(src, "game", "1.0.0", DeviceBucket(hash), func(devices *ReportDevices) error {
+		devices.Outcomes[hash] = ReportApplied
+		return nil
+	}))
+	// 应用目录下的版本文件不是元数据，即使是JSON文件也不应导入
+	artifact := filepath.Join(dir, "apps", "game", "versions", "1.0.0", "config.json")
+	must(os.MkdirAll(filepath.Dir(artifact), 0755))
+	must(os.WriteFile(artifact, []byte("{}"), 0644))
+
+	must(ImportMetadata(dst, src))
+
+	want, err := src.List("")
+	must(err)
+	want = slices.DeleteFunc(want, func(key string) bool { return key == "apps/game/versions/1.0.0/config" })
+	got, err := dst.List("")
+	must(err)
+	if !slices.Equal(got, want) {
+		t.Errorf("导入的键 %v，期望 %v", got, want)
+	}
+
+	analytics, err := LoadDailyAnalytics(dst, "game", "2024-05-01")
+	must(err)
+	if analytics.Checks != 7 {
+		t.Errorf("导入的统计 checks = %d，期望7", analytics.Checks)
+	}
+}
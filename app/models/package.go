@@ -0,0 +1,233 @@
+package models
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// 更新包校验失败的错误码，返回给上传方用于区分失败原因
+const (
+	PackageInvalidZip      = "invalid_zip"      // 不是ZIP文件（文件头不正确）
+	PackageCorruptZip      = "corrupt_zip"      // 中央目录损坏或文件数据校验失败
+	PackageEmpty           = "empty_package"    // 更新包中没有文件
+	PackageTooManyEntries  = "too_many_entries" // 文件数量超过限制
+	PackageUnsafePath      = "unsafe_path"      // 文件路径可能写到解压目录之外（zip-slip）
+	PackageDuplicateEntry  = "duplicate_entry"  // 同一路径出现多次
+	PackageSymlink         = "symlink_entry"    // 包含符号链接
+	PackageZipBomb         = "zip_bomb"         // 压缩比或解压后总大小超过限制
+	PackageMissingRequired = "missing_required" // 缺少应用规则要求的文件
+	PackageFileNotAllowed  = "file_not_allowed" // 文件不在应用规则允许的路径中
+	PackageInvalidRule     = "invalid_rule"     // 应用规则本身无效
+)
+
+// 更新包的全局限制
+const (
+	MaxPackageEntries       = 100000   // 最多文件数
+	MaxCompressionRatio     = 200      // 单个文件的最大压缩比
+	compressionRatioMinSize = 1 << 20  // 解压后小于此大小的文件不检查压缩比，避免误判小的文本文件
+	MaxUncompressedSize     = 64 << 30 // 解压后的最大总大小
+)
+
+// PackageError 更新包校验失败，Code为错误码，Entry为出错的包内路径（可能为空）
+type PackageError struct {
+	Code    string
+	Message string
+	Entry   string
+}
+
+func (e *PackageError) Error() string {
+	if e.Entry != "" {
+		return e.Message + ": " + e.Entry
+	}
+	return e.Message
+}
+
+func packageError(code string, entry string, format string, args ...any) error {
+	return &PackageError{Code: code, Message: fmt.Sprintf(format, args...), Entry: entry}
+}
+
+// PackageRules 应用自定义的更新包规则。路径模式按"/"分段匹配，每段使用path.Match语法（*不跨越"/"），
+// 单独的**段匹配零个或多个目录，如 Content/Paks/*.pak 只匹配该目录下的文件，Content/**/*.pak 匹配任意层级的子目录
+type PackageRules struct {
+	Required []string `json:"required,omitempty"` // 每个模式都必须匹配至少一个文件
+	Allowed  []string `json:"allowed,omitempty"`  // 设置时，所有文件都必须匹配其中一个模式
+}
+
+// Empty 判断是否没有设置任何规则
+func (r *PackageRules) Empty() bool {
+	return r == nil || len(r.Required) == 0 && len(r.Allowed) == 0
+}
+
+// Validate 检查规则中的路径模式是否有效
+func (r *PackageRules) Validate() error {
+	if r == nil {
+		return nil
+	}
+	for _, pattern := range append(append([]string{}, r.Required...), r.Allowed...) {
+		if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+			return packageError(PackageInvalidRule, "", "路径模式无效: %s", pattern)
+		}
+	}
+	return nil
+}
+
+// ValidatePackage 在保存之前校验上传的更新包：ZIP文件头和中央目录、文件数量、
+// 路径安全（zip-slip）、符号链接、压缩比和解压后大小，以及应用的自定义规则。
+// 只读取ZIP的目录信息；文件内容的CRC在生成文件清单时校验
+func ValidatePackage(r io.ReaderAt, size int64, rules *PackageRules) (*zip.Reader, error) {
+	magic := make([]byte, 4)
+	if _, err := r.ReadAt(magic, 0); err != nil || !bytes.Equal(magic, []byte("PK\x03\x04")) {
+		if bytes.Equal(magic, []byte("PK\x05\x06")) {
+			return nil, packageError(PackageEmpty, "", "更新包中没有文件")
+		}
+		return nil, packageError(PackageInvalidZip, "", "不是有效的ZIP文件")
+	}
+
+	reader, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, packageError(PackageCorruptZip, "", "ZIP文件已损坏: %v", err)
+	}
+	if len(reader.File) > MaxPackageEntries {
+		return nil, packageError(PackageTooManyEntries, "", "文件数量 %d 超过限制 %d", len(reader.File), MaxPackageEntries)
+	}
+
+	var files []string
+	var total uint64
+	seen := make(map[string]bool, len(reader.File))
+	for _, file := range reader.File {
+		if err := checkEntryPath(file.Name); err != nil {
+			return nil, err
+		}
+		if seen[file.Name] {
+			return nil, packageError(PackageDuplicateEntry, file.Name, "文件路径重复")
+		}
+		seen[file.Name] = true
+
+		if file.Mode()&fs.ModeSymlink != 0 {
+			return nil, packageError(PackageSymlink, file.Name, "不允许包含符号链接")
+		}
+
+		// 本地文件头必须能在文件范围内找到，否则中央目录与数据不一致
+		offset, err := file.DataOffset()
+		if err != nil || offset < 0 || offset+int64(file.CompressedSize64) > size {
+			return nil, packageError(PackageCorruptZip, file.Name, "ZIP文件已损坏")
+		}
+
+		if strings.HasSuffix(file.Name, "/") {
+			continue
+		}
+		if file.UncompressedSize64 >= compressionRatioMinSize &&
+			file.UncompressedSize64 > file.CompressedSize64*MaxCompressionRatio {
+			return nil, packageError(PackageZipBomb, file.Name, "压缩比超过限制 %d:1", MaxCompressionRatio)
+		}
+		total += file.UncompressedSize64
+		if total > MaxUncompressedSize {
+			return nil, packageError(PackageZipBomb, "", "解压后总大小超过限制 %d 字节", int64(MaxUncompressedSize))
+		}
+		files = append(files, file.Name)
+	}
+	if len(files) == 0 {
+		return nil, packageError(PackageEmpty, "", "更新包中没有文件")
+	}
+
+	if err := checkPackageRules(files, rules); err != nil {
+		return nil, err
+	}
+	return reader, nil
+}
+
+// 检查包内路径不会写到解压目录之外：不能是绝对路径、不能包含..、反斜杠或空字符
+func checkEntryPath(name string) error {
+	unsafe := name == "" ||
+		strings.HasPrefix(name, "/") ||
+		strings.ContainsAny(name, "\\\x00") ||
+		len(name) >= 2 && name[1] == ':'
+	if !unsafe {
+		for _, part := range strings.Split(strings.TrimSuffix(name, "/"), "/") {
+			if part == ".." {
+				unsafe = true
+				break
+			}
+		}
+	}
+	if unsafe {
+		return packageError(PackageUnsafePath, name, "文件路径不安全")
+	}
+	return nil
+}
+
+// 按应用规则检查包内文件
+func checkPackageRules(files []string, rules *PackageRules) error {
+	if rules.Empty() {
+		return nil
+	}
+
+	if len(rules.Allowed) > 0 {
+		for _, file := range files {
+			if !matchAny(rules.Allowed, file) {
+				return packageError(PackageFileNotAllowed, file, "文件不在允许的路径中")
+			}
+		}
+	}
+
+	for _, pattern := range rules.Required {
+		found := false
+		for _, file := range files {
+			if matchAny([]string{pattern}, file) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return packageError(PackageMissingRequired, "", "缺少匹配 %s 的文件", pattern)
+		}
+	}
+	return nil
+}
+
+// 判断路径是否匹配任意一个模式
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if MatchPackagePath(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchPackagePath 判断包内路径是否匹配规则中的路径模式，**段匹配零个或多个目录，其他段使用path.Match
+func MatchPackagePath(pattern string, name string) bool {
+	patterns := strings.Split(pattern, "/")
+	names := strings.Split(name, "/")
+
+	// matched[j]表示已处理的模式段能否匹配names[j:]之前的全部路径段
+	matched := make([]bool, len(names)+1)
+	matched[0] = true
+	for _, segment := range patterns {
+		next := make([]bool, len(names)+1)
+		for j := range matched {
+			if !matched[j] {
+				continue
+			}
+			if segment == "**" {
+				// 匹配之后任意数量的路径段
+				for k := j; k <= len(names); k++ {
+					next[k] = true
+				}
+				break
+			}
+			if j < len(names) {
+				if ok, _ := path.Match(segment, names[j]); ok {
+					next[j+1] = true
+				}
+			}
+		}
+		matched = next
+	}
+	return matched[len(names)]
+}
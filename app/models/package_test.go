@@ -0,0 +1,79 @@
+package models
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestMatchPackagePath(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{"Content/Paks/*.pak", "Content/Paks/a.pak", true},
+		{"Content/Paks/*.pak", "Content/Paks/sub/a.pak", false},
+		{"Content/**/*.pak", "Content/a.pak", true},
+		{"Content/**/*.pak", "Content/Paks/sub/a.pak", true},
+		{"Content/**/*.pak", "Other/a.pak", false},
+		{"Content/**/*.pak", "Content/Paks/a.txt", false},
+		{"Content/**", "Content/Paks/sub/a.pak", true},
+		{"Content/**", "Config.ini", false},
+		{"**/*.ini", "Config.ini", true},
+		{"**/*.ini", "Saved/Config/Game.ini", true},
+		{"**", "a/b/c", true},
+		{"Content/**/Paks/**/*.pak", "Content/x/Paks/y/z/a.pak", true},
+		{"Content/**/Paks/**/*.pak", "Content/x/y/a.pak", false},
+		{"Content/*", "Content/Paks/a.pak", false},
+		{"*.pak", "a.pak", true},
+	}
+	for _, tt := range tests {
+		if got := MatchPackagePath(tt.pattern, tt.name); got != tt.want {
+			t.Errorf("MatchPackagePath(%q, %q) = %v，期望 %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPackageRulesNestedPaths(t *testing.T) {
+	rules := &PackageRules{Required: []string{"Content/**/*.pak"}, Allowed: []string{"Content/**"}}
+	if err := rules.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkPackageRules([]string{"Content/Paks/pakchunk1/a.pak"}, rules); err != nil {
+		t.Errorf("嵌套目录中的文件应满足规则: %v", err)
+	}
+	if err := checkPackageRules([]string{"Content/a.pak", "Binaries/game.exe"}, rules); err == nil {
+		t.Error("Content之外的文件不应被允许")
+	}
+	if err := (&PackageRules{Allowed: []string{"Content/[a"}}).Validate(); err == nil {
+		t.Error("无效的路径模式应被拒绝")
+	}
+}
+
+// 目录信息完整但文件内容损坏的更新包通过ValidatePackage，在生成文件清单时报告出错的文件
+func TestBuildFileManifestReportsCorruptEntry(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: "Content/Paks/a.pak", Method: zip.Store})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("original pak contents"))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	i := bytes.Index(data, []byte("original"))
+	copy(data[i:], "modified")
+
+	if _, err := ValidatePackage(bytes.NewReader(data), int64(len(data)), nil); err != nil {
+		t.Fatalf("ValidatePackage只检查目录信息，不应失败: %v", err)
+	}
+	_, err = BuildFileManifest(bytes.NewReader(data), int64(len(data)), "1.0.0")
+	var pkgErr *PackageError
+	if !errors.As(err, &pkgErr) || pkgErr.Code != PackageCorruptZip || pkgErr.Entry != "Content/Paks/a.pak" {
+		t.Errorf("文件内容损坏时返回 %v，期望corrupt_zip错误", err)
+	}
+}
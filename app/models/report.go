@@ -0,0 +1,168 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"time"
+
+	"hotupdate/app/store"
+)
+
+// 客户端上报的更新结果
+const (
+	ReportApplied        = "applied"         // 更新已应用
+	ReportDownloadFailed = "download_failed" // 下载失败
+	ReportHashMismatch   = "hash_mismatch"   // 下载的文件哈希与服务器提供的不一致
+	ReportMountFailed    = "mount_failed"    // 挂载或应用更新包失败
+)
+
+// ReportOutcomes 全部更新结果，按展示顺序排列
+var ReportOutcomes = []string{ReportApplied, ReportDownloadFailed, ReportHashMismatch, ReportMountFailed}
+
+// ValidReportOutcome 判断更新结果是否有效
+func ValidReportOutcome(outcome string) bool {
+	return slices.Contains(ReportOutcomes, outcome)
+}
+
+// ReportStats 表示客户端上报的某个版本的更新结果统计，从版本发布开始累计
+type ReportStats struct {
+	Outcomes        map[string]int `json:"outcomes"`                  // 更新结果 -> 次数
+	DownloadMillis  int64          `json:"downloadMillis"`            // 上报的下载总耗时（毫秒）
+	Downloads       int            `json:"downloads"`                 // 上报了下载耗时的次数
+	ApplyMillis     int64          `json:"applyMillis"`               // 上报的应用总耗时（毫秒）
+	Applies         int            `json:"applies"`                   // 上报了应用耗时的次数
+	LastError       string         `json:"lastError,omitempty"`       // 最近一次失败的错误信息
+	LastFromVersion string         `json:"lastFromVersion,omitempty"` // 最近一次失败时客户端所在的版本
+	LastErrorAt     *time.Time     `json:"lastErrorAt,omitempty"`     // 最近一次失败的时间
+}
+
+// Total 获取上报总次数
+func (s *ReportStats) Total() int {
+	total := 0
+	for _, count := range s.Outcomes {
+		total += count
+	}
+	return total
+}
+
+// Failures 获取失败次数
+func (s *ReportStats) Failures() int {
+	return s.Total() - s.Outcomes[ReportApplied]
+}
+
+// FailureRate 获取失败率（0-1），没有上报时为0
+func (s *ReportStats) FailureRate() float64 {
+	total := s.Total()
+	if total == 0 {
+		return 0
+	}
+	return float64(s.Failures()) / float64(total)
+}
+
+// Merge 将另一份（较晚的）统计累加到当前统计。设备改报其他结果时，另一份统计中原结果的次数为负数
+func (s *ReportStats) Merge(other *ReportStats) {
+	if s.Outcomes == nil {
+		s.Outcomes = map[string]int{}
+	}
+	for outcome, count := range other.Outcomes {
+		s.Outcomes[outcome] += count
+		if s.Outcomes[outcome] == 0 {
+			delete(s.Outcomes, outcome)
+		}
+	}
+	s.DownloadMillis += other.DownloadMillis
+	s.Downloads += other.Downloads
+	s.ApplyMillis += other.ApplyMillis
+	s.Applies += other.Applies
+	if other.LastErrorAt != nil && (s.LastErrorAt == nil || !other.LastErrorAt.Before(*s.LastErrorAt)) {
+		s.LastError = other.LastError
+		s.LastFromVersion = other.LastFromVersion
+		s.LastErrorAt = other.LastErrorAt
+	}
+}
+
+// AutoHalt 表示应用的灰度自动终止规则：灰度中的版本收到至少MinReports台设备的上报，
+// 且失败率达到FailureRate%时自动终止灰度。每台设备每个版本只计最后一次结果
+type AutoHalt struct {
+	FailureRate int `json:"failureRate"` // 失败率阈值（1-100）
+	MinReports  int `json:"minReports"`  // 判断前至少需要的上报设备数
+}
+
+const (
+	// DefaultAutoHaltMinReports 未指定时判断前至少需要的上报设备数，避免少量失败就终止灰度
+	DefaultAutoHaltMinReports = 20
+	// MinAutoHaltReports 允许设置的最少上报设备数。上报接口无需认证，
+	// 设备数过少时少量伪造的失败上报就能终止灰度
+	MinAutoHaltReports = 10
+)
+
+// Exceeded 判断版本的上报是否达到自动终止的条件，达到时返回原因
+func (a *AutoHalt) Exceeded(stats *ReportStats) (string, bool) {
+	if a == nil || stats == nil {
+		return "", false
+	}
+	total := stats.Total()
+	if total < max(a.MinReports, MinAutoHaltReports) {
+		return "", false
+	}
+	rate := stats.FailureRate() * 100
+	if rate < float64(a.FailureRate) {
+		return "", false
+	}
+	return fmt.Sprintf("客户端上报失败率 %.1f%%（%d/%d）达到阈值 %d%%，已自动终止", rate, stats.Failures(), total, a.FailureRate), true
+}
+
+// DeviceBucketCount 设备集合按设备哈希的前两个十六进制字符分桶保存，每次写入只重写有变化的桶
+const DeviceBucketCount = 256
+
+// DeviceBucket 获取设备哈希所在的桶
+func DeviceBucket(hash string) string {
+	if len(hash) < 2 {
+		return "00"
+	}
+	return hash[:2]
+}
+
+// DeviceBuckets 获取全部桶名
+func DeviceBuckets() []string {
+	buckets := make([]string, 0, DeviceBucketCount)
+	for i := 0; i < DeviceBucketCount; i++ {
+		buckets = append(buckets, fmt.Sprintf("%02x", i))
+	}
+	return buckets
+}
+
+// ReportDevices 表示版本的一个设备桶中各设备最后上报的更新结果，
+// 用于保证每台设备对每个版本只计一次结果
+type ReportDevices struct {
+	Outcomes map[string]string `json:"outcomes"` // 设备ID哈希 -> 最后上报的更新结果
+}
+
+// ReportDevicesKey 获取版本一个设备桶的上报设备在元数据存储中的键
+func ReportDevicesKey(appID string, versionID string, bucket string) string {
+	return path.Join("apps", appID, "report-devices", versionID, bucket)
+}
+
+// UpdateReportDevices 修改版本一个设备桶的上报设备
+func UpdateReportDevices(s store.Store, appID string, versionID string, bucket string, fn func(devices *ReportDevices) error) error {
+	var devices ReportDevices
+	return s.Update(ReportDevicesKey(appID, versionID, bucket), &devices, func() error {
+		if devices.Outcomes == nil {
+			devices.Outcomes = map[string]string{}
+		}
+		return fn(&devices)
+	})
+}
+
+// DeleteReportDevices 删除版本全部设备桶的上报设备
+func DeleteReportDevices(s store.Store, appID string, versionID string) error {
+	var errs []error
+	for _, bucket := range DeviceBuckets() {
+		if err := s.Delete(ReportDevicesKey(appID, versionID, bucket)); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
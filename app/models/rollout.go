@@ -0,0 +1,54 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"time"
+)
+
+// 灰度发布状态
+const (
+	RolloutActive = "active" // 进行中：落在比例内的客户端可以更新
+	RolloutPaused = "paused" // 已暂停：暂不向尚未更新的客户端提供，可恢复
+	RolloutHalted = "halted" // 已终止：发现问题后停止发布，只能重新设置比例再次开始
+)
+
+// Rollout 表示版本的灰度发布设置，没有设置时版本对所有客户端可见
+type Rollout struct {
+	Percentage int       `json:"percentage"`       // 可以更新到此版本的客户端比例（1-100）
+	Status     string    `json:"status"`           // 灰度状态
+	Reason     string    `json:"reason,omitempty"` // 暂停或终止的原因
+	UpdatedBy  string    `json:"updatedBy"`        // 最后修改者
+	UpdatedAt  time.Time `json:"updatedAt"`        // 最后修改时间
+}
+
+// ValidRolloutPercentage 判断灰度比例是否有效
+func ValidRolloutPercentage(percentage int) bool {
+	return percentage >= 1 && percentage <= 100
+}
+
+// Includes 判断设备是否可以更新到该版本。r为nil时表示全量发布。
+// 没有提供设备ID的客户端无法分桶，只能更新到全量发布的版本
+func (r *Rollout) Includes(appID string, versionID string, deviceID string) bool {
+	if r == nil {
+		return true
+	}
+	if r.Status != RolloutActive {
+		return false
+	}
+	if r.Percentage >= 100 {
+		return true
+	}
+	if deviceID == "" {
+		return false
+	}
+	return RolloutBucket(appID, versionID, deviceID) < r.Percentage
+}
+
+// RolloutBucket 计算设备在某个版本灰度中的分桶（0-99）。
+// 同一设备在同一版本上的分桶固定，提高比例时已在范围内的设备不会被移出；
+// 分桶包含版本ID，每次灰度选中的设备不同
+func RolloutBucket(appID string, versionID string, deviceID string) int {
+	sum := sha256.Sum256([]byte(appID + "/" + versionID + "/" + deviceID))
+	return int(binary.BigEndian.Uint64(sum[:8]) % 100)
+}
@@ -0,0 +1,153 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"hotupdate/app/store"
+)
+
+// TokenPermission 表示API令牌的权限范围
+type TokenPermission string
+
+const (
+	TokenPermissionRead    TokenPermission = "read"    // 只读：查看应用和版本信息
+	TokenPermissionPublish TokenPermission = "publish" // 仅发布：上传新版本
+	TokenPermissionAdmin   TokenPermission = "admin"   // 管理：包含以上权限，并可管理该应用的令牌
+)
+
+// Valid 判断权限值是否合法
+func (p TokenPermission) Valid() bool {
+	switch p {
+	case TokenPermissionRead, TokenPermissionPublish, TokenPermissionAdmin:
+		return true
+	}
+	return false
+}
+
+// Allows 判断该权限是否满足所需权限
+func (p TokenPermission) Allows(required TokenPermission) bool {
+	return p == TokenPermissionAdmin || p == required
+}
+
+// APIToken 表示一个按应用授权的API令牌
+type APIToken struct {
+	ID         string          `json:"id"`                   // 令牌ID，也是令牌明文的前缀部分
+	AppID      string          `json:"appId"`                // 令牌所属应用
+	Name       string          `json:"name"`                 // 令牌名称，例如CI任务名
+	Permission TokenPermission `json:"permission"`           // 权限范围
+	SecretHash string          `json:"secretHash,omitempty"` // 令牌密钥的SHA-256哈希
+	CreatedAt  time.Time       `json:"createdAt"`            // 创建时间
+	CreatedBy  string          `json:"createdBy"`            // 创建者
+	LastUsedAt *time.Time      `json:"lastUsedAt,omitempty"` // 最后使用时间
+	RevokedAt  *time.Time      `json:"revokedAt,omitempty"`  // 吊销时间
+}
+
+// Revoked 判断令牌是否已被吊销
+func (t APIToken) Revoked() bool {
+	return t.RevokedAt != nil
+}
+
+// Public 返回去掉密钥哈希的令牌信息，用于接口响应
+func (t APIToken) Public() APIToken {
+	t.SecretHash = ""
+	return t
+}
+
+// TokenList 表示令牌列表
+type TokenList struct {
+	Tokens []APIToken `json:"tokens"` // 令牌列表
+}
+
+// HashTokenSecret 计算令牌密钥的哈希值
+func HashTokenSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// TokensKey 令牌列表在元数据存储中的键
+const TokensKey = "tokens"
+
+// LoadTokens 加载令牌列表
+func LoadTokens(s store.Store) (*TokenList, error) {
+	var tokenList TokenList
+	if err := s.Load(TokensKey, &tokenList); err != nil && !errors.Is(err, store.ErrNotFound) {
+		return nil, err
+	}
+	if tokenList.Tokens == nil {
+		tokenList.Tokens = []APIToken{}
+	}
+	return &tokenList, nil
+}
+
+// UpdateTokens 在存储锁内修改令牌列表，fn返回错误时不保存
+func UpdateTokens(s store.Store, fn func(tokenList *TokenList) error) error {
+	var tokenList TokenList
+	return s.Update(TokensKey, &tokenList, func() error {
+		if tokenList.Tokens == nil {
+			tokenList.Tokens = []APIToken{}
+		}
+		return fn(&tokenList)
+	})
+}
+
+// AddToken 添加新令牌
+func AddToken(tokenList *TokenList, token APIToken) *TokenList {
+	tokenList.Tokens = append(tokenList.Tokens, token)
+	return tokenList
+}
+
+// GetToken 根据ID获取令牌
+func GetToken(tokenList *TokenList, tokenID string) (APIToken, bool) {
+	for _, token := range tokenList.Tokens {
+		if token.ID == tokenID {
+			return token, true
+		}
+	}
+	return APIToken{}, false
+}
+
+// ListAppTokens 获取应用的全部令牌（不含密钥哈希）
+func ListAppTokens(tokenList *TokenList, appID string) []APIToken {
+	tokens := []APIToken{}
+	for _, token := range tokenList.Tokens {
+		if token.AppID == appID {
+			tokens = append(tokens, token.Public())
+		}
+	}
+	return tokens
+}
+
+// RevokeToken 吊销令牌
+func RevokeToken(tokenList *TokenList, tokenID string, at time.Time) *TokenList {
+	for i, token := range tokenList.Tokens {
+		if token.ID == tokenID && token.RevokedAt == nil {
+			tokenList.Tokens[i].RevokedAt = &at
+			break
+		}
+	}
+	return tokenList
+}
+
+// RevokeAppTokens 吊销应用的全部令牌
+func RevokeAppTokens(tokenList *TokenList, appID string, at time.Time) *TokenList {
+	for i, token := range tokenList.Tokens {
+		if token.AppID == appID && token.RevokedAt == nil {
+			tokenList.Tokens[i].RevokedAt = &at
+		}
+	}
+	return tokenList
+}
+
+// TouchToken 更新令牌的最后使用时间
+func TouchToken(tokenList *TokenList, tokenID string, at time.Time) *TokenList {
+	for i, token := range tokenList.Tokens {
+		if token.ID == tokenID {
+			tokenList.Tokens[i].LastUsedAt = &at
+			break
+		}
+	}
+	return tokenList
+}
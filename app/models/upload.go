@@ -0,0 +1,110 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"hotupdate/app/store"
+)
+
+// 上传会话的发布状态，接收分片期间为空
+const (
+	UploadFinalizing = "finalizing" // 已提交发布，正在后台校验并保存更新包
+	UploadCompleted  = "completed"  // 已发布为新版本
+	UploadFailed     = "failed"     // 发布失败，原因见Error，可以重新提交发布
+)
+
+// UploadSession 表示一个分片上传会话，上传完成后发布为新版本
+type UploadSession struct {
+	ID            string         `json:"id"`                      // 会话ID
+	AppID         string         `json:"appId"`                   // 所属应用
+	VersionID     string         `json:"versionId"`               // 完成后发布的版本ID
+	Name          string         `json:"name"`                    // 版本名称
+	Description   string         `json:"description"`             // 版本描述
+	ReleaseNotes  string         `json:"releaseNotes,omitempty"`  // 发布说明
+	Force         bool           `json:"force"`                   // 是否强制更新
+	Cumulative    bool           `json:"cumulative"`              // 是否为累积版本
+	Channel       string         `json:"channel"`                 // 发布到的渠道
+	Rollout       int            `json:"rollout"`                 // 灰度比例，0表示全量发布
+	Compatibility *Compatibility `json:"compatibility,omitempty"` // 兼容性要求
+	Size          int64          `json:"size"`                    // 文件总大小
+	Offset        int64          `json:"offset"`                  // 已接收的字节数
+	SHA256        string         `json:"sha256,omitempty"`        // 上传方提供的整个文件的SHA-256，完成时校验
+	CreatedBy     string         `json:"createdBy"`               // 创建者
+	CreatedAt     time.Time      `json:"createdAt"`               // 创建时间
+	UpdatedAt     time.Time      `json:"updatedAt"`               // 最后一次接收分片的时间
+	ExpiresAt     time.Time      `json:"expiresAt"`               // 过期时间，每次接收分片后顺延
+	Status        string         `json:"status,omitempty"`        // 发布状态，接收分片期间为空
+	Error         string         `json:"error,omitempty"`         // 发布失败的原因
+	ErrorCode     string         `json:"errorCode,omitempty"`     // 更新包校验失败时的错误码，与表单上传返回的code相同
+	ErrorEntry    string         `json:"errorEntry,omitempty"`    // 更新包校验失败时出错的包内路径
+}
+
+// Complete 判断文件是否已全部上传
+func (s UploadSession) Complete() bool {
+	return s.Offset == s.Size
+}
+
+// UploadSessionList 表示上传会话列表
+type UploadSessionList struct {
+	Sessions []UploadSession `json:"sessions"` // 会话列表
+}
+
+// UploadsKey 上传会话列表在元数据存储中的键
+const UploadsKey = "uploads"
+
+// LoadUploadSessions 加载上传会话列表
+func LoadUploadSessions(s store.Store) (*UploadSessionList, error) {
+	var sessionList UploadSessionList
+	if err := s.Load(UploadsKey, &sessionList); err != nil && !errors.Is(err, store.ErrNotFound) {
+		return nil, err
+	}
+	if sessionList.Sessions == nil {
+		sessionList.Sessions = []UploadSession{}
+	}
+	return &sessionList, nil
+}
+
+// UpdateUploadSessions 在存储锁内修改上传会话列表，fn返回错误时不保存
+func UpdateUploadSessions(s store.Store, fn func(sessionList *UploadSessionList) error) error {
+	var sessionList UploadSessionList
+	return s.Update(UploadsKey, &sessionList, func() error {
+		if sessionList.Sessions == nil {
+			sessionList.Sessions = []UploadSession{}
+		}
+		return fn(&sessionList)
+	})
+}
+
+// SetUploadSession 添加或替换上传会话
+func SetUploadSession(sessionList *UploadSessionList, session UploadSession) *UploadSessionList {
+	for i, existing := range sessionList.Sessions {
+		if existing.ID == session.ID {
+			sessionList.Sessions[i] = session
+			return sessionList
+		}
+	}
+	sessionList.Sessions = append(sessionList.Sessions, session)
+	return sessionList
+}
+
+// GetUploadSession 根据ID获取上传会话
+func GetUploadSession(sessionList *UploadSessionList, sessionID string) (UploadSession, bool) {
+	for _, session := range sessionList.Sessions {
+		if session.ID == sessionID {
+			return session, true
+		}
+	}
+	return UploadSession{}, false
+}
+
+// DeleteUploadSession 删除上传会话
+func DeleteUploadSession(sessionList *UploadSessionList, sessionID string) *UploadSessionList {
+	for i, session := range sessionList.Sessions {
+		if session.ID == sessionID {
+			sessionList.Sessions = append(sessionList.Sessions[:i], sessionList.Sessions[i+1:]...)
+			break
+		}
+	}
+	return sessionList
+}
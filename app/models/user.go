@@ -0,0 +1,189 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"hotupdate/app/store"
+)
+
+// Role 表示用户在应用上的角色
+type Role string
+
+const (
+	RoleViewer         Role = "viewer"          // 查看者：查看应用和版本信息
+	RoleUploader       Role = "uploader"        // 上传者：上传新版本
+	RoleReleaseManager Role = "release-manager" // 发布经理：上传版本并可设置强制更新
+	RoleOwner          Role = "owner"           // 所有者：全部权限，包括删除应用、管理令牌
+)
+
+// AllApps 表示对所有应用生效的角色授权范围
+const AllApps = "*"
+
+// Level 返回角色的权限等级，数值越大权限越高
+func (r Role) Level() int {
+	switch r {
+	case RoleViewer:
+		return 1
+	case RoleUploader:
+		return 2
+	case RoleReleaseManager:
+		return 3
+	case RoleOwner:
+		return 4
+	}
+	return 0
+}
+
+// Valid 判断角色值是否合法
+func (r Role) Valid() bool {
+	return r.Level() > 0
+}
+
+// AtLeast 判断该角色是否不低于所需角色
+func (r Role) AtLeast(required Role) bool {
+	return r.Valid() && r.Level() >= required.Level()
+}
+
+// Role 返回令牌权限对应的角色
+func (p TokenPermission) Role() Role {
+	switch p {
+	case TokenPermissionRead:
+		return RoleViewer
+	case TokenPermissionPublish:
+		return RoleUploader
+	case TokenPermissionAdmin:
+		return RoleOwner
+	}
+	return ""
+}
+
+// RequiredTokenPermission 返回满足指定角色所需的令牌权限
+func RequiredTokenPermission(required Role) TokenPermission {
+	switch required {
+	case RoleViewer:
+		return TokenPermissionRead
+	case RoleUploader:
+		return TokenPermissionPublish
+	}
+	return TokenPermissionAdmin
+}
+
+// User 表示一个管理用户
+type User struct {
+	Username     string          `json:"username"`               // 用户名，唯一标识
+	PasswordHash string          `json:"passwordHash,omitempty"` // bcrypt密码哈希
+	Roles        map[string]Role `json:"roles"`                  // 按应用授予的角色，键为应用ID或"*"
+	Disabled     bool            `json:"disabled"`               // 是否已禁用
+	// 是否必须先修改密码，使用生成的初始密码登录后除修改密码外不能访问其他接口
+	MustChangePassword bool      `json:"mustChangePassword,omitempty"`
+	CreatedAt          time.Time `json:"createdAt"` // 创建时间
+	UpdatedAt          time.Time `json:"updatedAt"` // 更新时间
+}
+
+// RoleFor 返回用户在指定应用上的有效角色，取应用授权与全局授权中较高者；
+// appID为空时只考虑全局授权
+func (u User) RoleFor(appID string) Role {
+	role := u.Roles[AllApps]
+	if appID != "" {
+		if appRole, ok := u.Roles[appID]; ok && appRole.Level() > role.Level() {
+			role = appRole
+		}
+	}
+	return role
+}
+
+// HasAnyRole 判断用户在指定应用上是否有任意角色
+func (u User) HasAnyRole(appID string) bool {
+	return u.RoleFor(appID).Valid()
+}
+
+// Public 返回去掉密码哈希的用户信息，用于接口响应
+func (u User) Public() User {
+	u.PasswordHash = ""
+	return u
+}
+
+// UserList 表示用户列表
+type UserList struct {
+	Users []User `json:"users"` // 用户列表
+}
+
+// UsersKey 用户列表在元数据存储中的键
+const UsersKey = "users"
+
+// LoadUsers 加载用户列表
+func LoadUsers(s store.Store) (*UserList, error) {
+	var userList UserList
+	if err := s.Load(UsersKey, &userList); err != nil && !errors.Is(err, store.ErrNotFound) {
+		return nil, err
+	}
+	if userList.Users == nil {
+		userList.Users = []User{}
+	}
+	return &userList, nil
+}
+
+// UpdateUsers 在存储锁内修改用户列表，fn返回错误时不保存
+func UpdateUsers(s store.Store, fn func(userList *UserList) error) error {
+	var userList UserList
+	return s.Update(UsersKey, &userList, func() error {
+		if userList.Users == nil {
+			userList.Users = []User{}
+		}
+		return fn(&userList)
+	})
+}
+
+// AddUser 添加新用户，用户名已存在时更新该用户
+func AddUser(userList *UserList, user User) *UserList {
+	for i, existingUser := range userList.Users {
+		if existingUser.Username == user.Username {
+			userList.Users[i] = user
+			return userList
+		}
+	}
+
+	userList.Users = append(userList.Users, user)
+	return userList
+}
+
+// GetUser 根据用户名获取用户
+func GetUser(userList *UserList, username string) (User, bool) {
+	for _, user := range userList.Users {
+		if user.Username == username {
+			return user, true
+		}
+	}
+	return User{}, false
+}
+
+// DeleteUser 删除用户
+func DeleteUser(userList *UserList, username string) *UserList {
+	for i, user := range userList.Users {
+		if user.Username == username {
+			userList.Users = append(userList.Users[:i], userList.Users[i+1:]...)
+			break
+		}
+	}
+	return userList
+}
+
+// RemoveAppRoles 撤销所有用户在指定应用上的角色
+func RemoveAppRoles(userList *UserList, appID string) *UserList {
+	for _, user := range userList.Users {
+		delete(user.Roles, appID)
+	}
+	return userList
+}
+
+// CountGlobalOwners 统计未禁用的全局所有者数量
+func CountGlobalOwners(userList *UserList) int {
+	count := 0
+	for _, user := range userList.Users {
+		if !user.Disabled && user.Roles[AllApps] == RoleOwner {
+			count++
+		}
+	}
+	return count
+}
@@ -1,130 +1,174 @@
 package models
 
 import (
-	"encoding/json"
-	"io/ioutil"
-	"os"
-	"path/filepath"
+	"errors"
 	"time"
+
+	"hotupdate/app/store"
 )
 
 // Version 表示一个版本信息
 type Version struct {
-	ID          string    `json:"id"`          // 版本ID
-	Name        string    `json:"name"`        // 版本名称
-	Description string    `json:"description"` // 版本描述
-	FilePath    string    `json:"filePath"`    // 版本文件路径
-	FileSize    int64     `json:"fileSize"`    // 文件大小
-	CreatedAt   time.Time `json:"createdAt"`   // 创建时间
-	Force       bool      `json:"force"`       // 是否强制更新
+	ID            string         `json:"id"`                      // 版本ID
+	Name          string         `json:"name"`                    // 版本名称
+	Description   string         `json:"description"`             // 版本描述
+	FilePath      string         `json:"filePath"`                // 版本文件路径
+	FileSize      int64          `json:"fileSize"`                // 文件大小
+	SHA256        string         `json:"sha256"`                  // 文件SHA-256（十六进制）
+	MD5           string         `json:"md5"`                     // 文件MD5（十六进制），供UE工具链校验
+	CRC32         string         `json:"crc32"`                   // 文件CRC32（十六进制），供UE工具链校验
+	CreatedAt     time.Time      `json:"createdAt"`               // 创建时间
+	Force         bool           `json:"force"`                   // 是否强制更新
+	Cumulative    bool           `json:"cumulative"`              // 是否为累积版本：包含之前所有版本的改动，可从任意旧版本直接更新
+	Delta         *Delta         `json:"delta,omitempty"`         // 从上一个版本到此版本的差分包
+	Rollout       *Rollout       `json:"rollout,omitempty"`       // 灰度发布设置，为空时全量发布
+	Yanked        *Yank          `json:"yanked,omitempty"`        // 撤回信息，为空时版本正常提供
+	Compatibility *Compatibility `json:"compatibility,omitempty"` // 兼容性要求，为空时可以从任意客户端更新
+	Artifacts     []Artifact     `json:"artifacts,omitempty"`     // 平台或纹理格式专用的更新包
+	ReleaseNotes  string         `json:"releaseNotes,omitempty"`  // 发布说明，展示给玩家的更新内容
+	DownloadedAt  *time.Time     `json:"downloadedAt,omitempty"`  // 首次被下载的时间（重定向到对象存储时为首次重定向的时间），被下载过的版本不能删除
+	Reports       *ReportStats   `json:"reports,omitempty"`       // 客户端上报的更新结果统计
 }
 
-// VersionList 表示版本列表
-type VersionList struct {
-	Versions      []Version `json:"versions"`      // 版本列表
-	LatestVersion string    `json:"latestVersion"` // 最新版本
+// Yank 表示版本已被撤回：检查更新不再提供此版本，但已安装的客户端仍可下载
+type Yank struct {
+	Reason     string    `json:"reason"`               // 撤回原因
+	FixVersion string    `json:"fixVersion,omitempty"` // 已安装此版本的客户端应更新到的版本，回滚时为更早的版本
+	YankedBy   string    `json:"yankedBy"`             // 操作者
+	YankedAt   time.Time `json:"yankedAt"`             // 撤回时间
 }
 
-// LoadVersions 从文件加载版本信息
-func LoadVersions(filePath string) (*VersionList, error) {
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
-		return &VersionList{
-			Versions:      []Version{},
-			LatestVersion: "",
-		}, nil
-	}
+// 差分包生成状态
+const (
+	DeltaPending = "pending" // 等待后台生成
+	DeltaReady   = "ready"   // 已生成，可以下载
+	DeltaFailed  = "failed"  // 生成失败，客户端使用完整包
+	DeltaSkipped = "skipped" // 差分包不比完整包小，不提供差分包
+)
 
-	data, err := ioutil.ReadFile(filePath)
-	if err != nil {
-		return nil, err
-	}
+// Delta 表示从某个版本到当前版本的按条目差分包（zipdelta格式）
+type Delta struct {
+	FromVersion string    `json:"fromVersion"`        // 基础版本ID
+	Status      string    `json:"status"`             // 生成状态
+	FilePath    string    `json:"filePath,omitempty"` // 差分包路径
+	FileSize    int64     `json:"fileSize,omitempty"` // 差分包大小
+	SHA256      string    `json:"sha256,omitempty"`   // 差分包SHA-256
+	Error       string    `json:"error,omitempty"`    // 生成失败原因
+	UpdatedAt   time.Time `json:"updatedAt"`          // 状态更新时间
+}
 
-	var versionList VersionList
-	err = json.Unmarshal(data, &versionList)
-	if err != nil {
-		return nil, err
-	}
+// Available 判断差分包是否可用于从fromVersion更新
+func (d *Delta) Available(fromVersion string) bool {
+	return d != nil && d.Status == DeltaReady && d.FromVersion == fromVersion
+}
 
-	return &versionList, nil
+// ETag 获取差分包的强ETag
+func (d *Delta) ETag() string {
+	return `"` + d.SHA256 + `"`
 }
 
-// SaveVersions 保存版本信息到文件
-func SaveVersions(versionList *VersionList, filePath string) error {
-	data, err := json.MarshalIndent(versionList, "", "  ")
-	if err != nil {
-		return err
+// ETag 获取版本文件的强ETag，旧版本没有记录哈希时返回空字符串
+func (v Version) ETag() string {
+	if v.SHA256 == "" {
+		return ""
 	}
+	return `"` + v.SHA256 + `"`
+}
 
-	return ioutil.WriteFile(filePath, data, 0644)
+// VersionList 表示版本列表
+type VersionList struct {
+	Versions      []Version `json:"versions"`      // 版本列表
+	LatestVersion string    `json:"latestVersion"` // 最新版本（所有渠道中最后发布的版本）
+	Channels      []Channel `json:"channels"`      // 发布渠道
 }
 
-// AddVersion 添加新版本
-func AddVersion(versionList *VersionList, version Version) *VersionList {
-	versionList.Versions = append(versionList.Versions, version)
-	versionList.LatestVersion = version.ID
-	return versionList
+// VersionsKey 获取应用版本列表在元数据存储中的键
+func VersionsKey(appID string) string {
+	return "apps/" + appID + "/versions"
 }
 
-// CreateInitialVersion 创建初始版本
-func CreateInitialVersion(uploadsDir string, initialZip string) (*VersionList, error) {
-	// 创建版本目录
-	versionId := "1.0.0"
-	versionDir := filepath.Join(uploadsDir, "versions", versionId)
-	if err := os.MkdirAll(versionDir, 0755); err != nil {
+// LoadVersions 加载应用的版本列表
+func LoadVersions(s store.Store, appID string) (*VersionList, error) {
+	var versionList VersionList
+	if err := s.Load(VersionsKey(appID), &versionList); err != nil && !errors.Is(err, store.ErrNotFound) {
 		return nil, err
 	}
+	if versionList.Versions == nil {
+		versionList.Versions = []Version{}
+	}
+	ensureChannels(&versionList)
+	return &versionList, nil
+}
 
-	// 假设初始zip文件已经存在
-	zipPath := filepath.Join(versionDir, "update.zip")
-
-	// 如果提供了初始zip文件，则复制它
-	if initialZip != "" {
-		if err := copyFile(initialZip, zipPath); err != nil {
-			return nil, err
+// UpdateVersions 在该应用的存储锁内修改版本列表，fn返回错误时不保存
+func UpdateVersions(s store.Store, appID string, fn func(versionList *VersionList) error) error {
+	var versionList VersionList
+	return s.Update(VersionsKey(appID), &versionList, func() error {
+		if versionList.Versions == nil {
+			versionList.Versions = []Version{}
 		}
-	} else {
-		// 创建空文件作为占位符
-		emptyFile, err := os.Create(zipPath)
-		if err != nil {
-			return nil, err
+		ensureChannels(&versionList)
+		return fn(&versionList)
+	})
+}
+
+// GetVersion 根据版本ID获取版本
+func GetVersion(versionList *VersionList, versionID string) (Version, bool) {
+	for _, version := range versionList.Versions {
+		if version.ID == versionID {
+			return version, true
 		}
-		emptyFile.Close()
 	}
+	return Version{}, false
+}
 
-	fileInfo, err := os.Stat(zipPath)
-	if err != nil {
-		return nil, err
+// SetVersion 替换已存在的版本，版本不存在时返回false
+func SetVersion(versionList *VersionList, version Version) bool {
+	for i, existing := range versionList.Versions {
+		if existing.ID == version.ID {
+			versionList.Versions[i] = version
+			return true
+		}
 	}
+	return false
+}
 
-	// 创建初始版本信息
-	initialVersion := Version{
-		ID:          versionId,
-		Name:        "初始版本",
-		Description: "系统初始版本",
-		FilePath:    filepath.Join("versions", versionId, "update.zip"),
-		FileSize:    fileInfo.Size(),
-		CreatedAt:   time.Now(),
-		Force:       false,
-	}
+// AddVersion 添加新版本
+func AddVersion(versionList *VersionList, version Version) *VersionList {
+	versionList.Versions = append(versionList.Versions, version)
+	RefreshLatestVersion(versionList)
+	return versionList
+}
 
-	versionList := &VersionList{
-		Versions:      []Version{initialVersion},
-		LatestVersion: versionId,
+// DeleteVersion 从版本列表和所有渠道中删除版本，并重新计算最新版本
+func DeleteVersion(versionList *VersionList, versionID string) *VersionList {
+	for i, version := range versionList.Versions {
+		if version.ID == versionID {
+			versionList.Versions = append(versionList.Versions[:i], versionList.Versions[i+1:]...)
+			break
+		}
 	}
-
-	// 保存版本信息
-	if err := SaveVersions(versionList, filepath.Join(uploadsDir, "versions.json")); err != nil {
-		return nil, err
+	for i := range versionList.Channels {
+		channel := &versionList.Channels[i]
+		for j, id := range channel.Versions {
+			if id == versionID {
+				channel.Versions = append(channel.Versions[:j], channel.Versions[j+1:]...)
+				break
+			}
+		}
+		delete(channel.Yanked, versionID)
 	}
-
-	return versionList, nil
+	return RefreshLatestVersion(versionList)
 }
 
-// 辅助函数：复制文件
-func copyFile(src, dst string) error {
-	data, err := ioutil.ReadFile(src)
-	if err != nil {
-		return err
+// RefreshLatestVersion 将最新版本设为最后一个未撤回的版本，撤回或回滚后调用
+func RefreshLatestVersion(versionList *VersionList) *VersionList {
+	versionList.LatestVersion = ""
+	for i := len(versionList.Versions) - 1; i >= 0; i-- {
+		if versionList.Versions[i].Yanked == nil {
+			versionList.LatestVersion = versionList.Versions[i].ID
+			break
+		}
 	}
-	return ioutil.WriteFile(dst, data, 0644)
+	return versionList
 }
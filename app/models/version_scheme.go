@@ -0,0 +1,185 @@
+package models
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"hotupdate/app/utils"
+)
+
+// 版本号方案
+const (
+	VersionSchemeSemver     = "semver"     // 语义化版本（默认），如1.2.0、1.3.0-beta.2、1.3.0+cl4412
+	VersionSchemeChangelist = "changelist" // 整数变更号，如UE项目的Perforce changelist 4412
+	VersionSchemeDate       = "date"       // 日期版本，如2024.05.01、2024.05.01.2或20240501.2
+)
+
+// VersionScheme 定义版本ID的格式和比较规则
+type VersionScheme interface {
+	// Name 方案名称
+	Name() string
+	// Validate 严格校验新发布版本的ID
+	Validate(id string) error
+	// Comparable 判断版本ID能否参与比较，用于检查更新时的客户端版本，比Validate宽松
+	Comparable(id string) bool
+	// Compare 比较两个版本ID，返回-1、0或1。无法解析的ID排在所有有效ID之前
+	Compare(a string, b string) int
+}
+
+// GetVersionScheme 根据名称获取版本号方案，名称为空时使用语义化版本
+func GetVersionScheme(name string) (VersionScheme, bool) {
+	switch name {
+	case "", VersionSchemeSemver:
+		return semverScheme{}, true
+	case VersionSchemeChangelist:
+		return changelistScheme{}, true
+	case VersionSchemeDate:
+		return dateScheme{}, true
+	}
+	return nil, false
+}
+
+// ValidVersionScheme 判断版本号方案是否有效
+func ValidVersionScheme(name string) bool {
+	_, ok := GetVersionScheme(name)
+	return ok
+}
+
+// Scheme 获取应用的版本号方案，未设置或无法识别时使用语义化版本
+func (a App) Scheme() VersionScheme {
+	if scheme, ok := GetVersionScheme(a.VersionScheme); ok {
+		return scheme
+	}
+	return semverScheme{}
+}
+
+// 按解析结果比较，无法解析的ID排在前面，两个都无法解析时按字符串比较
+func compareParsed(a string, b string, aErr error, bErr error, compare func() int) int {
+	switch {
+	case aErr != nil && bErr != nil:
+		return strings.Compare(a, b)
+	case aErr != nil:
+		return -1
+	case bErr != nil:
+		return 1
+	}
+	return compare()
+}
+
+// semverScheme 语义化版本。新版本必须符合SemVer 2.0，
+// 比较时兼容旧数据和旧客户端的宽松格式（如1.0、v1.2.3）
+type semverScheme struct{}
+
+func (semverScheme) Name() string { return VersionSchemeSemver }
+
+func (semverScheme) Validate(id string) error {
+	_, err := utils.ParseSemVer(id)
+	return err
+}
+
+func (semverScheme) Comparable(id string) bool {
+	_, err := utils.ParseSemVerLoose(id)
+	return err == nil
+}
+
+func (semverScheme) Compare(a string, b string) int {
+	va, aErr := utils.ParseSemVerLoose(a)
+	vb, bErr := utils.ParseSemVerLoose(b)
+	return compareParsed(a, b, aErr, bErr, func() int { return va.Compare(vb) })
+}
+
+// changelistScheme 整数变更号
+type changelistScheme struct{}
+
+func (changelistScheme) Name() string { return VersionSchemeChangelist }
+
+func (changelistScheme) Validate(id string) error {
+	_, err := parseChangelist(id)
+	return err
+}
+
+func (s changelistScheme) Comparable(id string) bool {
+	return s.Validate(id) == nil
+}
+
+func (changelistScheme) Compare(a string, b string) int {
+	na, aErr := parseChangelist(a)
+	nb, bErr := parseChangelist(b)
+	return compareParsed(a, b, aErr, bErr, func() int {
+		switch {
+		case na < nb:
+			return -1
+		case na > nb:
+			return 1
+		}
+		return 0
+	})
+}
+
+func parseChangelist(id string) (uint64, error) {
+	n, err := strconv.ParseUint(id, 10, 64)
+	if err != nil || n == 0 || id[0] == '0' {
+		return 0, errors.New("变更号必须是不以0开头的正整数")
+	}
+	return n, nil
+}
+
+// dateScheme 日期版本，同一天的多个版本用序号区分
+type dateScheme struct{}
+
+func (dateScheme) Name() string { return VersionSchemeDate }
+
+func (dateScheme) Validate(id string) error {
+	_, _, err := parseDateVersion(id)
+	return err
+}
+
+func (s dateScheme) Comparable(id string) bool {
+	return s.Validate(id) == nil
+}
+
+func (dateScheme) Compare(a string, b string) int {
+	da, sa, aErr := parseDateVersion(a)
+	db, sb, bErr := parseDateVersion(b)
+	return compareParsed(a, b, aErr, bErr, func() int {
+		switch {
+		case da.Before(db):
+			return -1
+		case da.After(db):
+			return 1
+		case sa < sb:
+			return -1
+		case sa > sb:
+			return 1
+		}
+		return 0
+	})
+}
+
+// 解析日期版本，支持YYYY.MM.DD[.N]和YYYYMMDD[.N]，没有序号时为0
+func parseDateVersion(id string) (time.Time, uint64, error) {
+	invalid := errors.New("日期版本格式必须是 YYYY.MM.DD[.序号] 或 YYYYMMDD[.序号]")
+
+	layout, datePart, rest := "20060102", id, ""
+	if len(id) >= 10 && id[4] == '.' {
+		layout, datePart, rest = "2006.01.02", id[:10], id[10:]
+	} else if i := strings.IndexByte(id, '.'); i >= 0 {
+		datePart, rest = id[:i], id[i:]
+	}
+
+	date, err := time.Parse(layout, datePart)
+	if err != nil {
+		return time.Time{}, 0, invalid
+	}
+
+	var seq uint64
+	if rest != "" {
+		seq, err = strconv.ParseUint(strings.TrimPrefix(rest, "."), 10, 64)
+		if err != nil || !strings.HasPrefix(rest, ".") {
+			return time.Time{}, 0, invalid
+		}
+	}
+	return date, seq, nil
+}
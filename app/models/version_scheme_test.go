@@ -0,0 +1,67 @@
+package models
+
+import "testing"
+
+func TestVersionSchemeValidate(t *testing.T) {
+	tests := []struct {
+		scheme  string
+		version string
+		ok      bool
+	}{
+		{VersionSchemeSemver, "1.3.0-beta.2", true},
+		{VersionSchemeSemver, "1.3.0+cl4412", true},
+		{VersionSchemeSemver, "1.3", false},
+		{VersionSchemeSemver, "1.03.0", false},
+		{VersionSchemeChangelist, "4412", true},
+		{VersionSchemeChangelist, "0", false},
+		{VersionSchemeChangelist, "04412", false},
+		{VersionSchemeChangelist, "-1", false},
+		{VersionSchemeChangelist, "cl4412", false},
+		{VersionSchemeDate, "2024.05.01", true},
+		{VersionSchemeDate, "2024.05.01.2", true},
+		{VersionSchemeDate, "20240501", true},
+		{VersionSchemeDate, "20240501.2", true},
+		{VersionSchemeDate, "2024.02.30", false},
+		{VersionSchemeDate, "2024.5.1", false},
+		{VersionSchemeDate, "2024.05.01.", false},
+		{VersionSchemeDate, "2024.05.01-2", false},
+		{VersionSchemeDate, "20240501.x", false},
+	}
+	for _, tt := range tests {
+		scheme, _ := GetVersionScheme(tt.scheme)
+		if err := scheme.Validate(tt.version); (err == nil) != tt.ok {
+			t.Errorf("%s方案校验 %q: %v，期望通过 %v", tt.scheme, tt.version, err, tt.ok)
+		}
+	}
+}
+
+func TestVersionSchemeCompare(t *testing.T) {
+	tests := []struct {
+		scheme string
+		a, b   string
+		want   int
+	}{
+		{VersionSchemeSemver, "1.3.0-beta.2", "1.3.0", -1},
+		{VersionSchemeSemver, "v1.2.3", "1.2.3", 0},
+		{VersionSchemeSemver, "1.2.3+cl1", "1.2.3+cl2", 0},
+		{VersionSchemeChangelist, "9", "10", -1},
+		{VersionSchemeChangelist, "4412", "4412", 0},
+		{VersionSchemeDate, "2024.05.01", "20240501", 0},
+		{VersionSchemeDate, "2024.05.01", "2024.05.01.1", -1},
+		{VersionSchemeDate, "2024.05.01.10", "2024.05.01.9", 1},
+		{VersionSchemeDate, "2024.04.30.9", "2024.05.01", -1},
+		// 无法解析的ID排在所有有效ID之前，两个都无法解析时按字符串比较
+		{VersionSchemeSemver, "unknown", "0.0.1", -1},
+		{VersionSchemeChangelist, "abc", "1", -1},
+		{VersionSchemeDate, "a", "b", -1},
+	}
+	for _, tt := range tests {
+		scheme, _ := GetVersionScheme(tt.scheme)
+		if got := scheme.Compare(tt.a, tt.b); got != tt.want {
+			t.Errorf("%s方案 Compare(%s, %s) = %d，期望 %d", tt.scheme, tt.a, tt.b, got, tt.want)
+		}
+		if got := scheme.Compare(tt.b, tt.a); got != -tt.want {
+			t.Errorf("%s方案 Compare(%s, %s) = %d，期望 %d", tt.scheme, tt.b, tt.a, got, -tt.want)
+		}
+	}
+}
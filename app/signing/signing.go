@@ -0,0 +1,181 @@
+package signing
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Algorithm 签名算法名称
+const Algorithm = "ed25519"
+
+// KeyConfig 签名密钥配置。私钥为base64编码的32字节种子（或64字节私钥），
+// 也可以放在privateKeyFile中，或通过环境变量 HOTUPDATE_SIGNING_KEY_<ID> 提供。
+// 只配置公钥的密钥不再用于签名，只用于发布给客户端，以便验证轮换前签发的清单。
+type KeyConfig struct {
+	ID             string `json:"id"`             // 密钥ID，客户端据此选择公钥
+	PrivateKey     string `json:"privateKey"`     // base64编码的私钥
+	PrivateKeyFile string `json:"privateKeyFile"` // 私钥文件路径，文件内容为base64编码的私钥
+	PublicKey      string `json:"publicKey"`      // base64编码的公钥，未配置时由私钥推导
+}
+
+// Config 清单签名配置
+type Config struct {
+	ActiveKeyID string      `json:"activeKeyId"` // 当前用于签名的密钥ID
+	Keys        []KeyConfig `json:"keys"`        // 所有有效密钥，包括轮换前的旧密钥
+}
+
+// PublicKey 发布给客户端的公钥信息
+type PublicKey struct {
+	ID        string `json:"keyId"`
+	Algorithm string `json:"algorithm"`
+	PublicKey string `json:"publicKey"` // base64编码
+	Active    bool   `json:"active"`    // 是否为当前签名密钥
+}
+
+// Signature 一次签名的结果
+type Signature struct {
+	KeyID     string `json:"keyId"`
+	Algorithm string `json:"algorithm"`
+	Signature string `json:"signature"` // base64编码
+}
+
+type key struct {
+	id         string
+	publicKey  ed25519.PublicKey
+	privateKey ed25519.PrivateKey
+}
+
+// Signer 清单签名器，持有当前签名密钥和全部可发布的公钥
+type Signer struct {
+	active *key
+	keys   []key
+}
+
+// New 根据配置创建签名器，未配置任何密钥时返回nil
+func New(cfg Config) (*Signer, error) {
+	if len(cfg.Keys) == 0 {
+		return nil, nil
+	}
+
+	s := &Signer{}
+	seen := make(map[string]bool)
+	for _, kc := range cfg.Keys {
+		if kc.ID == "" {
+			return nil, errors.New("签名密钥ID不能为空")
+		}
+		if seen[kc.ID] {
+			return nil, fmt.Errorf("签名密钥ID重复: %s", kc.ID)
+		}
+		seen[kc.ID] = true
+
+		k, err := loadKey(kc)
+		if err != nil {
+			return nil, fmt.Errorf("加载签名密钥 %s 失败: %w", kc.ID, err)
+		}
+		s.keys = append(s.keys, k)
+	}
+
+	activeID := cfg.ActiveKeyID
+	if activeID == "" && len(s.keys) == 1 {
+		activeID = s.keys[0].id
+	}
+	for i := range s.keys {
+		if s.keys[i].id == activeID {
+			s.active = &s.keys[i]
+		}
+	}
+	if s.active == nil {
+		return nil, fmt.Errorf("未找到当前签名密钥: %q", activeID)
+	}
+	if s.active.privateKey == nil {
+		return nil, fmt.Errorf("当前签名密钥 %s 没有配置私钥", activeID)
+	}
+
+	return s, nil
+}
+
+// 加载一个密钥，私钥依次从配置、文件和环境变量读取
+func loadKey(kc KeyConfig) (key, error) {
+	k := key{id: kc.ID}
+
+	encoded := kc.PrivateKey
+	if encoded == "" && kc.PrivateKeyFile != "" {
+		data, err := os.ReadFile(kc.PrivateKeyFile)
+		if err != nil {
+			return key{}, err
+		}
+		encoded = string(data)
+	}
+	if encoded == "" {
+		encoded = os.Getenv("HOTUPDATE_SIGNING_KEY_" + strings.ToUpper(kc.ID))
+	}
+
+	if encoded = strings.TrimSpace(encoded); encoded != "" {
+		raw, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return key{}, fmt.Errorf("私钥不是有效的base64: %w", err)
+		}
+		switch len(raw) {
+		case ed25519.SeedSize:
+			k.privateKey = ed25519.NewKeyFromSeed(raw)
+		case ed25519.PrivateKeySize:
+			k.privateKey = ed25519.PrivateKey(raw)
+		default:
+			return key{}, fmt.Errorf("私钥长度无效: %d 字节", len(raw))
+		}
+		k.publicKey = k.privateKey.Public().(ed25519.PublicKey)
+	}
+
+	if kc.PublicKey != "" {
+		raw, err := base64.StdEncoding.DecodeString(kc.PublicKey)
+		if err != nil || len(raw) != ed25519.PublicKeySize {
+			return key{}, errors.New("公钥无效")
+		}
+		if k.publicKey != nil && !k.publicKey.Equal(ed25519.PublicKey(raw)) {
+			return key{}, errors.New("公钥与私钥不匹配")
+		}
+		k.publicKey = ed25519.PublicKey(raw)
+	}
+
+	if k.publicKey == nil {
+		return key{}, errors.New("未配置私钥或公钥")
+	}
+	return k, nil
+}
+
+// Sign 使用当前密钥签名
+func (s *Signer) Sign(payload []byte) Signature {
+	return Signature{
+		KeyID:     s.active.id,
+		Algorithm: Algorithm,
+		Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(s.active.privateKey, payload)),
+	}
+}
+
+// PublicKeys 获取全部公钥
+func (s *Signer) PublicKeys() []PublicKey {
+	keys := make([]PublicKey, 0, len(s.keys))
+	for _, k := range s.keys {
+		keys = append(keys, PublicKey{
+			ID:        k.id,
+			Algorithm: Algorithm,
+			PublicKey: base64.StdEncoding.EncodeToString(k.publicKey),
+			Active:    k.id == s.active.id,
+		})
+	}
+	return keys
+}
+
+// GenerateKey 生成新的密钥对，返回base64编码的私钥种子和公钥
+func GenerateKey() (string, string, error) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	return base64.StdEncoding.EncodeToString(privateKey.Seed()), base64.StdEncoding.EncodeToString(publicKey), nil
+}
@@ -0,0 +1,202 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"hotupdate/app/utils"
+)
+
+// LocalStorage 本地文件系统存储
+type LocalStorage struct {
+	root string
+}
+
+// NewLocalStorage 创建以root为根目录的本地存储
+func NewLocalStorage(root string) *LocalStorage {
+	return &LocalStorage{root: root}
+}
+
+// 将对象键转换为本地文件路径，键会解析到根目录之外时返回utils.ErrUnsafePath
+func (s *LocalStorage) path(key string) (string, error) {
+	return utils.SafeJoin(s.root, key)
+}
+
+// Put 写入对象，先写入临时文件再重命名，避免读到写了一半的文件
+func (s *LocalStorage) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	filePath, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(filePath), "."+filepath.Base(filePath)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+
+	written, err := io.Copy(tmp, r)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	if size >= 0 && written != size {
+		tmp.Close()
+		return fmt.Errorf("写入大小不一致: 期望 %d 字节，实际 %d 字节", size, written)
+	}
+	// 重命名之前落盘，断电后不会出现大小正确但内容为空的文件
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpPath, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, filePath); err != nil {
+		return err
+	}
+
+	// 同步目录，确保重命名本身已落盘
+	if d, err := os.Open(filepath.Dir(filePath)); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// Get 读取对象，返回的对象同时实现了io.ReadSeeker
+func (s *LocalStorage) Get(ctx context.Context, key string) (*Object, error) {
+	filePath, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(filePath)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	fileInfo, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if fileInfo.IsDir() {
+		f.Close()
+		return nil, ErrNotFound
+	}
+
+	return &Object{
+		ReadCloser: f,
+		Info:       ObjectInfo{Key: key, Size: fileInfo.Size(), ModTime: fileInfo.ModTime()},
+	}, nil
+}
+
+// Stat 获取对象元信息
+func (s *LocalStorage) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	filePath, err := s.path(key)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	fileInfo, err := os.Stat(filePath)
+	if os.IsNotExist(err) {
+		return ObjectInfo{}, ErrNotFound
+	}
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	if fileInfo.IsDir() {
+		return ObjectInfo{}, ErrNotFound
+	}
+
+	return ObjectInfo{Key: key, Size: fileInfo.Size(), ModTime: fileInfo.ModTime()}, nil
+}
+
+// Delete 删除对象，同时删除因此变空的上级目录（不包括根目录）
+func (s *LocalStorage) Delete(ctx context.Context, key string) error {
+	filePath, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(filePath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	// 目录不为空时os.Remove会失败，到此为止
+	root := filepath.Clean(s.root)
+	for dir := filepath.Dir(filePath); dir != root && strings.HasPrefix(dir, root+string(filepath.Separator)); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+// List 列出指定前缀下的所有对象
+func (s *LocalStorage) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	objects := []ObjectInfo{}
+
+	// 前缀可能是目录，也可能是文件名的一部分，从其所在目录开始遍历
+	dir, err := s.path(prefix)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(prefix, "/") {
+		dir = filepath.Dir(dir)
+	}
+
+	err = filepath.Walk(dir, func(filePath string, fileInfo os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if fileInfo.IsDir() || strings.HasSuffix(fileInfo.Name(), ".tmp") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(s.root, filePath)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if strings.HasPrefix(key, prefix) {
+			objects = append(objects, ObjectInfo{Key: key, Size: fileInfo.Size(), ModTime: fileInfo.ModTime()})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return objects, nil
+}
+
+// PresignGet 本地存储不支持预签名地址，由服务器直接提供下载
+func (s *LocalStorage) PresignGet(ctx context.Context, key string, expires time.Duration) (string, error) {
+	return "", ErrPresignNotSupported
+}
+
+// String 返回存储描述，用于日志输出
+func (s *LocalStorage) String() string {
+	return fmt.Sprintf("local(%s)", s.root)
+}
+
+// 确保LocalStorage实现了Storage接口
+var _ Storage = (*LocalStorage)(nil)
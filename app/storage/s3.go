@@ -0,0 +1,586 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// 未签名的请求体哈希，S3及MinIO等兼容实现均支持
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+	// 空请求体的SHA-256
+	emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	// 默认分片大小，超过该大小的文件使用分片上传（S3单次PUT上限为5GB）
+	defaultPartSize = 64 << 20
+)
+
+// S3Config S3兼容对象存储配置
+type S3Config struct {
+	Endpoint       string `json:"endpoint"`       // 服务地址，例如 https://s3.amazonaws.com 或 http://127.0.0.1:9000
+	Region         string `json:"region"`         // 区域，默认 us-east-1
+	Bucket         string `json:"bucket"`         // 存储桶
+	AccessKey      string `json:"accessKey"`      // 访问密钥ID，为空时读取环境变量 AWS_ACCESS_KEY_ID
+	SecretKey      string `json:"secretKey"`      // 访问密钥，为空时读取环境变量 AWS_SECRET_ACCESS_KEY
+	Prefix         string `json:"prefix"`         // 对象键前缀，可用于多个服务共享同一存储桶
+	PathStyle      bool   `json:"pathStyle"`      // 使用路径风格地址（MinIO等自建服务通常需要开启）
+	PresignSeconds int    `json:"presignSeconds"` // 预签名下载地址有效期，默认3600秒；小于0时不使用预签名，由服务器代理下载
+	PartSizeMB     int    `json:"partSizeMB"`     // 分片上传的分片大小，默认64MB
+}
+
+// S3Storage S3兼容对象存储，使用AWS Signature V4签名
+type S3Storage struct {
+	cfg      S3Config
+	endpoint *url.URL
+	partSize int64
+
+	// Client 发送请求使用的HTTP客户端，可替换为指向本地模拟服务的客户端
+	Client *http.Client
+	// now 获取当前时间，便于校验签名
+	now func() time.Time
+}
+
+// NewS3Storage 创建S3兼容存储
+func NewS3Storage(cfg S3Config) (*S3Storage, error) {
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = "https://s3.amazonaws.com"
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if cfg.AccessKey == "" {
+		cfg.AccessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+	}
+	if cfg.SecretKey == "" {
+		cfg.SecretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	}
+	if cfg.Bucket == "" {
+		return nil, errors.New("S3存储未配置bucket")
+	}
+	if cfg.AccessKey == "" || cfg.SecretKey == "" {
+		return nil, errors.New("S3存储未配置访问密钥")
+	}
+
+	endpoint, err := url.Parse(strings.TrimSuffix(cfg.Endpoint, "/"))
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("无效的S3服务地址: %s", cfg.Endpoint)
+	}
+
+	partSize := int64(defaultPartSize)
+	if cfg.PartSizeMB > 0 {
+		partSize = int64(cfg.PartSizeMB) << 20
+	}
+
+	return &S3Storage{
+		cfg:      cfg,
+		endpoint: endpoint,
+		partSize: partSize,
+		Client:   http.DefaultClient,
+		now:      time.Now,
+	}, nil
+}
+
+// Put 写入对象，超过分片大小或大小未知时使用分片上传
+func (s *S3Storage) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	if size < 0 || size > s.partSize {
+		return s.putMultipart(ctx, key, r)
+	}
+
+	resp, err := s.do(ctx, http.MethodPut, key, nil, io.LimitReader(r, size), size)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return checkResponse(resp)
+}
+
+// Get 读取对象
+func (s *S3Storage) Get(ctx context.Context, key string) (*Object, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, nil, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkResponse(resp); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+
+	return &Object{ReadCloser: resp.Body, Info: objectInfoFromHeader(key, resp)}, nil
+}
+
+// GetRange 读取对象从offset开始的length个字节
+func (s *S3Storage) GetRange(ctx context.Context, key string, offset int64, length int64) (io.ReadCloser, error) {
+	req, err := s.newSignedRequest(ctx, http.MethodGet, s.objectURL(key), nil, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkResponse(resp); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		return nil, fmt.Errorf("对象存储未返回部分内容: %s", resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// Stat 获取对象元信息
+func (s *S3Storage) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	resp, err := s.do(ctx, http.MethodHead, key, nil, nil, 0)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	defer resp.Body.Close()
+
+	if err := checkResponse(resp); err != nil {
+		return ObjectInfo{}, err
+	}
+	return objectInfoFromHeader(key, resp), nil
+}
+
+// Delete 删除对象
+func (s *S3Storage) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, nil, 0)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := checkResponse(resp); err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	return nil
+}
+
+// List 列出指定前缀下的所有对象
+func (s *S3Storage) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	objects := []ObjectInfo{}
+	continuation := ""
+
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		query.Set("prefix", s.objectKey(prefix))
+		if continuation != "" {
+			query.Set("continuation-token", continuation)
+		}
+
+		resp, err := s.doBucket(ctx, http.MethodGet, query)
+		if err != nil {
+			return nil, err
+		}
+
+		var result struct {
+			Contents []struct {
+				Key          string    `xml:"Key"`
+				Size         int64     `xml:"Size"`
+				LastModified time.Time `xml:"LastModified"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		err = checkResponse(resp)
+		if err == nil {
+			err = xml.NewDecoder(resp.Body).Decode(&result)
+		}
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, item := range result.Contents {
+			objects = append(objects, ObjectInfo{
+				Key:     strings.TrimPrefix(item.Key, s.keyPrefix()),
+				Size:    item.Size,
+				ModTime: item.LastModified,
+			})
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+		continuation = result.NextContinuationToken
+	}
+
+	return objects, nil
+}
+
+// PresignGet 生成预签名下载地址，expires为0时使用配置的默认有效期
+func (s *S3Storage) PresignGet(ctx context.Context, key string, expires time.Duration) (string, error) {
+	if s.cfg.PresignSeconds < 0 {
+		return "", ErrPresignNotSupported
+	}
+	if expires <= 0 {
+		expires = time.Duration(s.cfg.PresignSeconds) * time.Second
+	}
+	if expires <= 0 {
+		expires = time.Hour
+	}
+
+	now := s.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	scope := s.credentialScope(now)
+
+	u := s.objectURL(key)
+	query := url.Values{}
+	query.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	query.Set("X-Amz-Credential", s.cfg.AccessKey+"/"+scope)
+	query.Set("X-Amz-Date", amzDate)
+	query.Set("X-Amz-Expires", strconv.Itoa(int(expires.Seconds())))
+	query.Set("X-Amz-SignedHeaders", "host")
+
+	canonicalRequest := strings.Join([]string{
+		http.MethodGet,
+		u.EscapedPath(),
+		canonicalQuery(query),
+		"host:" + u.Host + "\n",
+		"host",
+		unsignedPayload,
+	}, "\n")
+
+	query.Set("X-Amz-Signature", s.signature(now, amzDate, scope, canonicalRequest))
+	u.RawQuery = canonicalQuery(query)
+	return u.String(), nil
+}
+
+// String 返回存储描述，用于日志输出
+func (s *S3Storage) String() string {
+	return fmt.Sprintf("s3(%s/%s)", s.endpoint.String(), s.cfg.Bucket)
+}
+
+// 分片上传，每个分片读入内存后发送
+func (s *S3Storage) putMultipart(ctx context.Context, key string, r io.Reader) error {
+	query := url.Values{}
+	query.Set("uploads", "")
+	resp, err := s.do(ctx, http.MethodPost, key, query, nil, 0)
+	if err != nil {
+		return err
+	}
+	var initResult struct {
+		UploadID string `xml:"UploadId"`
+	}
+	err = checkResponse(resp)
+	if err == nil {
+		err = xml.NewDecoder(resp.Body).Decode(&initResult)
+	}
+	resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("创建分片上传失败: %w", err)
+	}
+
+	type completedPart struct {
+		PartNumber int    `xml:"PartNumber"`
+		ETag       string `xml:"ETag"`
+	}
+	var parts []completedPart
+
+	abort := func() {
+		q := url.Values{}
+		q.Set("uploadId", initResult.UploadID)
+		if resp, err := s.do(context.Background(), http.MethodDelete, key, q, nil, 0); err == nil {
+			resp.Body.Close()
+		}
+	}
+
+	buf := make([]byte, s.partSize)
+	for partNumber := 1; ; partNumber++ {
+		n, readErr := io.ReadFull(r, buf)
+		if n == 0 && partNumber > 1 {
+			break
+		}
+		if readErr != nil && readErr != io.EOF && readErr != io.ErrUnexpectedEOF {
+			abort()
+			return readErr
+		}
+
+		q := url.Values{}
+		q.Set("partNumber", strconv.Itoa(partNumber))
+		q.Set("uploadId", initResult.UploadID)
+		resp, err := s.do(ctx, http.MethodPut, key, q, bytes.NewReader(buf[:n]), int64(n))
+		if err != nil {
+			abort()
+			return err
+		}
+		err = checkResponse(resp)
+		resp.Body.Close()
+		if err != nil {
+			abort()
+			return fmt.Errorf("上传分片 %d 失败: %w", partNumber, err)
+		}
+		parts = append(parts, completedPart{PartNumber: partNumber, ETag: resp.Header.Get("ETag")})
+
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+	}
+
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []completedPart `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		abort()
+		return err
+	}
+
+	q := url.Values{}
+	q.Set("uploadId", initResult.UploadID)
+	resp, err = s.do(ctx, http.MethodPost, key, q, bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		abort()
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := checkResponse(resp); err != nil {
+		abort()
+		return fmt.Errorf("完成分片上传失败: %w", err)
+	}
+
+	// S3在完成分片上传时可能返回200但响应体中包含错误
+	var completeResult struct {
+		XMLName xml.Name
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&completeResult); err == nil && completeResult.XMLName.Local == "Error" {
+		abort()
+		return fmt.Errorf("完成分片上传失败: %s %s", completeResult.Code, completeResult.Message)
+	}
+	return nil
+}
+
+// 对对象发送签名请求
+func (s *S3Storage) do(ctx context.Context, method, key string, query url.Values, body io.Reader, size int64) (*http.Response, error) {
+	return s.send(ctx, method, s.objectURL(key), query, body, size)
+}
+
+// 对存储桶发送签名请求
+func (s *S3Storage) doBucket(ctx context.Context, method string, query url.Values) (*http.Response, error) {
+	return s.send(ctx, method, s.bucketURL(), query, nil, 0)
+}
+
+// 构造并发送使用请求头签名的请求
+func (s *S3Storage) send(ctx context.Context, method string, u *url.URL, query url.Values, body io.Reader, size int64) (*http.Response, error) {
+	req, err := s.newSignedRequest(ctx, method, u, query, body, size)
+	if err != nil {
+		return nil, err
+	}
+	return s.Client.Do(req)
+}
+
+// 构造使用请求头签名的请求。Range等未参与签名的请求头可以在签名后再添加
+func (s *S3Storage) newSignedRequest(ctx context.Context, method string, u *url.URL, query url.Values, body io.Reader, size int64) (*http.Request, error) {
+	if query != nil {
+		u.RawQuery = canonicalQuery(query)
+	}
+
+	// 长度为0的请求体使用http.NoBody，否则会以chunked编码发送，S3要求PUT提供Content-Length
+	if body != nil && size == 0 {
+		body = http.NoBody
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+
+	payloadHash := emptyPayloadHash
+	if body != nil && body != http.NoBody {
+		payloadHash = unsignedPayload
+	}
+
+	now := s.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	scope := s.credentialScope(now)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalHeaders := "host:" + u.Host + "\n" +
+		"x-amz-content-sha256:" + payloadHash + "\n" +
+		"x-amz-date:" + amzDate + "\n"
+
+	canonicalRequest := strings.Join([]string{
+		method,
+		u.EscapedPath(),
+		u.RawQuery,
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.cfg.AccessKey, scope, signedHeaders, s.signature(now, amzDate, scope, canonicalRequest),
+	))
+
+	return req, nil
+}
+
+// 计算签名
+func (s *S3Storage) signature(now time.Time, amzDate, scope, canonicalRequest string) string {
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hex.EncodeToString(hash[:]),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.cfg.SecretKey), now.Format("20060102"))
+	key = hmacSHA256(key, s.cfg.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+// 签名范围
+func (s *S3Storage) credentialScope(now time.Time) string {
+	return now.Format("20060102") + "/" + s.cfg.Region + "/s3/aws4_request"
+}
+
+// 带配置前缀的对象键
+func (s *S3Storage) objectKey(key string) string {
+	return s.keyPrefix() + strings.TrimPrefix(key, "/")
+}
+
+// 规范化的对象键前缀
+func (s *S3Storage) keyPrefix() string {
+	prefix := strings.Trim(s.cfg.Prefix, "/")
+	if prefix == "" {
+		return ""
+	}
+	return prefix + "/"
+}
+
+// 存储桶地址
+func (s *S3Storage) bucketURL() *url.URL {
+	u := *s.endpoint
+	if s.cfg.PathStyle {
+		u.Path = "/" + s.cfg.Bucket + "/"
+	} else {
+		u.Host = s.cfg.Bucket + "." + u.Host
+		u.Path = "/"
+	}
+	return &u
+}
+
+// 对象地址
+func (s *S3Storage) objectURL(key string) *url.URL {
+	u := s.bucketURL()
+	u.Path = path.Join(u.Path, s.objectKey(key))
+	u.RawPath = escapePath(u.Path)
+	return u
+}
+
+// 按S3要求对路径逐段编码
+func escapePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, segment := range segments {
+		segments[i] = uriEncode(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// 按AWS签名规范排序并编码查询参数
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var pairs []string
+	for _, k := range keys {
+		values := append([]string(nil), query[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			pairs = append(pairs, uriEncode(k)+"="+uriEncode(v))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// 按AWS签名规范进行URI编码，只保留非保留字符
+func uriEncode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// 从响应头中解析对象元信息
+func objectInfoFromHeader(key string, resp *http.Response) ObjectInfo {
+	info := ObjectInfo{Key: key, Size: resp.ContentLength}
+	if modTime, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		info.ModTime = modTime
+	}
+	return info
+}
+
+// 检查响应状态码，404转换为ErrNotFound
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	var s3Err struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	if xml.Unmarshal(body, &s3Err) == nil && s3Err.Code != "" {
+		if s3Err.Code == "NoSuchKey" {
+			return ErrNotFound
+		}
+		return fmt.Errorf("S3请求失败: %d %s: %s", resp.StatusCode, s3Err.Code, s3Err.Message)
+	}
+	return fmt.Errorf("S3请求失败: %d", resp.StatusCode)
+}
+
+// 确保S3Storage实现了Storage接口
+var _ Storage = (*S3Storage)(nil)
+var _ RangeGetter = (*S3Storage)(nil)
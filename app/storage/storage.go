@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+var (
+	// ErrNotFound 对象不存在
+	ErrNotFound = errors.New("对象不存在")
+	// ErrPresignNotSupported 存储后端不支持生成预签名下载地址
+	ErrPresignNotSupported = errors.New("存储后端不支持预签名地址")
+)
+
+// ObjectInfo 表示存储对象的元信息
+type ObjectInfo struct {
+	Key     string    // 对象键，使用"/"分隔的相对路径
+	Size    int64     // 对象大小
+	ModTime time.Time // 最后修改时间
+}
+
+// Object 表示读取到的存储对象，使用完毕后需要关闭
+type Object struct {
+	io.ReadCloser
+	Info ObjectInfo
+}
+
+// Storage 版本文件存储接口，对象键使用"/"分隔，例如 apps/default/versions/1.0.0/update.zip
+type Storage interface {
+	// Put 写入对象，size为-1时表示大小未知
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+	// Get 读取对象，对象不存在时返回ErrNotFound
+	Get(ctx context.Context, key string) (*Object, error)
+	// Stat 获取对象元信息，对象不存在时返回ErrNotFound
+	Stat(ctx context.Context, key string) (ObjectInfo, error)
+	// Delete 删除对象，对象不存在时不返回错误
+	Delete(ctx context.Context, key string) error
+	// List 列出指定前缀下的所有对象
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+	// PresignGet 生成有时效的下载地址，不支持时返回ErrPresignNotSupported
+	PresignGet(ctx context.Context, key string, expires time.Duration) (string, error)
+}
+
+// RangeGetter 支持按字节范围读取对象的存储，用于在不下载整个对象的情况下响应Range请求
+type RangeGetter interface {
+	// GetRange 读取对象从offset开始的length个字节
+	GetRange(ctx context.Context, key string, offset int64, length int64) (io.ReadCloser, error)
+}
+
+// Config 存储后端配置
+type Config struct {
+	Backend string   `json:"backend"` // 存储后端：local（默认）或 s3
+	S3      S3Config `json:"s3"`      // S3兼容存储配置
+}
+
+// New 根据配置创建存储后端，本地存储以localRoot为根目录
+func New(cfg Config, localRoot string) (Storage, error) {
+	switch cfg.Backend {
+	case "", "local":
+		return NewLocalStorage(localRoot), nil
+	case "s3":
+		return NewS3Storage(cfg.S3)
+	}
+	return nil, fmt.Errorf("不支持的存储后端: %s", cfg.Backend)
+}
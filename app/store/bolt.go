@@ -0,0 +1,119 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// 保存所有文档的bucket名称
+var metadataBucket = []byte("metadata")
+
+// BoltStore 基于bbolt嵌入式数据库的存储，所有写入在事务中完成
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// OpenBoltStore 打开（不存在时创建）bolt数据库
+func OpenBoltStore(dbPath string) (*BoltStore, error) {
+	if err := os.MkdirAll(filepath.Dir(dbPath), 0755); err != nil {
+		return nil, err
+	}
+
+	db, err := bolt.Open(dbPath, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("打开元数据数据库失败: %w", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(metadataBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &BoltStore{db: db}, nil
+}
+
+// Load 读取文档
+func (s *BoltStore) Load(key string, v interface{}) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(metadataBucket).Get([]byte(key))
+		if data == nil {
+			return ErrNotFound
+		}
+		if err := json.Unmarshal(data, v); err != nil {
+			return fmt.Errorf("解析 %s 失败: %w", key, err)
+		}
+		return nil
+	})
+}
+
+// Update 在写事务中读取、修改并写回文档，bolt的写事务是串行执行的
+func (s *BoltStore) Update(key string, v interface{}, fn func() error) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(metadataBucket)
+
+		if data := bucket.Get([]byte(key)); data != nil {
+			if err := json.Unmarshal(data, v); err != nil {
+				return fmt.Errorf("解析 %s 失败: %w", key, err)
+			}
+		}
+
+		if err := fn(); err != nil {
+			return err
+		}
+
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(key), data)
+	})
+}
+
+// Delete 删除文档
+func (s *BoltStore) Delete(key string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(metadataBucket).Delete([]byte(key))
+	})
+}
+
+// List 从prefix开始遍历有序的键，列出以prefix开头的文档
+func (s *BoltStore) List(prefix string) ([]string, error) {
+	var keys []string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(metadataBucket).Cursor()
+		for k, _ := c.Seek([]byte(prefix)); k != nil && bytes.HasPrefix(k, []byte(prefix)); k, _ = c.Next() {
+			keys = append(keys, string(k))
+		}
+		return nil
+	})
+	return keys, err
+}
+
+// Close 关闭数据库
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
+
+// Empty 判断数据库中是否还没有任何文档
+func (s *BoltStore) Empty() bool {
+	empty := true
+	s.db.View(func(tx *bolt.Tx) error {
+		k, _ := tx.Bucket(metadataBucket).Cursor().First()
+		empty = k == nil
+		return nil
+	})
+	return empty
+}
+
+// 确保BoltStore实现了Store接口
+var _ Store = (*BoltStore)(nil)
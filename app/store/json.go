@@ -0,0 +1,200 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"hotupdate/app/utils"
+)
+
+// JSONStore 以JSON文件保存文档的存储，键 "apps/default/versions" 对应文件 <root>/apps/default/versions.json
+type JSONStore struct {
+	root string
+
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+// NewJSONStore 创建以root为根目录的JSON文件存储
+func NewJSONStore(root string) *JSONStore {
+	return &JSONStore{
+		root:  root,
+		locks: make(map[string]*sync.Mutex),
+	}
+}
+
+// Path 获取键对应的文件路径，键会解析到根目录之外时返回utils.ErrUnsafePath
+func (s *JSONStore) Path(key string) (string, error) {
+	return utils.SafeJoin(s.root, key+".json")
+}
+
+// Load 读取文档。写入使用原子重命名，读取时不会读到写了一半的文件，因此无需加锁
+func (s *JSONStore) Load(key string, v interface{}) error {
+	filePath, err := s.Path(key)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("解析 %s 失败: %w", key, err)
+	}
+	return nil
+}
+
+// Update 在键锁内读取、修改并原子写回文档
+func (s *JSONStore) Update(key string, v interface{}, fn func() error) error {
+	lock := s.lock(key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if err := s.Load(key, v); err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+
+	if err := fn(); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	filePath, err := s.Path(key)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filePath, data, 0600)
+}
+
+// Delete 删除文档
+func (s *JSONStore) Delete(key string) error {
+	lock := s.lock(key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	filePath, err := s.Path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(filePath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// List 遍历前缀所在的目录，列出以prefix开头的文档。不包含写入中的临时文件
+func (s *JSONStore) List(prefix string) ([]string, error) {
+	dir := ""
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		dir = prefix[:i]
+	}
+	dirPath, err := utils.SafeJoin(s.root, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	err = filepath.WalkDir(dirPath, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && filePath == dirPath {
+				return filepath.SkipAll
+			}
+			return err
+		}
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, ".") || !strings.HasSuffix(name, ".json") {
+			return nil
+		}
+		rel, err := filepath.Rel(s.root, filePath)
+		if err != nil {
+			return err
+		}
+		if key := strings.TrimSuffix(filepath.ToSlash(rel), ".json"); strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Close JSON存储无需释放资源
+func (s *JSONStore) Close() error {
+	return nil
+}
+
+// 获取键对应的互斥锁
+func (s *JSONStore) lock(key string) *sync.Mutex {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lock, ok := s.locks[key]
+	if !ok {
+		lock = &sync.Mutex{}
+		s.locks[key] = lock
+	}
+	return lock
+}
+
+// 原子写入文件：先写入同目录下的临时文件并同步到磁盘，再重命名覆盖目标文件，
+// 进程在任意时刻崩溃都只会留下旧文件或新文件，不会留下写了一半的文件
+func writeFileAtomic(filePath string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(filePath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(filePath)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpPath, perm); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, filePath); err != nil {
+		return err
+	}
+
+	// 同步目录，确保重命名本身已落盘
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// 确保JSONStore实现了Store接口
+var _ Store = (*JSONStore)(nil)
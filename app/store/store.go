@@ -0,0 +1,47 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+)
+
+// ErrNotFound 文档不存在
+var ErrNotFound = errors.New("文档不存在")
+
+// Store 元数据存储接口。每个文档以"/"分隔的键标识（如 "apps"、"apps/default/versions"），
+// 以JSON形式保存。同一个键上的Update互斥执行，保证"读取-修改-写入"不会丢失并发更新。
+type Store interface {
+	// Load 读取文档到v，文档不存在时返回ErrNotFound
+	Load(key string, v interface{}) error
+	// Update 持有该键的锁读取文档到v（不存在时保持v不变），调用fn修改v后原子写回；
+	// fn返回错误时放弃写入并返回该错误
+	Update(key string, v interface{}, fn func() error) error
+	// List 列出以prefix开头的全部键，按字典序排列
+	List(prefix string) ([]string, error)
+	// Delete 删除文档，文档不存在时不返回错误
+	Delete(key string) error
+	// Close 释放存储占用的资源
+	Close() error
+}
+
+// Config 元数据存储配置
+type Config struct {
+	Backend  string `json:"backend"`  // 存储后端：json（默认）或 bolt
+	BoltPath string `json:"boltPath"` // bolt数据库文件路径，默认为上传目录下的 metadata.db
+}
+
+// New 根据配置创建元数据存储，JSON存储以root为根目录
+func New(cfg Config, root string) (Store, error) {
+	switch cfg.Backend {
+	case "", "json":
+		return NewJSONStore(root), nil
+	case "bolt":
+		boltPath := cfg.BoltPath
+		if boltPath == "" {
+			boltPath = filepath.Join(root, "metadata.db")
+		}
+		return OpenBoltStore(boltPath)
+	}
+	return nil, fmt.Errorf("不支持的元数据存储后端: %s", cfg.Backend)
+}
@@ -0,0 +1,85 @@
+package store
+
+import (
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+)
+
+func TestList(t *testing.T) {
+	dir := t.TempDir()
+	bolt, err := OpenBoltStore(filepath.Join(dir, "metadata.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bolt.Close()
+
+	for name, s := range map[string]Store{"json": NewJSONStore(filepath.Join(dir, "json")), "bolt": bolt} {
+		t.Run(name, func(t *testing.T) {
+			for _, key := range []string{"apps", "apps/a/versions", "apps/a/analytics/2024-05-02", "apps/a/analytics/2024-05-01", "apps/ab/analytics/2024-05-01"} {
+				if err := s.Update(key, &map[string]int{}, func() error { return nil }); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			for prefix, want := range map[string][]string{
+				"apps/a/analytics/": {"apps/a/analytics/2024-05-01", "apps/a/analytics/2024-05-02"},
+				"apps/a/":           {"apps/a/analytics/2024-05-01", "apps/a/analytics/2024-05-02", "apps/a/versions"},
+				"apps/c/":           nil,
+				"":                  {"apps", "apps/a/analytics/2024-05-01", "apps/a/analytics/2024-05-02", "apps/a/versions", "apps/ab/analytics/2024-05-01"},
+			} {
+				got, err := s.List(prefix)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !slices.Equal(got, want) {
+					t.Errorf("List(%q) = %v，期望 %v", prefix, got, want)
+				}
+			}
+		})
+	}
+}
+
+// 并发Update同一个键时每次修改都基于上一次保存的结果，不丢失更新
+func TestConcurrentUpdate(t *testing.T) {
+	dir := t.TempDir()
+	bolt, err := OpenBoltStore(filepath.Join(dir, "metadata.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bolt.Close()
+
+	const workers, increments = 8, 25
+	for name, s := range map[string]Store{"json": NewJSONStore(filepath.Join(dir, "json")), "bolt": bolt} {
+		t.Run(name, func(t *testing.T) {
+			var wg sync.WaitGroup
+			for i := 0; i < workers; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for j := 0; j < increments; j++ {
+						var counter struct{ N int }
+						err := s.Update("counter", &counter, func() error {
+							counter.N++
+							return nil
+						})
+						if err != nil {
+							t.Error(err)
+							return
+						}
+					}
+				}()
+			}
+			wg.Wait()
+
+			var counter struct{ N int }
+			if err := s.Load("counter", &counter); err != nil {
+				t.Fatal(err)
+			}
+			if counter.N != workers*increments {
+				t.Errorf("计数为 %d，期望 %d", counter.N, workers*increments)
+			}
+		})
+	}
+}
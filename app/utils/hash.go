@@ -0,0 +1,66 @@
+package utils
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+)
+
+// Hasher 在一次读取中同时计算文件的SHA-256、MD5和CRC32，配合io.TeeReader在上传时流式计算
+type Hasher struct {
+	sha256 hash.Hash
+	md5    hash.Hash
+	crc32  hash.Hash32
+	size   int64
+}
+
+// NewHasher 创建哈希计算器
+func NewHasher() *Hasher {
+	return &Hasher{
+		sha256: sha256.New(),
+		md5:    md5.New(),
+		crc32:  crc32.NewIEEE(),
+	}
+}
+
+// Write 写入数据，实现io.Writer
+func (h *Hasher) Write(p []byte) (int, error) {
+	h.sha256.Write(p)
+	h.md5.Write(p)
+	h.crc32.Write(p)
+	h.size += int64(len(p))
+	return len(p), nil
+}
+
+// Size 已写入的字节数
+func (h *Hasher) Size() int64 {
+	return h.size
+}
+
+// SHA256 SHA-256的十六进制表示
+func (h *Hasher) SHA256() string {
+	return hex.EncodeToString(h.sha256.Sum(nil))
+}
+
+// MD5 MD5的十六进制表示
+func (h *Hasher) MD5() string {
+	return hex.EncodeToString(h.md5.Sum(nil))
+}
+
+// CRC32 CRC32（IEEE）的8位十六进制表示
+func (h *Hasher) CRC32() string {
+	return fmt.Sprintf("%08x", h.crc32.Sum32())
+}
+
+// DigestHeader 根据十六进制哈希生成Digest响应头（RFC 3230），如 "sha-256=<base64>"
+func DigestHeader(algorithm string, hexSum string) string {
+	sum, err := hex.DecodeString(hexSum)
+	if err != nil || len(sum) == 0 {
+		return ""
+	}
+	return algorithm + "=" + base64.StdEncoding.EncodeToString(sum)
+}
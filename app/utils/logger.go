@@ -1,98 +1,112 @@
 package utils
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"os"
-	"path/filepath"
-	"time"
+	"strings"
 )
 
-// LogLevelType 日志级别类型
-type LogLevelType int
+// LogConfig 日志配置
+type LogConfig struct {
+	Level      string `json:"level"`      // 日志级别：debug、info、warn、error，默认info
+	MaxSizeMB  int    `json:"maxSizeMB"`  // 单个日志文件的最大大小（MB），超过后轮转，默认100
+	MaxAgeDays int    `json:"maxAgeDays"` // 轮转后的日志文件保留天数，默认30
+	MaxBackups int    `json:"maxBackups"` // 最多保留的轮转日志文件数，0表示只按天数清理
+}
 
+// 日志配置的默认值
 const (
-	DEBUG LogLevelType = iota
-	INFO
-	WARNING
-	ERROR
-	FATAL
+	DefaultLogMaxSizeMB  = 100
+	DefaultLogMaxAgeDays = 30
 )
 
-var (
-	logFile     *os.File
-	LogLevel    = INFO
-	initialized = false
-)
+// LogLevel 当前日志级别，可以在运行时调整
+var LogLevel = new(slog.LevelVar)
 
-// InitLogger 初始化日志系统
-func InitLogger(logDir string, prefix string) error {
-	if initialized {
-		return nil
-	}
+var logWriter *RotatingWriter
 
-	// 确保日志目录存在
-	if err := os.MkdirAll(logDir, 0755); err != nil {
-		return fmt.Errorf("无法创建日志目录: %v", err)
+// ParseLogLevel 解析日志级别，空字符串为info
+func ParseLogLevel(level string) (slog.Level, error) {
+	if level == "" {
+		return slog.LevelInfo, nil
 	}
+	var parsed slog.Level
+	if err := parsed.UnmarshalText([]byte(strings.TrimSpace(level))); err != nil {
+		return slog.LevelInfo, fmt.Errorf("无效的日志级别 %q，可选值：debug、info、warn、error", level)
+	}
+	return parsed, nil
+}
 
-	// 生成日志文件名
-	timestamp := time.Now().Format("2006-01-02")
-	logFileName := fmt.Sprintf("server_%s.log", timestamp)
-	logFilePath := filepath.Join(logDir, logFileName)
-
-	// 打开日志文件
-	f, err := os.OpenFile(logFilePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+// InitLogger 初始化结构化日志：JSON格式同时输出到标准输出和logDir下按大小和日期轮转的日志文件，
+// 并设为slog和log包的默认日志。日志目录不可用时只输出到标准输出，返回的错误说明原因
+func InitLogger(logDir string, cfg LogConfig) error {
+	level, err := ParseLogLevel(cfg.Level)
 	if err != nil {
-		return fmt.Errorf("无法打开日志文件: %v", err)
+		return err
 	}
+	LogLevel.Set(level)
 
-	logFile = f
-	log.SetOutput(f)
-	log.SetPrefix(prefix + " ")
+	if cfg.MaxSizeMB <= 0 {
+		cfg.MaxSizeMB = DefaultLogMaxSizeMB
+	}
+	if cfg.MaxAgeDays <= 0 {
+		cfg.MaxAgeDays = DefaultLogMaxAgeDays
+	}
 
-	initialized = true
+	var output io.Writer = os.Stdout
+	writer, openErr := NewRotatingWriter(logDir, "server", int64(cfg.MaxSizeMB)<<20, cfg.MaxAgeDays, cfg.MaxBackups)
+	if openErr == nil {
+		logWriter = writer
+		output = io.MultiWriter(writer, os.Stdout)
+	}
 
-	log.Println("日志系统已初始化")
-	return nil
+	handler := slog.NewJSONHandler(output, &slog.HandlerOptions{AddSource: true, Level: LogLevel})
+	slog.SetDefault(slog.New(contextHandler{handler}))
+	return openErr
 }
 
-// CloseLogger 关闭日志系统
+// CloseLogger 关闭日志文件
 func CloseLogger() {
-	if logFile != nil {
-		logFile.Close()
+	if logWriter != nil {
+		logWriter.Close()
 	}
 }
 
-// Debug 记录调试级别日志
-func Debug(format string, v ...interface{}) {
-	if LogLevel <= DEBUG {
-		log.Printf("[DEBUG] "+format, v...)
-	}
+// RequestIDHeader 请求ID的请求头和响应头
+const RequestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// WithRequestID 在上下文中记录请求ID，使用该上下文记录的日志都带有request_id字段
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, requestID)
 }
 
-// Info 记录信息级别日志
-func Info(format string, v ...interface{}) {
-	if LogLevel <= INFO {
-		log.Printf("[INFO] "+format, v...)
-	}
+// RequestID 获取上下文中的请求ID，没有时为空
+func RequestID(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
 }
 
-// Warning 记录警告级别日志
-func Warning(format string, v ...interface{}) {
-	if LogLevel <= WARNING {
-		log.Printf("[WARNING] "+format, v...)
-	}
+// contextHandler 为日志添加上下文中的请求ID
+type contextHandler struct {
+	slog.Handler
 }
 
-// Error 记录错误级别日志
-func Error(format string, v ...interface{}) {
-	if LogLevel <= ERROR {
-		log.Printf("[ERROR] "+format, v...)
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if requestID := RequestID(ctx); requestID != "" {
+		record.AddAttrs(slog.String("request_id", requestID))
 	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
 }
 
-// Fatal 记录致命错误级别日志并终止程序
-func Fatal(format string, v ...interface{}) {
-	log.Fatalf("[FATAL] "+format, v...)
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
 }
@@ -0,0 +1,171 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RotatingWriter 按大小和日期轮转的日志文件。当前日志写入 {name}.log，
+// 超过最大大小或跨天时重命名为 {name}-{日期}.log（同一天多次轮转时为 {name}-{日期}.{序号}.log），
+// 并删除超过保留天数或超出保留个数的旧文件
+type RotatingWriter struct {
+	mu         sync.Mutex
+	dir        string
+	name       string
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+
+	file *os.File
+	size int64
+	date string // 当前日志文件开始写入的日期
+}
+
+// NewRotatingWriter 创建轮转日志文件，maxBackups为0时只按maxAgeDays清理
+func NewRotatingWriter(dir string, name string, maxSize int64, maxAgeDays int, maxBackups int) (*RotatingWriter, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("无法创建日志目录: %v", err)
+	}
+	w := &RotatingWriter{
+		dir:        dir,
+		name:       name,
+		maxSize:    maxSize,
+		maxAge:     time.Duration(maxAgeDays) * 24 * time.Hour,
+		maxBackups: maxBackups,
+	}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	w.cleanup()
+	return w, nil
+}
+
+// Write 写入一条日志，写入前按需轮转
+func (w *RotatingWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return 0, os.ErrClosed
+	}
+	today := time.Now().Format("2006-01-02")
+	if w.date != today || (w.size > 0 && w.size+int64(len(p)) > w.maxSize) {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// Close 关闭当前日志文件
+func (w *RotatingWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+// 当前日志文件的路径
+func (w *RotatingWriter) current() string {
+	return filepath.Join(w.dir, w.name+".log")
+}
+
+// 打开当前日志文件，上次运行留下的文件不是今天写入的则先轮转
+func (w *RotatingWriter) open() error {
+	path := w.current()
+	today := time.Now().Format("2006-01-02")
+	if info, err := os.Stat(path); err == nil {
+		if date := info.ModTime().Format("2006-01-02"); date != today && info.Size() > 0 {
+			if err := os.Rename(path, w.backupPath(date)); err != nil {
+				return fmt.Errorf("无法轮转日志文件: %v", err)
+			}
+		}
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("无法打开日志文件: %v", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("无法读取日志文件信息: %v", err)
+	}
+	w.file = f
+	w.size = info.Size()
+	w.date = today
+	return nil
+}
+
+// 将当前日志文件重命名为按日期命名的备份并重新打开，调用方需持有mu
+func (w *RotatingWriter) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	w.file = nil
+	if err := os.Rename(w.current(), w.backupPath(w.date)); err != nil {
+		return fmt.Errorf("无法轮转日志文件: %v", err)
+	}
+	if err := w.open(); err != nil {
+		return err
+	}
+	go w.cleanup()
+	return nil
+}
+
+// 获取某天尚未使用的备份文件路径
+func (w *RotatingWriter) backupPath(date string) string {
+	path := filepath.Join(w.dir, fmt.Sprintf("%s-%s.log", w.name, date))
+	for i := 1; ; i++ {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return path
+		}
+		path = filepath.Join(w.dir, fmt.Sprintf("%s-%s.%d.log", w.name, date, i))
+	}
+}
+
+// 删除超过保留天数或超出保留个数的备份文件，包括旧版本按天命名的 {name}_{日期}.log
+func (w *RotatingWriter) cleanup() {
+	entries, err := os.ReadDir(w.dir)
+	if err != nil {
+		return
+	}
+
+	type backup struct {
+		path    string
+		modTime time.Time
+	}
+	var backups []backup
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".log") ||
+			!(strings.HasPrefix(name, w.name+"-") || strings.HasPrefix(name, w.name+"_")) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		backups = append(backups, backup{filepath.Join(w.dir, name), info.ModTime()})
+	}
+
+	// 从新到旧排列
+	sort.Slice(backups, func(i, j int) bool { return backups[i].modTime.After(backups[j].modTime) })
+	cutoff := time.Now().Add(-w.maxAge)
+	for i, b := range backups {
+		if (w.maxAge > 0 && b.modTime.Before(cutoff)) || (w.maxBackups > 0 && i >= w.maxBackups) {
+			os.Remove(b.path)
+		}
+	}
+}
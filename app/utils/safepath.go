@@ -0,0 +1,30 @@
+package utils
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+)
+
+// ErrUnsafePath 路径会解析到根目录之外
+var ErrUnsafePath = errors.New("路径不安全")
+
+// SafeJoin 将以/分隔的相对路径拼接到root下。rel为绝对路径、包含..、反斜杠或空字符，
+// 或拼接后不在root之内时返回ErrUnsafePath；rel为空时返回root
+func SafeJoin(root string, rel string) (string, error) {
+	if strings.ContainsAny(rel, "\\\x00") || strings.HasPrefix(rel, "/") || filepath.IsAbs(rel) || filepath.VolumeName(rel) != "" {
+		return "", ErrUnsafePath
+	}
+	for _, part := range strings.Split(rel, "/") {
+		if part == ".." {
+			return "", ErrUnsafePath
+		}
+	}
+
+	root = filepath.Clean(root)
+	joined := filepath.Join(root, filepath.FromSlash(rel))
+	if joined != root && !strings.HasPrefix(joined, root+string(filepath.Separator)) {
+		return "", ErrUnsafePath
+	}
+	return joined, nil
+}
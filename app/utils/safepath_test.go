@@ -0,0 +1,39 @@
+package utils
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func FuzzSafeJoin(f *testing.F) {
+	for _, seed := range []string{
+		"", "apps/game/versions/1.0.0/update.zip", "a/./b", "a//b", "a/",
+		"..", "../etc/passwd", "a/../../b", "a/..", "/etc/passwd", `a\..\..\b`, "C:/Windows", "a\x00b", "....//....",
+	} {
+		f.Add(seed)
+	}
+
+	root := filepath.Join(f.TempDir(), "uploads")
+	f.Fuzz(func(t *testing.T, rel string) {
+		joined, err := SafeJoin(root, rel)
+		if err != nil {
+			if !errors.Is(err, ErrUnsafePath) {
+				t.Fatalf("SafeJoin(%q) 返回了意外的错误 %v", rel, err)
+			}
+			return
+		}
+
+		// 结果必须是root本身或root之下的路径
+		inside, err := filepath.Rel(root, joined)
+		if err != nil || inside == ".." || strings.HasPrefix(inside, ".."+string(filepath.Separator)) || filepath.IsAbs(inside) {
+			t.Fatalf("SafeJoin(%q) = %q，不在 %q 之内", rel, joined, root)
+		}
+		for _, part := range strings.Split(rel, "/") {
+			if part == ".." {
+				t.Fatalf("SafeJoin(%q) 接受了包含..的路径", rel)
+			}
+		}
+	})
+}
@@ -0,0 +1,160 @@
+package utils
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// SemVer 表示一个语义化版本号（SemVer 2.0）
+type SemVer struct {
+	Core       []uint64 // 主版本号、次版本号、补丁版本号，宽松解析时可能多于或少于三段
+	PreRelease []string // 先行版本标识，如 beta.2 解析为 ["beta", "2"]
+	Build      string   // 构建元数据，不参与比较
+}
+
+// ParseSemVer 按SemVer 2.0严格解析版本号：必须是X.Y.Z三段，数字不能有前导零
+func ParseSemVer(s string) (SemVer, error) {
+	return parseSemVer(s, true)
+}
+
+// ParseSemVerLoose 宽松解析版本号，兼容旧客户端和旧数据：允许v前缀、任意段数和前导零
+func ParseSemVerLoose(s string) (SemVer, error) {
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "v"), "V")
+	return parseSemVer(s, false)
+}
+
+func parseSemVer(s string, strict bool) (SemVer, error) {
+	var v SemVer
+
+	rest, build, hasBuild := strings.Cut(s, "+")
+	if hasBuild {
+		if !validIdentifiers(build, false) {
+			return SemVer{}, errors.New("构建元数据格式无效")
+		}
+		v.Build = build
+	}
+
+	core, pre, hasPre := strings.Cut(rest, "-")
+	if hasPre {
+		if !validIdentifiers(pre, strict) {
+			return SemVer{}, errors.New("先行版本号格式无效")
+		}
+		v.PreRelease = strings.Split(pre, ".")
+	}
+
+	parts := strings.Split(core, ".")
+	if strict && len(parts) != 3 {
+		return SemVer{}, errors.New("版本号必须是 主版本号.次版本号.补丁版本号 格式")
+	}
+	for _, part := range parts {
+		if !isNumeric(part) {
+			return SemVer{}, errors.New("版本号的每一段必须是数字")
+		}
+		if strict && len(part) > 1 && part[0] == '0' {
+			return SemVer{}, errors.New("版本号数字不能有前导零")
+		}
+		n, err := strconv.ParseUint(part, 10, 64)
+		if err != nil {
+			return SemVer{}, errors.New("版本号数字超出范围")
+		}
+		v.Core = append(v.Core, n)
+	}
+	return v, nil
+}
+
+// 校验以"."分隔的标识符：只能包含字母、数字和横线且不能为空；
+// 先行版本的数字标识符在严格模式下不能有前导零
+func validIdentifiers(s string, noLeadingZero bool) bool {
+	for _, id := range strings.Split(s, ".") {
+		if id == "" {
+			return false
+		}
+		for _, r := range id {
+			if !(r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r == '-') {
+				return false
+			}
+		}
+		if noLeadingZero && isNumeric(id) && len(id) > 1 && id[0] == '0' {
+			return false
+		}
+	}
+	return true
+}
+
+// 判断字符串是否为非空的纯数字
+func isNumeric(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Compare 按SemVer 2.0的优先级比较版本号，返回-1、0或1。构建元数据不参与比较
+func (v SemVer) Compare(other SemVer) int {
+	// 逐段比较数字部分，段数不同时缺少的段视为0
+	for i := 0; i < len(v.Core) || i < len(other.Core); i++ {
+		var a, b uint64
+		if i < len(v.Core) {
+			a = v.Core[i]
+		}
+		if i < len(other.Core) {
+			b = other.Core[i]
+		}
+		if a != b {
+			return compareUint(a, b)
+		}
+	}
+
+	// 有先行版本号的版本优先级低于正式版本
+	switch {
+	case len(v.PreRelease) == 0 && len(other.PreRelease) == 0:
+		return 0
+	case len(v.PreRelease) == 0:
+		return 1
+	case len(other.PreRelease) == 0:
+		return -1
+	}
+
+	for i := 0; i < len(v.PreRelease) && i < len(other.PreRelease); i++ {
+		if c := comparePreRelease(v.PreRelease[i], other.PreRelease[i]); c != 0 {
+			return c
+		}
+	}
+	// 前面的标识符都相同时，标识符多的优先级高
+	return compareUint(uint64(len(v.PreRelease)), uint64(len(other.PreRelease)))
+}
+
+// 比较先行版本标识符：数字标识符按数值比较且低于字母标识符，字母标识符按ASCII顺序比较
+func comparePreRelease(a string, b string) int {
+	aNumeric, bNumeric := isNumeric(a), isNumeric(b)
+	switch {
+	case aNumeric && bNumeric:
+		// 去掉前导零后先比较长度，避免超出整数范围
+		a, b = strings.TrimLeft(a, "0"), strings.TrimLeft(b, "0")
+		if len(a) != len(b) {
+			return compareUint(uint64(len(a)), uint64(len(b)))
+		}
+		return strings.Compare(a, b)
+	case aNumeric:
+		return -1
+	case bNumeric:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+func compareUint(a uint64, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,112 @@
+package utils
+
+import "testing"
+
+func TestParseSemVer(t *testing.T) {
+	tests := []struct {
+		version string
+		ok      bool
+	}{
+		{"1.2.3", true},
+		{"0.0.0", true},
+		{"1.2.3-beta.2", true},
+		{"1.2.3-0.3.7", true},
+		{"1.2.3-x-y-z.--", true},
+		{"1.2.3+build.5", true},
+		{"1.2.3-rc.1+0001", true}, // 构建元数据允许前导零
+		{"1.2", false},
+		{"1.2.3.4", false},
+		{"v1.2.3", false},
+		{"01.2.3", false},
+		{"1.02.3", false},
+		{"1.2.03", false},
+		{"1.2.3-01", false}, // 数字先行标识符不能有前导零
+		{"1.2.3-", false},
+		{"1.2.3-beta..1", false},
+		{"1.2.3-beta.", false},
+		{"1.2.3+", false},
+		{"1.2.3+a..b", false},
+		{"1.2.3-beta_1", false},
+		{"1.2.x", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if _, err := ParseSemVer(tt.version); (err == nil) != tt.ok {
+			t.Errorf("ParseSemVer(%q) 错误为 %v，期望通过 %v", tt.version, err, tt.ok)
+		}
+	}
+}
+
+func TestParseSemVerLoose(t *testing.T) {
+	for _, version := range []string{"v1.2.3", "V1.2", "1", "1.2.3.4", "01.02.03"} {
+		if _, err := ParseSemVerLoose(version); err != nil {
+			t.Errorf("ParseSemVerLoose(%q) 返回错误 %v", version, err)
+		}
+	}
+	for _, version := range []string{"", "v", "1..2", "1.2.x", "1.2.3-"} {
+		if _, err := ParseSemVerLoose(version); err == nil {
+			t.Errorf("ParseSemVerLoose(%q) 应返回错误", version)
+		}
+	}
+}
+
+func TestSemVerCompare(t *testing.T) {
+	// SemVer 2.0规范中的优先级示例，按从低到高排列
+	ordered := []string{
+		"1.0.0-alpha",
+		"1.0.0-alpha.1",
+		"1.0.0-alpha.beta",
+		"1.0.0-beta",
+		"1.0.0-beta.2",
+		"1.0.0-beta.11",
+		"1.0.0-rc.1",
+		"1.0.0",
+		"1.0.1",
+		"1.1.0",
+		"2.0.0",
+		"10.0.0",
+	}
+	for i := range ordered {
+		for j := range ordered {
+			a, _ := ParseSemVer(ordered[i])
+			b, _ := ParseSemVer(ordered[j])
+			want := compareUint(uint64(i), uint64(j))
+			if got := a.Compare(b); got != want {
+				t.Errorf("Compare(%s, %s) = %d，期望 %d", ordered[i], ordered[j], got, want)
+			}
+		}
+	}
+
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		// 构建元数据不参与比较
+		{"1.0.0+build.1", "1.0.0+build.2", 0},
+		{"1.0.0-rc.1+a", "1.0.0-rc.1", 0},
+		// 数字标识符按数值比较，且低于字母标识符
+		{"1.0.0-2", "1.0.0-10", -1},
+		{"1.0.0-99999999999999999999", "1.0.0-100000000000000000000", -1},
+		{"1.0.0-1", "1.0.0-a", -1},
+		{"1.0.0-a1", "1.0.0-1a", 1},
+		// 宽松格式中缺少的段视为0
+		{"1.0", "1.0.0", 0},
+		{"1.2", "1.1.9", 1},
+	}
+	for _, tt := range tests {
+		a, err := ParseSemVerLoose(tt.a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ParseSemVerLoose(tt.b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := a.Compare(b); got != tt.want {
+			t.Errorf("Compare(%s, %s) = %d，期望 %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := b.Compare(a); got != -tt.want {
+			t.Errorf("Compare(%s, %s) = %d，期望 %d", tt.b, tt.a, got, -tt.want)
+		}
+	}
+}
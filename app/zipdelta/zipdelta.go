@@ -0,0 +1,406 @@
+// Package zipdelta 生成和应用ZIP更新包之间的按条目差分包。
+//
+// 差分包按新更新包的字节顺序记录一组操作：内容未变的条目从旧更新包复制，内容变化的条目
+// 对压缩后的数据做bsdiff，其余部分（新增的条目、本地文件头和中央目录）直接写入。
+// 生成时每次只把一个变化的条目读入内存，更新包的大小不受内存限制。
+//
+// 格式：8字节魔数"HUDELTA1"、8字节新更新包大小，之后是若干操作，以操作码'E'结束。
+// 所有整数均为大端序的8字节无符号数：
+//
+//	'C' 旧文件偏移 长度                    从旧更新包复制
+//	'D' 长度 数据                          直接写入数据
+//	'P' 旧文件偏移 旧长度 新长度 补丁长度 补丁  对旧更新包的一段应用bsdiff补丁（BSDIFF40格式）
+//	'E'                                    结束
+package zipdelta
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/gabstv/go-bsdiff/pkg/bsdiff"
+	"github.com/gabstv/go-bsdiff/pkg/bspatch"
+)
+
+// Format 差分包格式名称，检查更新的响应中返回给客户端
+const Format = "zipdelta"
+
+const magic = "HUDELTA1"
+
+// 操作码
+const (
+	opCopy  = 'C'
+	opData  = 'D'
+	opPatch = 'P'
+	opEnd   = 'E'
+)
+
+// 比较条目内容时每次读取的大小
+const compareChunk = 64 << 10
+
+// Stats 差分包的组成，用于记录日志
+type Stats struct {
+	CopiedBytes  int64 // 从旧更新包复制的字节数
+	PatchedBytes int64 // 通过bsdiff补丁生成的字节数
+	LiteralBytes int64 // 直接写入的字节数
+	PatchSize    int64 // 补丁数据的总大小
+	Changed      int   // 内容变化或新增的条目数
+}
+
+// Build 生成从旧更新包到新更新包的差分包并写入w。
+// maxMemory为对单个条目做bsdiff时允许使用的内存，超过时该条目直接写入，估算方法见DiffMemory
+func Build(w io.Writer, old io.ReaderAt, oldSize int64, updated io.ReaderAt, newSize int64, maxMemory int64) (Stats, error) {
+	oldZip, err := zip.NewReader(old, oldSize)
+	if err != nil {
+		return Stats{}, fmt.Errorf("无法读取旧更新包: %w", err)
+	}
+	newZip, err := zip.NewReader(updated, newSize)
+	if err != nil {
+		return Stats{}, fmt.Errorf("无法读取新更新包: %w", err)
+	}
+
+	oldFiles := make(map[string]*zip.File, len(oldZip.File))
+	for _, file := range oldZip.File {
+		if _, exists := oldFiles[file.Name]; !exists {
+			oldFiles[file.Name] = file
+		}
+	}
+
+	// 按数据在文件中的位置处理新更新包的条目
+	type entry struct {
+		file   *zip.File
+		offset int64
+	}
+	entries := make([]entry, 0, len(newZip.File))
+	for _, file := range newZip.File {
+		offset, err := file.DataOffset()
+		if err != nil {
+			return Stats{}, fmt.Errorf("无法读取新更新包的文件 %s: %w", file.Name, err)
+		}
+		entries = append(entries, entry{file: file, offset: offset})
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].offset < entries[j].offset })
+
+	b := &builder{w: bufio.NewWriter(w), old: old, updated: updated}
+	b.writeHeader(newSize)
+
+	cursor := int64(0)
+	for _, e := range entries {
+		length := int64(e.file.CompressedSize64)
+		// 数据与之前的条目重叠的损坏文件，重叠部分已经作为其他内容写入
+		if e.offset < cursor || e.offset+length > newSize {
+			continue
+		}
+		b.literal(cursor, e.offset-cursor)
+		cursor = e.offset + length
+		if length == 0 {
+			continue
+		}
+
+		oldFile, exists := oldFiles[e.file.Name]
+		if !exists {
+			b.stats.Changed++
+			b.literal(e.offset, length)
+			continue
+		}
+		oldOffset, err := oldFile.DataOffset()
+		if err != nil {
+			return Stats{}, fmt.Errorf("无法读取旧更新包的文件 %s: %w", oldFile.Name, err)
+		}
+		oldLength := int64(oldFile.CompressedSize64)
+		if oldOffset+oldLength > oldSize {
+			b.stats.Changed++
+			b.literal(e.offset, length)
+			continue
+		}
+
+		if sameHeader(oldFile, e.file) {
+			equal, err := sameContent(old, oldOffset, updated, e.offset, length)
+			if err != nil {
+				return Stats{}, err
+			}
+			if equal {
+				b.copy(oldOffset, length)
+				continue
+			}
+		}
+
+		b.stats.Changed++
+		if DiffMemory(oldLength, length) > maxMemory {
+			b.literal(e.offset, length)
+			continue
+		}
+		if err := b.patch(oldOffset, oldLength, e.offset, length); err != nil {
+			return Stats{}, err
+		}
+	}
+	b.literal(cursor, newSize-cursor)
+	b.end()
+
+	if b.err != nil {
+		return Stats{}, b.err
+	}
+	return b.stats, b.w.Flush()
+}
+
+// DiffMemory 估算bsdiff比较一个条目的峰值内存：旧数据及其两个后缀数组（每个字节各8字节），
+// 新数据、差异块和额外块，以及不超过新数据大小的补丁
+func DiffMemory(oldSize int64, newSize int64) int64 {
+	return 17*oldSize + 4*newSize
+}
+
+// 压缩方式、压缩前后大小和CRC32都相同的条目可能未变化，需再比较数据确认
+func sameHeader(a *zip.File, b *zip.File) bool {
+	return a.Method == b.Method && a.CRC32 == b.CRC32 &&
+		a.CompressedSize64 == b.CompressedSize64 && a.UncompressedSize64 == b.UncompressedSize64
+}
+
+// 逐块比较两个文件中的两段数据
+func sameContent(a io.ReaderAt, aOffset int64, b io.ReaderAt, bOffset int64, length int64) (bool, error) {
+	bufA := make([]byte, compareChunk)
+	bufB := make([]byte, compareChunk)
+	for done := int64(0); done < length; {
+		n := int(min(compareChunk, length-done))
+		if _, err := a.ReadAt(bufA[:n], aOffset+done); err != nil {
+			return false, err
+		}
+		if _, err := b.ReadAt(bufB[:n], bOffset+done); err != nil {
+			return false, err
+		}
+		if !bytes.Equal(bufA[:n], bufB[:n]) {
+			return false, nil
+		}
+		done += int64(n)
+	}
+	return true, nil
+}
+
+// builder 写入差分包，合并相邻的复制和直接写入操作。出错后忽略之后的写入，错误记录在err中
+type builder struct {
+	w       *bufio.Writer
+	old     io.ReaderAt
+	updated io.ReaderAt
+	stats   Stats
+	err     error
+
+	// 尚未写入的操作：新更新包中待直接写入的一段，或旧更新包中待复制的一段
+	literalOffset, literalLength int64
+	copyOffset, copyLength       int64
+}
+
+func (b *builder) writeHeader(newSize int64) {
+	b.w.WriteString(magic)
+	b.writeInt(newSize)
+}
+
+func (b *builder) writeInt(values ...int64) {
+	var buf [8]byte
+	for _, v := range values {
+		binary.BigEndian.PutUint64(buf[:], uint64(v))
+		if _, err := b.w.Write(buf[:]); err != nil && b.err == nil {
+			b.err = err
+		}
+	}
+}
+
+// 直接写入新更新包中的一段
+func (b *builder) literal(offset int64, length int64) {
+	if length <= 0 {
+		return
+	}
+	b.flushCopy()
+	if b.literalLength > 0 && b.literalOffset+b.literalLength == offset {
+		b.literalLength += length
+		return
+	}
+	b.flushLiteral()
+	b.literalOffset, b.literalLength = offset, length
+}
+
+// 从旧更新包复制一段
+func (b *builder) copy(offset int64, length int64) {
+	b.flushLiteral()
+	if b.copyLength > 0 && b.copyOffset+b.copyLength == offset {
+		b.copyLength += length
+		return
+	}
+	b.flushCopy()
+	b.copyOffset, b.copyLength = offset, length
+}
+
+// 对变化的条目生成补丁，补丁不比新数据小时直接写入
+func (b *builder) patch(oldOffset int64, oldLength int64, newOffset int64, newLength int64) error {
+	oldData := make([]byte, oldLength)
+	if _, err := b.old.ReadAt(oldData, oldOffset); err != nil {
+		return err
+	}
+	newData := make([]byte, newLength)
+	if _, err := b.updated.ReadAt(newData, newOffset); err != nil {
+		return err
+	}
+	patch, err := bsdiff.Bytes(oldData, newData)
+	if err != nil {
+		return err
+	}
+	if int64(len(patch)) >= newLength {
+		b.literal(newOffset, newLength)
+		return nil
+	}
+
+	b.flushLiteral()
+	b.flushCopy()
+	b.w.WriteByte(opPatch)
+	b.writeInt(oldOffset, oldLength, newLength, int64(len(patch)))
+	b.w.Write(patch)
+	b.stats.PatchedBytes += newLength
+	b.stats.PatchSize += int64(len(patch))
+	return nil
+}
+
+func (b *builder) flushLiteral() {
+	if b.literalLength == 0 || b.err != nil {
+		b.literalLength = 0
+		return
+	}
+	b.w.WriteByte(opData)
+	b.writeInt(b.literalLength)
+	if _, err := io.Copy(b.w, io.NewSectionReader(b.updated, b.literalOffset, b.literalLength)); err != nil && b.err == nil {
+		b.err = err
+	}
+	b.stats.LiteralBytes += b.literalLength
+	b.literalLength = 0
+}
+
+func (b *builder) flushCopy() {
+	if b.copyLength == 0 {
+		return
+	}
+	b.w.WriteByte(opCopy)
+	b.writeInt(b.copyOffset, b.copyLength)
+	b.stats.CopiedBytes += b.copyLength
+	b.copyLength = 0
+}
+
+func (b *builder) end() {
+	b.flushLiteral()
+	b.flushCopy()
+	b.w.WriteByte(opEnd)
+}
+
+// Apply 将差分包应用到旧更新包上，把新更新包写入w。
+// 调用方应在应用前校验差分包的SHA-256，应用后校验新更新包的SHA-256
+func Apply(w io.Writer, old io.ReaderAt, patch io.Reader) error {
+	r := bufio.NewReader(patch)
+	header := make([]byte, len(magic))
+	if _, err := io.ReadFull(r, header); err != nil || string(header) != magic {
+		return errors.New("不是有效的差分包")
+	}
+	newSize, err := readInt(r)
+	if err != nil {
+		return err
+	}
+
+	written := int64(0)
+	for {
+		op, err := r.ReadByte()
+		if err != nil {
+			return fmt.Errorf("差分包不完整: %w", err)
+		}
+
+		switch op {
+		case opCopy:
+			offset, length, err := readInts2(r)
+			if err != nil {
+				return err
+			}
+			if length > newSize-written {
+				return errors.New("差分包已损坏")
+			}
+			n, err := io.Copy(w, io.NewSectionReader(old, offset, length))
+			if err != nil {
+				return err
+			}
+			if n != length {
+				return errors.New("旧更新包的内容不完整")
+			}
+			written += n
+		case opData:
+			length, err := readInt(r)
+			if err != nil {
+				return err
+			}
+			if length > newSize-written {
+				return errors.New("差分包已损坏")
+			}
+			n, err := io.CopyN(w, r, length)
+			if err != nil {
+				return fmt.Errorf("差分包不完整: %w", err)
+			}
+			written += n
+		case opPatch:
+			oldOffset, oldLength, err := readInts2(r)
+			if err != nil {
+				return err
+			}
+			newLength, patchLength, err := readInts2(r)
+			if err != nil {
+				return err
+			}
+			if newLength > newSize-written || patchLength > newLength {
+				return errors.New("差分包已损坏")
+			}
+			oldData := make([]byte, oldLength)
+			if _, err := old.ReadAt(oldData, oldOffset); err != nil {
+				return fmt.Errorf("旧更新包的内容不完整: %w", err)
+			}
+			patchData := make([]byte, patchLength)
+			if _, err := io.ReadFull(r, patchData); err != nil {
+				return fmt.Errorf("差分包不完整: %w", err)
+			}
+			newData, err := bspatch.Bytes(oldData, patchData)
+			if err != nil {
+				return err
+			}
+			if int64(len(newData)) != newLength {
+				return errors.New("差分包已损坏")
+			}
+			if _, err := w.Write(newData); err != nil {
+				return err
+			}
+			written += newLength
+		case opEnd:
+			if written != newSize {
+				return fmt.Errorf("生成的更新包大小为 %d 字节，期望 %d 字节", written, newSize)
+			}
+			return nil
+		default:
+			return errors.New("差分包已损坏")
+		}
+	}
+}
+
+func readInt(r io.Reader) (int64, error) {
+	var buf [8]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return 0, fmt.Errorf("差分包不完整: %w", err)
+	}
+	v := binary.BigEndian.Uint64(buf[:])
+	if v > 1<<62 {
+		return 0, errors.New("差分包已损坏")
+	}
+	return int64(v), nil
+}
+
+func readInts2(r io.Reader) (int64, int64, error) {
+	a, err := readInt(r)
+	if err != nil {
+		return 0, 0, err
+	}
+	b, err := readInt(r)
+	return a, b, err
+}
@@ -0,0 +1,122 @@
+package zipdelta
+
+import (
+	"archive/zip"
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+type testFile struct {
+	name   string
+	data   []byte
+	method uint16
+}
+
+func buildZip(t *testing.T, files []testFile) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, file := range files {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: file.name, Method: file.method})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(file.data)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func randomData(seed int64, size int) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+// 生成差分包并应用，结果应与新更新包完全一致
+func roundTrip(t *testing.T, oldZip []byte, newZip []byte, maxMemory int64) (Stats, []byte) {
+	t.Helper()
+	var patch bytes.Buffer
+	stats, err := Build(&patch, bytes.NewReader(oldZip), int64(len(oldZip)), bytes.NewReader(newZip), int64(len(newZip)), maxMemory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := Apply(&out, bytes.NewReader(oldZip), bytes.NewReader(patch.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), newZip) {
+		t.Fatal("应用差分包后的内容与新更新包不一致")
+	}
+	return stats, patch.Bytes()
+}
+
+func TestBuildAndApply(t *testing.T) {
+	unchanged := randomData(1, 256<<10)
+	changed := randomData(2, 64<<10)
+	modified := append([]byte(nil), changed...)
+	copy(modified[1000:], "修改后的内容")
+
+	oldZip := buildZip(t, []testFile{
+		{"Content/Paks/base.pak", unchanged, zip.Store},
+		{"Content/Paks/patch.pak", changed, zip.Store},
+		{"Content/config.ini", []byte("version=1\n"), zip.Deflate},
+		{"Content/removed.txt", []byte("removed"), zip.Deflate},
+	})
+	newZip := buildZip(t, []testFile{
+		{"Content/Paks/base.pak", unchanged, zip.Store},
+		{"Content/Paks/patch.pak", modified, zip.Store},
+		{"Content/config.ini", []byte("version=2\n"), zip.Deflate},
+		{"Content/added.txt", []byte("added"), zip.Deflate},
+	})
+
+	stats, patch := roundTrip(t, oldZip, newZip, 512<<20)
+	if stats.CopiedBytes < int64(len(unchanged)) {
+		t.Errorf("未变化的条目应从旧更新包复制，只复制了 %d 字节", stats.CopiedBytes)
+	}
+	if stats.PatchedBytes < int64(len(modified)) {
+		t.Errorf("变化的条目应生成补丁，只有 %d 字节", stats.PatchedBytes)
+	}
+	if stats.Changed != 3 {
+		t.Errorf("变化的条目数为 %d，期望3", stats.Changed)
+	}
+	if len(patch) > len(newZip)/10 {
+		t.Errorf("差分包 %d 字节，新更新包 %d 字节", len(patch), len(newZip))
+	}
+}
+
+// 超过内存限制的变化条目直接写入，仍然生成正确的差分包
+func TestBuildWritesLargeEntries(t *testing.T) {
+	oldZip := buildZip(t, []testFile{{"a.pak", randomData(3, 64<<10), zip.Store}})
+	newZip := buildZip(t, []testFile{{"a.pak", randomData(4, 64<<10), zip.Store}})
+
+	stats, _ := roundTrip(t, oldZip, newZip, DiffMemory(1<<10, 1<<10))
+	if stats.PatchedBytes != 0 || stats.LiteralBytes < 64<<10 {
+		t.Errorf("超过内存限制的条目应直接写入: %+v", stats)
+	}
+}
+
+func TestApplyRejectsCorruptPatch(t *testing.T) {
+	data := randomData(5, 16<<10)
+	oldZip := buildZip(t, []testFile{{"a.pak", data, zip.Store}})
+	newZip := buildZip(t, []testFile{{"a.pak", data, zip.Store}, {"b.txt", []byte("b"), zip.Store}})
+
+	var patch bytes.Buffer
+	if _, err := Build(&patch, bytes.NewReader(oldZip), int64(len(oldZip)), bytes.NewReader(newZip), int64(len(newZip)), 512<<20); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, corrupt := range map[string][]byte{
+		"魔数错误": append([]byte("BSDIFF40"), patch.Bytes()[8:]...),
+		"被截断":  patch.Bytes()[:patch.Len()-1],
+		"空文件":  nil,
+	} {
+		var out bytes.Buffer
+		if err := Apply(&out, bytes.NewReader(oldZip), bytes.NewReader(corrupt)); err == nil {
+			t.Errorf("%s的差分包应返回错误", name)
+		}
+	}
+}
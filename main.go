@@ -1,20 +1,32 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"hotupdate/app/controllers"
+	"hotupdate/app/metrics"
+	"hotupdate/app/models"
+	"hotupdate/app/signing"
+	"hotupdate/app/storage"
+	"hotupdate/app/store"
+	"hotupdate/app/utils"
 	"io"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
-	"path/filepath"
+	"os/signal"
+	"runtime"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
 )
 
 // Config 服务器配置
@@ -23,20 +35,33 @@ type Config struct {
 		Port      int    `json:"port"`
 		Host      string `json:"host"`
 		DebugMode bool   `json:"debugMode"`
+		// 信任的反向代理地址或网段。只有来自这些地址的请求才按X-Forwarded-For确定客户端IP，
+		// 默认不信任任何代理，避免客户端伪造请求头绕过按IP的限流
+		TrustedProxies []string `json:"trustedProxies"`
+		// 指标接口的访问控制：抓取令牌或单独的监听地址，都未设置时只有已登录的全局所有者可以访问
+		Metrics metrics.Config `json:"metrics"`
 	} `json:"server"`
 	Storage struct {
 		UploadDir string `json:"uploadDir"`
 		LogDir    string `json:"logDir"`
+		storage.Config
+		Metadata store.Config `json:"metadata"` // 元数据存储配置
 	} `json:"storage"`
 	Version struct {
 		InitialVersion            string `json:"initialVersion"`
 		InitialVersionName        string `json:"initialVersionName"`
 		InitialVersionDescription string `json:"initialVersionDescription"`
 	} `json:"version"`
+	// 管理员账号仅在用户列表为空时作为初始全局所有者导入
 	Security struct {
-		AdminUsername string `json:"adminUsername"`
-		AdminPassword string `json:"adminPassword"`
+		AdminUsername     string `json:"adminUsername"`
+		AdminPasswordHash string `json:"adminPasswordHash"` // bcrypt哈希，可通过 -hash-password 参数生成
+		AdminPassword     string `json:"adminPassword"`     // 已废弃：明文密码，只设置此字段时拒绝启动
+		SessionTTLHours   int    `json:"sessionTTLHours"`
+		// 更新清单签名密钥，轮换时先添加新密钥并切换activeKeyId，旧密钥保留到客户端不再需要
+		Signing signing.Config `json:"signing"`
 	} `json:"security"`
+	Log  utils.LogConfig `json:"log"` // 日志级别、轮转和保留设置
 	Apps []struct {
 		ID          string `json:"id"`
 		Name        string `json:"name"`
@@ -45,21 +70,58 @@ type Config struct {
 }
 
 var (
-	port       int
-	uploadDir  string
-	logDir     string
-	configPath string
-	debug      bool
-	config     Config
+	port         int
+	uploadDir    string
+	logDir       string
+	configPath   string
+	debug        bool
+	hashPassword string
+	genKey       bool
+	config       Config
+
+	metadataStore store.Store
+
+	// 日志系统初始化之前产生的日志，初始化后再输出，使其写入日志文件并使用配置的格式
+	pendingLogs []slog.Record
 )
 
+// 记录一条日志，等日志系统初始化后再输出，保留产生日志的时间和位置
+func logLater(level slog.Level, msg string, args ...any) {
+	var pcs [1]uintptr
+	runtime.Callers(2, pcs[:])
+	record := slog.NewRecord(time.Now(), level, msg, pcs[0])
+	record.Add(args...)
+	pendingLogs = append(pendingLogs, record)
+}
+
 func main() {
 	initConfig()
 
+	// 仅生成密码哈希后退出
+	if hashPassword != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(hashPassword), bcrypt.DefaultCost)
+		if err != nil {
+			log.Fatalf("生成密码哈希失败: %v", err)
+		}
+		fmt.Println(string(hash))
+		return
+	}
+
+	// 仅生成签名密钥后退出
+	if genKey {
+		privateKey, publicKey, err := signing.GenerateKey()
+		if err != nil {
+			log.Fatalf("生成签名密钥失败: %v", err)
+		}
+		fmt.Printf("privateKey: %s\npublicKey:  %s\n", privateKey, publicKey)
+		return
+	}
+
 	// 初始化日志
 	initLogger()
+	defer utils.CloseLogger()
 
-	log.Println("正在启动多项目热更新服务器...")
+	slog.Info("正在启动多项目热更新服务器...")
 	startTime := time.Now()
 
 	// 确保目录存在
@@ -69,12 +131,16 @@ func main() {
 	// 设置Gin模式
 	if debug || config.Server.DebugMode {
 		gin.SetMode(gin.DebugMode)
-		log.Println("以调试模式运行")
+		slog.Info("以调试模式运行")
 	} else {
 		gin.SetMode(gin.ReleaseMode)
-		log.Println("以生产模式运行")
+		slog.Info("以生产模式运行")
 	}
 
+	// 打开元数据存储
+	metadataStore = openMetadataStore()
+	defer metadataStore.Close()
+
 	// 设置Gin路由
 	r := setupRouter()
 
@@ -82,18 +148,64 @@ func main() {
 	portToUse := strconv.Itoa(port)
 
 	// 启动前准备所需时间
-	log.Printf("服务器准备完成，耗时 %v", time.Since(startTime))
+	slog.Info("服务器准备完成", "elapsed", time.Since(startTime).String())
 
 	// 启动提示
 	hostAddr := fmt.Sprintf(":%s", portToUse)
-	log.Printf("多项目热更新服务器已启动，监听 %s", hostAddr)
-	log.Printf("管理界面: http://localhost:%s/admin", portToUse)
-	log.Printf("健康检查: http://localhost:%s/health", portToUse)
+	slog.Info("多项目热更新服务器已启动", "addr", hostAddr,
+		"admin", fmt.Sprintf("http://localhost:%s/admin", portToUse),
+		"health", fmt.Sprintf("http://localhost:%s/health", portToUse))
+
+	// 在单独的地址上提供指标
+	metricsServer := startMetricsServer()
+
+	// 启动服务器，收到SIGINT或SIGTERM后停止接收新请求，等待进行中的请求完成
+	server := &http.Server{Addr: hostAddr, Handler: r}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		<-ctx.Done()
+		slog.Info("正在停止服务器...")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			slog.Error("停止服务器失败", "error", err)
+		}
+		if metricsServer != nil {
+			metricsServer.Shutdown(shutdownCtx)
+		}
+	}()
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("启动服务器失败", "error", err)
+		os.Exit(1)
+	}
+	<-stopped
 
-	// 启动服务器
-	if err := r.Run(hostAddr); err != nil {
-		log.Fatalf("启动服务器失败: %v", err)
+	// 写入内存中尚未保存的统计数据和客户端上报，之后才关闭元数据存储
+	controllers.FlushAnalytics()
+	slog.Info("服务器已停止")
+}
+
+// 配置了单独的指标监听地址时，在该地址上提供/metrics，未配置时返回nil
+func startMetricsServer() *http.Server {
+	cfg := config.Server.Metrics
+	if cfg.Listen == "" {
+		return nil
 	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.TokenHandler(cfg.Token))
+	server := &http.Server{Addr: cfg.Listen, Handler: mux}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("启动指标服务失败", "addr", cfg.Listen, "error", err)
+			os.Exit(1)
+		}
+	}()
+	slog.Info("指标服务已启动", "addr", cfg.Listen)
+	return server
 }
 
 // 初始化配置
@@ -104,6 +216,8 @@ func initConfig() {
 	flag.StringVar(&uploadDir, "upload", "./uploads", "上传目录")
 	flag.StringVar(&logDir, "log", "./logs", "日志目录")
 	flag.BoolVar(&debug, "debug", false, "调试模式")
+	flag.StringVar(&hashPassword, "hash-password", "", "生成管理员密码的bcrypt哈希并退出")
+	flag.BoolVar(&genKey, "gen-signing-key", false, "生成Ed25519清单签名密钥对并退出")
 	flag.Parse()
 
 	// 尝试从环境变量读取配置，环境变量优先级高于命令行参数
@@ -131,7 +245,7 @@ func initConfig() {
 
 	// 加载配置文件
 	if err := loadConfig(); err != nil {
-		log.Printf("无法加载配置文件: %v, 使用默认配置", err)
+		logLater(slog.LevelWarn, "无法加载配置文件，使用默认配置", "error", err)
 	}
 
 	// 环境变量可以覆盖配置文件中的设置
@@ -142,6 +256,10 @@ func initConfig() {
 	if envDebugMode := os.Getenv("DEBUG_MODE"); envDebugMode != "" {
 		config.Server.DebugMode = (envDebugMode == "true" || envDebugMode == "1")
 	}
+
+	if envLogLevel := os.Getenv("LOG_LEVEL"); envLogLevel != "" {
+		config.Log.Level = envLogLevel
+	}
 }
 
 // 加载配置文件
@@ -162,21 +280,21 @@ func loadConfig() error {
 		return err
 	}
 
-	log.Printf("成功加载配置文件: %s", configPath)
+	logLater(slog.LevelInfo, "成功加载配置文件", "path", configPath)
 
 	// 如果配置文件中设置了这些值，则覆盖命令行参数
 	if config.Storage.UploadDir != "" {
 		uploadDir = config.Storage.UploadDir
-		log.Printf("使用配置文件中的上传目录: %s", uploadDir)
+		logLater(slog.LevelInfo, "使用配置文件中的上传目录", "dir", uploadDir)
 	}
 	if config.Storage.LogDir != "" {
 		logDir = config.Storage.LogDir
-		log.Printf("使用配置文件中的日志目录: %s", logDir)
+		logLater(slog.LevelInfo, "使用配置文件中的日志目录", "dir", logDir)
 	}
 
 	// 预先创建配置文件中指定的应用
 	if len(config.Apps) > 0 {
-		log.Printf("从配置文件中加载 %d 个预定义应用", len(config.Apps))
+		logLater(slog.LevelInfo, "从配置文件中加载预定义应用", "count", len(config.Apps))
 		// 稍后在初始化应用列表时处理
 	}
 
@@ -187,99 +305,79 @@ func loadConfig() error {
 func ensureDir(dir string) {
 	dir = strings.TrimSpace(dir)
 	if dir == "" {
-		log.Println("警告: 目录路径为空")
+		slog.Warn("目录路径为空")
 		return
 	}
 
 	if _, err := os.Stat(dir); os.IsNotExist(err) {
 		err = os.MkdirAll(dir, 0755)
 		if err != nil {
-			log.Fatalf("无法创建目录 %s: %v", dir, err)
+			slog.Error("无法创建目录", "dir", dir, "error", err)
+			os.Exit(1)
 		}
-		log.Printf("已创建目录: %s", dir)
+		slog.Info("已创建目录", "dir", dir)
 	} else {
-		log.Printf("目录已存在: %s", dir)
+		slog.Debug("目录已存在", "dir", dir)
 	}
 }
 
-// 初始化日志系统
+// 初始化日志系统，日志目录不可用时只输出到标准输出
 func initLogger() {
-	// 确保日志目录存在
-	if _, err := os.Stat(logDir); os.IsNotExist(err) {
-		if err := os.MkdirAll(logDir, 0755); err != nil {
-			// 如果无法创建日志目录，继续使用标准输出
-			log.Printf("无法创建日志目录: %v, 将使用标准输出", err)
-			return
-		}
+	if _, err := utils.ParseLogLevel(config.Log.Level); err != nil {
+		logLater(slog.LevelWarn, "日志级别无效，使用info级别", "error", err)
+		config.Log.Level = ""
 	}
-
-	// 生成带时间戳的日志文件名
-	timestamp := time.Now().Format("2006-01-02")
-	logFile := filepath.Join(logDir, fmt.Sprintf("server_%s.log", timestamp))
-
-	f, err := os.OpenFile(logFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		log.Printf("无法打开日志文件: %v, 将使用标准输出", err)
-		return
+	if err := utils.InitLogger(logDir, config.Log); err != nil {
+		slog.Warn("日志文件不可用，只输出到标准输出", "error", err)
+	} else {
+		slog.Info("日志系统已初始化", "dir", logDir, "log_level", utils.LogLevel.Level().String())
 	}
 
-	// 同时输出到文件和控制台
-	log.SetOutput(io.MultiWriter(f, os.Stdout))
-	log.SetPrefix("[热更新服务] ")
-	log.SetFlags(log.Ldate | log.Ltime | log.Lshortfile)
-
-	log.Println("日志系统已初始化，日志文件:", logFile)
+	ctx := context.Background()
+	for _, record := range pendingLogs {
+		if slog.Default().Enabled(ctx, record.Level) {
+			slog.Default().Handler().Handle(ctx, record)
+		}
+	}
+	pendingLogs = nil
 }
 
 // 设置Gin路由
 func setupRouter() *gin.Engine {
-	r := gin.Default()
-
-	// 设置Gin恢复中间件
-	r.Use(gin.Recovery())
-
-	// 添加请求日志中间件
-	r.Use(func(c *gin.Context) {
-		// 开始时间
-		startTime := time.Now()
-
-		// 处理请求
-		c.Next()
-
-		// 结束时间
-		endTime := time.Now()
-
-		// 请求处理时间
-		latencyTime := endTime.Sub(startTime)
-
-		// 请求方式
-		reqMethod := c.Request.Method
-
-		// 请求路由
-		reqUri := c.Request.RequestURI
-
-		// 状态码
-		statusCode := c.Writer.Status()
-
-		// 请求IP
-		clientIP := c.ClientIP()
+	r := gin.New()
+	if err := r.SetTrustedProxies(config.Server.TrustedProxies); err != nil {
+		slog.Error("信任的代理地址无效", "error", err)
+		os.Exit(1)
+	}
 
-		// 日志格式
-		log.Printf("| %3d | %13v | %15s | %-7s | %s",
-			statusCode,
-			latencyTime,
-			clientIP,
-			reqMethod,
-			reqUri,
-		)
-	})
+	// 请求ID，之后的中间件和处理函数记录的日志都带有该ID
+	r.Use(controllers.RequestID())
+
+	// 处理函数panic时记录错误和调用栈并返回500
+	r.Use(gin.CustomRecoveryWithWriter(io.Discard, func(c *gin.Context, recovered any) {
+		stack := make([]byte, 64<<10)
+		slog.ErrorContext(c.Request.Context(), "处理请求时发生panic", "panic", recovered, "stack", string(stack[:runtime.Stack(stack, false)]))
+		c.AbortWithStatus(http.StatusInternalServerError)
+	}))
+
+	// 请求指标和访问日志
+	r.Use(metrics.Instrument())
+
+	// Prometheus指标。设置了单独的监听地址时只在该地址提供
+	switch {
+	case config.Server.Metrics.Listen != "":
+	case config.Server.Metrics.Token != "":
+		r.GET("/metrics", gin.WrapH(metrics.TokenHandler(config.Server.Metrics.Token)))
+	default:
+		r.GET("/metrics", controllers.RoleRequired(models.RoleOwner), gin.WrapH(metrics.Handler()))
+	}
 
 	// 静态文件
 	r.Static("/static", "./app/static")
 	r.LoadHTMLGlob("app/views/templates/*")
 
 	// 管理界面
-	r.GET("/admin", func(c *gin.Context) {
+	r.GET("/admin", controllers.PageAuthRequired(), func(c *gin.Context) {
 		c.HTML(http.StatusOK, "admin.html", gin.H{
 			"title": "多项目热更新管理系统",
 		})
@@ -290,8 +388,68 @@ func setupRouter() *gin.Engine {
 		c.Redirect(http.StatusFound, "/admin")
 	})
 
+	// 设置认证控制器
+	controllers.SetupAuthController(
+		r,
+		metadataStore,
+		config.Security.AdminUsername,
+		adminPasswordHash(),
+		time.Duration(config.Security.SessionTTLHours)*time.Hour,
+	)
+
+	// 设置签名控制器
+	signer, err := signing.New(config.Security.Signing)
+	if err != nil {
+		slog.Error("初始化清单签名失败", "error", err)
+		os.Exit(1)
+	}
+	if signer == nil {
+		slog.Warn("未配置清单签名密钥，检查更新的响应中不包含签名清单")
+	}
+	controllers.SetupSigningController(r, signer)
+
+	// 初始化版本文件存储
+	fileStorage, err := storage.New(config.Storage.Config, uploadDir)
+	if err != nil {
+		slog.Error("初始化存储后端失败", "error", err)
+		os.Exit(1)
+	}
+	slog.Info("版本文件存储", "storage", fmt.Sprint(fileStorage))
+
 	// 设置版本控制器
-	controllers.SetupVersionController(r, uploadDir)
+	controllers.SetupVersionController(r, uploadDir, metadataStore, fileStorage)
 
 	return r
 }
+
+// 打开元数据存储。首次使用bolt数据库时，从上传目录中已有的JSON文件导入数据
+func openMetadataStore() store.Store {
+	s, err := store.New(config.Storage.Metadata, uploadDir)
+	if err != nil {
+		slog.Error("打开元数据存储失败", "error", err)
+		os.Exit(1)
+	}
+
+	if boltStore, ok := s.(*store.BoltStore); ok && boltStore.Empty() {
+		if err := models.ImportMetadata(boltStore, store.NewJSONStore(uploadDir)); err != nil {
+			slog.Error("从JSON文件导入元数据失败", "error", err)
+			os.Exit(1)
+		}
+		slog.Info("已从JSON文件导入元数据到bolt数据库")
+	}
+
+	return s
+}
+
+// 获取管理员密码哈希。配置文件中只有明文密码adminPassword时拒绝启动，
+// 旧版本的默认配置附带了公开的明文密码，不能把它导入为所有者的密码
+func adminPasswordHash() string {
+	if config.Security.AdminPassword != "" {
+		if config.Security.AdminPasswordHash == "" {
+			slog.Error("配置文件中使用了明文密码 adminPassword，已不再支持。请使用 -hash-password 生成哈希并改用 adminPasswordHash，或删除该字段以生成一次性初始密码")
+			os.Exit(1)
+		}
+		slog.Warn("配置文件中的明文密码 adminPassword 已被忽略，请删除该字段")
+	}
+	return config.Security.AdminPasswordHash
+}